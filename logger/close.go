@@ -0,0 +1,65 @@
+package logger
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"go.uber.org/multierr"
+)
+
+var (
+	closersLock sync.Mutex
+	closers     []io.Closer
+)
+
+// RegisterCloser registers c, e.g. the Kafka writer of a handler, to be
+// closed by Close. It is also flushed by Flush if it has a Flush() error method.
+func RegisterCloser(c io.Closer) {
+	closersLock.Lock()
+	defer closersLock.Unlock()
+	closers = append(closers, c)
+}
+
+// flushClosers flushes the registered writers that buffer writes.
+func flushClosers() error {
+	closersLock.Lock()
+	pending := closers
+	closersLock.Unlock()
+
+	var err error
+	for _, c := range pending {
+		if f, ok := c.(interface{ Flush() error }); ok {
+			err = multierr.Append(err, f.Flush())
+		}
+	}
+	return err
+}
+
+// Close flushes the logger then closes the registered writers, draining the
+// records they buffer, in registration order. It gives up waiting, on the
+// flush as on the writers, once ctx is done. Programs are expected to shut
+// down with
+//
+//	defer logger.Close(ctx)
+func Close(ctx context.Context) error {
+	closersLock.Lock()
+	pending := closers
+	closers = nil
+	closersLock.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		err := Flush()
+		for _, c := range pending {
+			err = multierr.Append(err, c.Close())
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,99 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeWriter buffers writes until closed, as an async Kafka writer does
+type fakeWriter struct {
+	mu      sync.Mutex
+	pending []string
+	written []string
+	closed  int
+	block   chan struct{}
+}
+
+func (w *fakeWriter) Write(msg string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending = append(w.pending, msg)
+}
+
+func (w *fakeWriter) Close() error {
+	if w.block != nil {
+		<-w.block
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.written = append(w.written, w.pending...)
+	w.pending = nil
+	w.closed++
+	return nil
+}
+
+func TestClose(t *testing.T) {
+	resetSingleton(t)
+	Singleton()
+
+	first, second := &fakeWriter{}, &fakeWriter{}
+	RegisterCloser(first)
+	RegisterCloser(second)
+	first.Write("a")
+	first.Write("b")
+
+	if err := Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if first.closed != 1 || second.closed != 1 {
+		t.Errorf("expected every writer closed once, got %d and %d", first.closed, second.closed)
+	}
+	if len(first.pending) != 0 || len(first.written) != 2 {
+		t.Errorf("expected the pending writes drained, got pending %v written %v", first.pending, first.written)
+	}
+
+	if err := Close(context.Background()); err != nil || first.closed != 1 {
+		t.Errorf("expected a second Close to close nothing, got %v and %d closes", err, first.closed)
+	}
+}
+
+func TestCloseDeadline(t *testing.T) {
+	resetSingleton(t)
+	Singleton()
+
+	stuck := &fakeWriter{block: make(chan struct{})}
+	defer close(stuck.block)
+	RegisterCloser(stuck)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline to be exceeded, got %v", err)
+	}
+}
+
+func TestCloseExpiredStalledKafka(t *testing.T) {
+	resetSingleton(t)
+	Singleton()
+
+	stuck := &fakeWriter{block: make(chan struct{})}
+	defer close(stuck.block)
+	ks := &kafkaSink{writer: stuck, timeout: kafkaFlushTimeout, idle: make(chan struct{})}
+	close(ks.idle)
+	// a record is never delivered, stalling the flush of the writer
+	ks.add(1)
+	RegisterCloser(ks)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	if err := Close(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the context error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected Close to return once ctx is done, took %s", elapsed)
+	}
+}
@@ -0,0 +1,120 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ContextExtractor returns the attributes to log from a context, e.g. the
+// trace_id of the current span
+type ContextExtractor func(ctx context.Context) []slog.Attr
+
+var (
+	extractorsLock sync.RWMutex
+	extractors     []ContextExtractor
+)
+
+// AddContextExtractor registers an extractor run on the context of every
+// *Context logging call. Extractors run in registration order.
+func AddContextExtractor(extractor ContextExtractor) {
+	extractorsLock.Lock()
+	defer extractorsLock.Unlock()
+	extractors = append(extractors, extractor)
+}
+
+// TraceContextExtractor is a ContextExtractor returning the trace_id and
+// span_id of the span carried by ctx, if any.
+func TraceContextExtractor(ctx context.Context) []slog.Attr {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsValid() {
+		return nil
+	}
+	return []slog.Attr{
+		slog.String("trace_id", spanContext.TraceID().String()),
+		slog.String("span_id", spanContext.SpanID().String()),
+	}
+}
+
+// contextAttrs returns the attributes to log for ctx and keysAndValues.
+// Extractors are skipped when ctx is nil, which is replaced by context.Background.
+func contextAttrs(ctx context.Context, keysAndValues []interface{}) (context.Context, []interface{}) {
+	attrs := []interface{}{slog.Group("", keysAndValues...)}
+	if ctx == nil {
+		return context.Background(), attrs
+	}
+	extractorsLock.RLock()
+	defer extractorsLock.RUnlock()
+	for _, extract := range extractors {
+		for _, attr := range extract(ctx) {
+			attrs = append(attrs, attr)
+		}
+	}
+	return ctx, attrs
+}
+
+// DebugContext logs msg and keysAndValues at debug level with ctx.
+//
+//go:noinline
+func DebugContext(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	ctx, attrs := contextAttrs(ctx, keysAndValues)
+	klogger.logger.Log(ctx, slog.LevelDebug, msg, attrs...)
+}
+
+// DebugContext logs msg and keysAndValues at debug level with ctx.
+//
+//go:noinline
+func (k *Klogger) DebugContext(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	ctx, attrs := contextAttrs(ctx, keysAndValues)
+	k.logger.Log(ctx, slog.LevelDebug, msg, attrs...)
+}
+
+// InfoContext logs msg and keysAndValues at info level with ctx.
+//
+//go:noinline
+func InfoContext(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	ctx, attrs := contextAttrs(ctx, keysAndValues)
+	klogger.logger.Log(ctx, slog.LevelInfo, msg, attrs...)
+}
+
+// InfoContext logs msg and keysAndValues at info level with ctx.
+//
+//go:noinline
+func (k *Klogger) InfoContext(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	ctx, attrs := contextAttrs(ctx, keysAndValues)
+	k.logger.Log(ctx, slog.LevelInfo, msg, attrs...)
+}
+
+// WarnContext logs msg and keysAndValues at warning level with ctx.
+//
+//go:noinline
+func WarnContext(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	ctx, attrs := contextAttrs(ctx, keysAndValues)
+	klogger.logger.Log(ctx, slog.LevelWarn, msg, attrs...)
+}
+
+// WarnContext logs msg and keysAndValues at warning level with ctx.
+//
+//go:noinline
+func (k *Klogger) WarnContext(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	ctx, attrs := contextAttrs(ctx, keysAndValues)
+	k.logger.Log(ctx, slog.LevelWarn, msg, attrs...)
+}
+
+// ErrorContext logs msg and keysAndValues at error level with ctx.
+//
+//go:noinline
+func ErrorContext(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	ctx, attrs := contextAttrs(ctx, keysAndValues)
+	klogger.logger.Log(ctx, slog.LevelError, msg, attrs...)
+}
+
+// ErrorContext logs msg and keysAndValues at error level with ctx.
+//
+//go:noinline
+func (k *Klogger) ErrorContext(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	ctx, attrs := contextAttrs(ctx, keysAndValues)
+	k.logger.Log(ctx, slog.LevelError, msg, attrs...)
+}
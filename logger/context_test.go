@@ -0,0 +1,98 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+type traceKey struct{}
+
+// ctxHandler is a MemoryHandler also keeping the context of every record.
+type ctxHandler struct {
+	*MemoryHandler
+	contexts *[]context.Context
+}
+
+func (h ctxHandler) Handle(ctx context.Context, r slog.Record) error {
+	*h.contexts = append(*h.contexts, ctx)
+	return h.MemoryHandler.Handle(ctx, r)
+}
+
+func TestContextLogging(t *testing.T) {
+	previous := extractors
+	t.Cleanup(func() { extractors = previous })
+	extractors = nil
+
+	var order []string
+	AddContextExtractor(func(ctx context.Context) []slog.Attr {
+		order = append(order, "trace")
+		if id, ok := ctx.Value(traceKey{}).(string); ok {
+			return []slog.Attr{slog.String("trace_id", id)}
+		}
+		return nil
+	})
+	AddContextExtractor(func(ctx context.Context) []slog.Attr {
+		order = append(order, "tenant")
+		return []slog.Attr{slog.String("tenant", "acme")}
+	})
+
+	handler := ctxHandler{MemoryHandler: NewMemoryHandler(), contexts: &[]context.Context{}}
+	l := &Klogger{}
+	l.SetLogger(slog.New(handler))
+
+	ctx := context.WithValue(context.Background(), traceKey{}, "abc123")
+	l.InfoContext(ctx, "handled", "status", 200)
+	attrs := lastAttrs(handler.MemoryHandler)
+	want := map[string]any{"trace_id": "abc123", "tenant": "acme", "status": int64(200)}
+	if !reflect.DeepEqual(attrs, want) {
+		t.Errorf("expected %v, got %v", want, attrs)
+	}
+	if got := (*handler.contexts)[0]; got != ctx {
+		t.Errorf("expected the caller context to reach the handler")
+	}
+	if !reflect.DeepEqual(order, []string{"trace", "tenant"}) {
+		t.Errorf("expected extractors in registration order, got %v", order)
+	}
+
+	order = nil
+	l.ErrorContext(nil, "failed", "status", 500)
+	record, _ := handler.Last()
+	if record.Level != slog.LevelError {
+		t.Errorf("expected error level, got %v", record.Level)
+	}
+	if len(order) != 0 || !reflect.DeepEqual(record.Attrs, map[string]any{"status": int64(500)}) {
+		t.Errorf("expected extractors to be skipped for a nil context, ran %v, got %v", order, record.Attrs)
+	}
+}
+
+func TestTraceContext(t *testing.T) {
+	previous := extractors
+	t.Cleanup(func() { extractors = previous })
+	extractors = nil
+	resetSingleton(t)
+	if _, err := InitLogger(NewConfig(WithTraceContext())); err != nil {
+		t.Fatal(err)
+	}
+	handler := NewMemoryHandler()
+	SetHandler(handler)
+
+	InfoContext(context.Background(), "no span")
+	if attrs := lastAttrs(handler); len(attrs) != 0 {
+		t.Errorf("expected no trace attributes without span, got %v", attrs)
+	}
+
+	ctx, span := sdktrace.NewTracerProvider().Tracer("logger").Start(context.Background(), "operation")
+	defer span.End()
+	InfoContext(ctx, "in span")
+	attrs := lastAttrs(handler)
+	if want := span.SpanContext().TraceID().String(); attrs["trace_id"] != want {
+		t.Errorf("expected trace_id %s, got %v", want, attrs)
+	}
+	if want := span.SpanContext().SpanID().String(); attrs["span_id"] != want {
+		t.Errorf("expected span_id %s, got %v", want, attrs)
+	}
+}
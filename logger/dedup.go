@@ -0,0 +1,155 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// WithDedup returns an option to collapse consecutive records with the same
+// level and message within window, e.g. a persistence error failing in a tight
+// loop. The first record is logged and the repeats are summarized by a single
+// "(repeated N times)" line once the message changes, the window elapses or
+// the logger is flushed. The records let through are then sampled as usual.
+func WithDedup(window time.Duration) Option {
+	return func(c *Config) {
+		c.dedupWindow = window
+	}
+}
+
+// dedupKey identifies the records deduplicated together
+type dedupKey struct {
+	level   zapcore.Level
+	message string
+}
+
+// dedupState is shared by a dedupCore and the cores derived from it by With
+type dedupState struct {
+	window time.Duration
+
+	mu sync.Mutex
+	// last is the last record logged, start when it was
+	last  dedupKey
+	start time.Time
+	// repeated counts the suppressed repeats of last, summarized on core
+	repeated int
+	lastTime time.Time
+	core     zapcore.Core
+	// timer flushes the summary once the window of last elapses, gen telling
+	// a timer stopped too late from the current one
+	timer *time.Timer
+	gen   int
+}
+
+// dedupCore deduplicates the consecutive records of Core
+type dedupCore struct {
+	zapcore.Core
+	state *dedupState
+}
+
+// dedupCoreFor deduplicates the consecutive records of core within window.
+func dedupCoreFor(core zapcore.Core, window time.Duration) zapcore.Core {
+	return &dedupCore{Core: core, state: &dedupState{window: window}}
+}
+
+func (c *dedupCore) With(fields []zapcore.Field) zapcore.Core {
+	return &dedupCore{Core: c.Core.With(fields), state: c.state}
+}
+
+func (c *dedupCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *dedupCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	s := c.state
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := dedupKey{level: ent.Level, message: ent.Message}
+	if key == s.last && s.core != nil && ent.Time.Sub(s.start) < s.window {
+		s.repeated++
+		s.lastTime = ent.Time
+		if s.timer == nil {
+			gen := s.gen
+			s.timer = time.AfterFunc(s.window-ent.Time.Sub(s.start), func() { s.expire(gen) })
+		}
+		return nil
+	}
+	err := s.flush()
+	s.last, s.start, s.core = key, ent.Time, c.Core
+	if writeErr := writeThrough(c.Core, ent, fields); writeErr != nil {
+		err = writeErr
+	}
+	return err
+}
+
+// writeThrough writes ent to core through its Check, for the cores it wraps,
+// e.g. a sampler, to filter it.
+func writeThrough(core zapcore.Core, ent zapcore.Entry, fields []zapcore.Field) error {
+	ce := core.Check(ent, nil)
+	if ce == nil {
+		return nil
+	}
+	errs := &writeErrors{}
+	ce.ErrorOutput = errs
+	ce.Write(fields...)
+	return errs.err
+}
+
+// writeErrors collects the write errors a CheckedEntry reports
+type writeErrors struct {
+	err error
+}
+
+func (w *writeErrors) Write(p []byte) (int, error) {
+	w.err = errors.New(strings.TrimSpace(string(p)))
+	return len(p), nil
+}
+
+func (w *writeErrors) Sync() error { return nil }
+
+func (c *dedupCore) Sync() error {
+	c.state.mu.Lock()
+	err := c.state.flush()
+	c.state.mu.Unlock()
+	if syncErr := c.Core.Sync(); syncErr != nil {
+		err = syncErr
+	}
+	return err
+}
+
+// expire flushes the summary once the window elapsed, unless the timer of
+// gen was stopped meanwhile.
+func (s *dedupState) expire(gen int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if gen == s.gen {
+		_ = s.flush()
+	}
+}
+
+// flush writes the summary of the suppressed repeats, if any. Called with mu held.
+func (s *dedupState) flush() error {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+		s.gen++
+	}
+	if s.repeated == 0 {
+		return nil
+	}
+	ent := zapcore.Entry{
+		Level:   s.last.level,
+		Time:    s.lastTime,
+		Message: fmt.Sprintf("%s (repeated %d times)", s.last.message, s.repeated),
+	}
+	s.repeated = 0
+	return writeThrough(s.core, ent, nil)
+}
@@ -0,0 +1,192 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// fakeClock is a zapcore.Clock moved by hand
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) NewTicker(d time.Duration) *time.Ticker { return time.NewTicker(d) }
+
+func messages(logs *observer.ObservedLogs) []string {
+	var out []string
+	for _, e := range logs.AllUntimed() {
+		out = append(out, e.Level.String()+" "+e.Message)
+	}
+	return out
+}
+
+func TestDedupCore(t *testing.T) {
+	observed, logs := observer.New(zapcore.DebugLevel)
+	clock := &fakeClock{now: time.Now()}
+	log := zap.New(dedupCoreFor(observed, time.Minute), zap.WithClock(clock))
+
+	for i := 0; i < 5; i++ {
+		log.Error("persist failed", zap.Int("i", i))
+	}
+	// the level is part of the key
+	log.Warn("persist failed")
+	log.With(zap.String("k", "v")).Warn("persist failed")
+	log.Info("done")
+
+	want := []string{
+		"error persist failed",
+		"error persist failed (repeated 4 times)",
+		"warn persist failed",
+		"warn persist failed (repeated 1 times)",
+		"info done",
+	}
+	if got := messages(logs); !slices.Equal(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if i := logs.AllUntimed()[0].ContextMap()["i"]; i != int64(0) {
+		t.Errorf("expected the first record logged as is, got i=%v", i)
+	}
+}
+
+func TestDedupCoreFlush(t *testing.T) {
+	observed, logs := observer.New(zapcore.DebugLevel)
+	clock := &fakeClock{now: time.Now()}
+	log := zap.New(dedupCoreFor(observed, time.Minute), zap.WithClock(clock))
+
+	log.Error("persist failed")
+	log.Error("persist failed")
+	clock.now = clock.now.Add(time.Minute)
+	// the window elapsed, the record is logged again after the summary
+	log.Error("persist failed")
+	log.Error("persist failed")
+	log.Error("persist failed")
+	if err := log.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	log.Error("persist failed")
+
+	want := []string{
+		"error persist failed",
+		"error persist failed (repeated 1 times)",
+		"error persist failed",
+		"error persist failed (repeated 2 times)",
+	}
+	if got := messages(logs); !slices.Equal(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if got := logs.All()[3].Time; !got.Equal(clock.now) {
+		t.Errorf("expected the summary at the last repeat %s, got %s", clock.now, got)
+	}
+}
+
+func TestDedupCoreWindowExpiry(t *testing.T) {
+	observed, logs := observer.New(zapcore.DebugLevel)
+	log := zap.New(dedupCoreFor(observed, 50*time.Millisecond))
+
+	for i := 0; i < 3; i++ {
+		log.Error("persist failed")
+	}
+	if got := messages(logs); !slices.Equal(got, []string{"error persist failed"}) {
+		t.Fatalf("expected the repeats suppressed within the window, got %q", got)
+	}
+
+	// the summary is written once the window elapses, without another record
+	want := []string{"error persist failed", "error persist failed (repeated 2 times)"}
+	deadline := time.Now().Add(5 * time.Second)
+	for !slices.Equal(messages(logs), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %q after the window, got %q", want, messages(logs))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// a later record starts a new window
+	log.Error("persist failed")
+	if got := messages(logs); len(got) != 3 || got[2] != "error persist failed" {
+		t.Errorf("expected the record logged again after the summary, got %q", got)
+	}
+}
+
+func TestDedupCoreSampling(t *testing.T) {
+	bypass := zapcore.ErrorLevel
+	tests := []struct {
+		name   string
+		bypass *zapcore.Level
+		want   []string
+	}{
+		{"sampling", nil, []string{"info a", "info b", "error a", "error b"}},
+		{"sampling bypass", &bypass, []string{"info a", "info b", "error a", "error b", "error a", "error b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			observed, logs := observer.New(zapcore.DebugLevel)
+			sampled := sampleCore(observed, &zap.SamplingConfig{Initial: 1, Thereafter: 1000}, tt.bypass)
+			log := zap.New(dedupCoreFor(sampled, time.Minute))
+
+			// alternating messages are not deduplicated, only sampled
+			for _, msg := range []string{"a", "b", "a", "b"} {
+				log.Info(msg)
+			}
+			for _, msg := range []string{"a", "b", "a", "b"} {
+				log.Error(msg)
+			}
+			if got := messages(logs); !slices.Equal(got, tt.want) {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestInitLoggerDedupSampling(t *testing.T) {
+	resetSingleton(t)
+	path := filepath.Join(t.TempDir(), "pkg.log")
+	k, err := InitLogger(NewConfig(WithAlsoLogToStderr(false), WithRotatingFile(path, 10, 1, 0),
+		WithDedup(time.Minute), WithSampling(1, 1000), WithSamplingBypass(zapcore.ErrorLevel)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = k.config.fileSink.Stop()
+		_ = k.config.rotatingFile.Close()
+	})
+
+	log := k.config.zapLogger
+	for i := 0; i < 3; i++ {
+		log.Info("sampled-a")
+		log.Info("sampled-b")
+		log.Error("kept-a")
+		log.Error("kept-b")
+	}
+	if err := k.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for msg, want := range map[string]int{"sampled-a": 1, "sampled-b": 1, "kept-a": 3, "kept-b": 3} {
+		if got := strings.Count(string(data), `"`+msg+`"`); got != want {
+			t.Errorf("expected %s logged %d times, got %d in %q", msg, want, got, data)
+		}
+	}
+}
+
+func TestInitLoggerDedup(t *testing.T) {
+	resetSingleton(t)
+	if _, err := InitLogger(NewConfig(WithDedup(time.Second))); err != nil {
+		t.Fatal(err)
+	}
+	if klogger.config.dedupWindow != time.Second {
+		t.Errorf("expected a dedup window of 1s, got %s", klogger.config.dedupWindow)
+	}
+}
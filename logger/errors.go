@@ -0,0 +1,72 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/samber/oops"
+)
+
+// stackTracer is implemented by the errors of github.com/pkg/errors carrying a stack
+type stackTracer interface {
+	StackTrace() pkgerrors.StackTrace
+}
+
+// ErrorErr is a shim
+//
+//go:noinline
+func ErrorErr(msg string, err error, kv ...interface{}) {
+	klogger.ErrorErr(msg, err, kv...)
+}
+
+// ErrorErr logs msg at error level with err and kv as attributes. The context
+// carried by err is kept as structured attributes: the domain and the fields
+// of an oops error, and the stack of an oops or pkg/errors error. Other errors
+// only add their message.
+func (k *Klogger) ErrorErr(msg string, err error, kv ...interface{}) {
+	attrs := errorAttrs(err)
+	k.logger.Log(context.Background(), slog.LevelError, msg, append(attrs, k.config.mapValues(kv)...)...)
+}
+
+// errorAttrs returns the attributes describing err.
+func errorAttrs(err error) []interface{} {
+	if err == nil {
+		return nil
+	}
+	attrs := []interface{}{slog.String("error", err.Error())}
+
+	if o, ok := oops.AsOops(err); ok {
+		if domain := o.Domain(); domain != "" {
+			attrs = append(attrs, slog.String("domain", domain))
+		}
+		fields := o.Context()
+		keys := make([]string, 0, len(fields))
+		for key := range fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			attrs = append(attrs, slog.Any(key, fields[key]))
+		}
+		if stack := o.Stacktrace(); stack != "" {
+			attrs = append(attrs, slog.String("stacktrace", stack))
+		}
+		return attrs
+	}
+
+	// the innermost stack is the closest to the origin of the error
+	var stack pkgerrors.StackTrace
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if st, ok := e.(stackTracer); ok {
+			stack = st.StackTrace()
+		}
+	}
+	if stack != nil {
+		attrs = append(attrs, slog.String("stacktrace", fmt.Sprintf("%+v", stack)))
+	}
+	return attrs
+}
@@ -0,0 +1,70 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/samber/oops"
+)
+
+func TestErrorErr(t *testing.T) {
+	k, observer := NewObserver()
+
+	cause := oops.In("edgedb").With("service", "web", "attempt", 3).Wrap(errors.New("insert failed"))
+	k.ErrorErr("Failed to persist service", fmt.Errorf("persist: %w", cause), "namespace", "default")
+
+	r, _ := observer.Last()
+	if r.Level != slog.LevelError || r.Message != "Failed to persist service" {
+		t.Fatalf("expected the error record, got %v", r)
+	}
+	want := map[string]any{
+		"error":     "persist: insert failed",
+		"domain":    "edgedb",
+		"service":   "web",
+		"attempt":   int64(3),
+		"namespace": "default",
+	}
+	for key, value := range want {
+		if r.Attrs[key] != value {
+			t.Errorf("%s: expected %v, got %v", key, value, r.Attrs[key])
+		}
+	}
+	if stack, _ := r.Attrs["stacktrace"].(string); !strings.Contains(stack, "TestErrorErr") {
+		t.Errorf("expected the oops stacktrace, got %q", stack)
+	}
+}
+
+func TestErrorErrPlain(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		wantError any
+		wantStack bool
+	}{
+		{"plain", errors.New("boom"), "boom", false},
+		{"pkg errors", pkgerrors.Wrap(pkgerrors.New("boom"), "persist"), "persist: boom", true},
+		{"nil", nil, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, observer := NewObserver()
+			k.ErrorErr("failed", tt.err)
+
+			r, _ := observer.Last()
+			if r.Attrs["error"] != tt.wantError {
+				t.Errorf("expected error %v, got %v", tt.wantError, r.Attrs["error"])
+			}
+			if _, ok := r.Attrs["domain"]; ok {
+				t.Errorf("expected no domain, got %v", r.Attrs["domain"])
+			}
+			stack, _ := r.Attrs["stacktrace"].(string)
+			if got := strings.Contains(stack, "TestErrorErrPlain"); got != tt.wantStack {
+				t.Errorf("expected stacktrace %t, got %q", tt.wantStack, stack)
+			}
+		})
+	}
+}
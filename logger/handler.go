@@ -0,0 +1,95 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+)
+
+// handlerBox boxes a slog.Handler so it can be swapped atomically
+type handlerBox struct {
+	slog.Handler
+}
+
+// derivedHandler caches the handler derived from a given box
+type derivedHandler struct {
+	box     *handlerBox
+	handler slog.Handler
+}
+
+// swapHandler forwards records to the handler currently stored in sink.
+// Handlers derived through WithAttrs and WithGroup share the sink and replay
+// their attrs and groups on top of it, so they follow every swap.
+// Attributes are redacted on their way through, see RegisterRedactedKeys.
+type swapHandler struct {
+	sink   *atomic.Pointer[handlerBox]
+	derive func(slog.Handler) slog.Handler
+	cache  atomic.Pointer[derivedHandler]
+}
+
+func newSwapHandler(sink *atomic.Pointer[handlerBox]) *swapHandler {
+	return &swapHandler{sink: sink}
+}
+
+// handler returns the current sink handler with the derived attrs and groups applied
+func (h *swapHandler) handler() slog.Handler {
+	box := h.sink.Load()
+	if h.derive == nil {
+		return box.Handler
+	}
+	if c := h.cache.Load(); c != nil && c.box == box {
+		return c.handler
+	}
+	derived := h.derive(box.Handler)
+	h.cache.Store(&derivedHandler{box: box, handler: derived})
+	return derived
+}
+
+func (h *swapHandler) with(derive func(slog.Handler) slog.Handler) *swapHandler {
+	parent := h.derive
+	if parent != nil {
+		child := derive
+		derive = func(base slog.Handler) slog.Handler {
+			return child(parent(base))
+		}
+	}
+	return &swapHandler{sink: h.sink, derive: derive}
+}
+
+func (h *swapHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler().Enabled(ctx, level)
+}
+
+func (h *swapHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.handler().Handle(ctx, redactRecord(r))
+}
+
+func (h *swapHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	attrs = redactAttrs(attrs)
+	return h.with(func(base slog.Handler) slog.Handler {
+		return base.WithAttrs(attrs)
+	})
+}
+
+func (h *swapHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return h.with(func(base slog.Handler) slog.Handler {
+		return base.WithGroup(name)
+	})
+}
+
+// Handler returns the slog.Handler of the logger
+func (k *Klogger) Handler() slog.Handler {
+	return k.logger.Handler()
+}
+
+// SetHandler atomically replaces the handler of the singleton. Loggers
+// already derived from it, e.g. by With, log to the new handler as well.
+func SetHandler(h slog.Handler) {
+	sink.Store(&handlerBox{h})
+}
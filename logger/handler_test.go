@@ -0,0 +1,37 @@
+package logger
+
+import (
+	"testing"
+)
+
+func TestSetHandler(t *testing.T) {
+	Singleton()
+	box := sink.Load()
+	t.Cleanup(func() { sink.Store(box) })
+
+	derived := With("request", "r1")
+	handler := NewMemoryHandler()
+	SetHandler(handler)
+
+	Info("global")
+	if _, ok := handler.Last(); !ok {
+		t.Fatal("expected the singleton to log to the new handler")
+	}
+	derived.Info("derived")
+	if attrs := lastAttrs(handler); attrs["request"] != "r1" {
+		t.Errorf("expected the derived logger to keep its attrs, got %v", attrs)
+	}
+
+	next := NewMemoryHandler()
+	SetHandler(next)
+	derived.Info("derived")
+	if attrs := lastAttrs(next); attrs["request"] != "r1" {
+		t.Errorf("expected the derived logger to follow the swap, got %v", attrs)
+	}
+	if len(handler.Records()) != 2 {
+		t.Errorf("expected the previous handler to stop receiving records, got %d", len(handler.Records()))
+	}
+	if Singleton().Handler() == nil {
+		t.Errorf("expected a handler")
+	}
+}
@@ -3,7 +3,10 @@ package logger
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"log/slog"
+	"sync"
 	"time"
 
 	slogkafka "github.com/samber/slog-kafka"
@@ -87,11 +90,121 @@ func SetupKafkaWriter(brokers []string) *kafka.Writer {
 	return writer
 }
 
+// kafkaFlushTimeout bounds how long Flush waits for the Kafka writer to
+// deliver the pending records.
+const kafkaFlushTimeout = 10 * time.Second
+
 // NewKafkaHandler creates a new slog.Handler that forwards to Kafka.
+// Its writer is flushed by Flush and closed by Close.
 func NewKafkaHandler(brokers []string) slog.Handler {
 	kafkaWriter := SetupKafkaWriter(brokers)
-	return slogkafka.Option{
-		Level:       slog.LevelDebug,
-		KafkaWriter: kafkaWriter,
-	}.NewKafkaHandler()
+	ks := newKafkaSink(kafkaWriter)
+	RegisterCloser(ks)
+	return &kafkaHandler{
+		Handler: slogkafka.Option{
+			Level:       slog.LevelDebug,
+			KafkaWriter: kafkaWriter,
+		}.NewKafkaHandler(),
+		sink: ks,
+	}
+}
+
+// kafkaSink tracks the records the async Kafka writer has yet to deliver
+// so that they can be flushed.
+type kafkaSink struct {
+	writer  io.Closer
+	timeout time.Duration
+
+	mu      sync.Mutex
+	pending int
+	idle    chan struct{} // closed while no record is pending
+}
+
+// newKafkaSink returns the sink of writer, counting the records it delivers
+// through its Completion callback.
+func newKafkaSink(writer *kafka.Writer) *kafkaSink {
+	s := &kafkaSink{writer: writer, timeout: kafkaFlushTimeout, idle: make(chan struct{})}
+	close(s.idle)
+	completion := writer.Completion
+	writer.Completion = func(messages []kafka.Message, err error) {
+		s.done(len(messages))
+		if completion != nil {
+			completion(messages, err)
+		}
+	}
+	return s
+}
+
+func (s *kafkaSink) add(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending == 0 {
+		s.idle = make(chan struct{})
+	}
+	s.pending += n
+}
+
+func (s *kafkaSink) done(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending == 0 {
+		return
+	}
+	s.pending -= n
+	if s.pending <= 0 {
+		s.pending = 0
+		close(s.idle)
+	}
+}
+
+// Flush waits for the pending records to be delivered, giving up after the
+// flush timeout.
+func (s *kafkaSink) Flush() error {
+	s.mu.Lock()
+	idle := s.idle
+	s.mu.Unlock()
+
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+	select {
+	case <-idle:
+		return nil
+	case <-timer.C:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return fmt.Errorf("kafka: %d records not delivered after %s", s.pending, s.timeout)
+	}
+}
+
+// Close closes the writer, delivering the pending records.
+func (s *kafkaSink) Close() error {
+	return s.writer.Close()
+}
+
+// kafkaHandler counts the records handed to the async Kafka writer as
+// pending on its sink.
+type kafkaHandler struct {
+	slog.Handler
+	sink *kafkaSink
+}
+
+// Handle implements slog.Handler.
+func (h *kafkaHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.sink.add(1)
+	err := h.Handler.Handle(ctx, r)
+	if err != nil {
+		// the record was not queued, Completion will not report it
+		h.sink.done(1)
+	}
+	return err
+}
+
+// WithAttrs implements slog.Handler.
+func (h *kafkaHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &kafkaHandler{Handler: h.Handler.WithAttrs(attrs), sink: h.sink}
+}
+
+// WithGroup implements slog.Handler.
+func (h *kafkaHandler) WithGroup(name string) slog.Handler {
+	return &kafkaHandler{Handler: h.Handler.WithGroup(name), sink: h.sink}
 }
@@ -0,0 +1,58 @@
+package logger
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestKafkaFlush(t *testing.T) {
+	resetSingleton(t)
+	Singleton()
+	closersLock.Lock()
+	registered := closers
+	closersLock.Unlock()
+	t.Cleanup(func() {
+		closersLock.Lock()
+		closers = registered
+		closersLock.Unlock()
+	})
+
+	writer := &kafka.Writer{}
+	ks := newKafkaSink(writer)
+	RegisterCloser(ks)
+	memory := NewMemoryHandler()
+	log := slog.New(&kafkaHandler{Handler: memory, sink: ks}).With("a", 1)
+	log.Info("first")
+	log.Info("second")
+
+	flushed := make(chan error, 1)
+	go func() { flushed <- Flush() }()
+	writer.Completion([]kafka.Message{{}}, nil)
+	select {
+	case err := <-flushed:
+		t.Fatalf("expected Flush to wait for the second record, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	writer.Completion([]kafka.Message{{}}, errors.New("delivery failed"))
+	if err := <-flushed; err != nil {
+		t.Fatalf("expected Flush to return once the records are delivered, got %v", err)
+	}
+
+	// a record the handler failed is not waited for
+	failing := slog.New(&kafkaHandler{Handler: failingHandler{memory, errors.New("writer closed")}, sink: ks})
+	failing.Info("dropped")
+	if err := Flush(); err != nil {
+		t.Errorf("expected nothing pending, got %v", err)
+	}
+
+	ks.timeout = 10 * time.Millisecond
+	log.Info("undelivered")
+	if err := Flush(); err == nil || !strings.Contains(err.Error(), "1 records not delivered") {
+		t.Errorf("expected Flush to give up on the undelivered record, got %v", err)
+	}
+}
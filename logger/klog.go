@@ -2,18 +2,24 @@ package logger
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
-	slogmulti "github.com/samber/slog-multi"
 	slogzap "github.com/samber/slog-zap"
 	"github.com/spf13/pflag"
+	"go.uber.org/multierr"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 // Level is a shim
@@ -26,17 +32,43 @@ type Verbose bool
 type Config struct {
 	// zap config
 	zapConfig zap.Config
-	level     Level
 
 	// klog config
 	v               int32
 	alsologtostderr bool
+	// logTo is the console destination, one of the LogTo* values, empty
+	// to derive it from alsologtostderr
+	logTo string
+
+	// mapKeyFormat formats map keys in structured context
+	mapKeyFormat func(key interface{}) string
+
+	// rotatingFile is the log file, nil to log to stdout/stderr only
+	rotatingFile *lumberjack.Logger
+	// fileSink buffers the writes to rotatingFile until flushed
+	fileSink *zapcore.BufferedWriteSyncer
+	// zapLogger is the zap logger built from zapConfig, synced by Flush
+	zapLogger *zap.Logger
+	// traceContext adds the OpenTelemetry trace to *Context logging calls
+	traceContext bool
+	// sampling overrides the zap sampling, records from samplingBypass up are never sampled
+	sampling       *zap.SamplingConfig
+	samplingBypass *zapcore.Level
+	// format is the encoding of the records, FormatJSON or FormatConsole
+	format string
+	// dedupWindow collapses consecutive identical records within it, zero disables
+	dedupWindow time.Duration
 }
 
 // Klogger wraps a slog logger
 type Klogger struct {
 	logger *slog.Logger
 	config Config
+	// level gates V. A Klogger derived by With and alike follows the level
+	// of its parent until its own SetLevel overrides it.
+	level    Level
+	levelSet atomic.Bool
+	parent   *Klogger
 	// kafkaWriter *kafka.Writer
 }
 
@@ -50,9 +82,32 @@ const (
 	MaxLevel
 )
 
+// Record encodings of the --log-format flag
+const (
+	FormatJSON    = "json"
+	FormatConsole = "console"
+)
+
+// Console destinations of the --log-to flag
+const (
+	LogToStdout = "stdout"
+	LogToStderr = "stderr"
+	LogToBoth   = "both"
+)
+
+// VerbosityEnv is the environment variable used as the verbosity when the
+// --v flag is not set
+const VerbosityEnv = "LOG_V"
+
 var (
 	klogger *Klogger
-	once    sync.Once
+	initMu  sync.Mutex
+	// initialized is set once the singleton is initialized, written under initMu
+	initialized atomic.Bool
+	// sink is the handler shared by the singleton and its derived loggers
+	sink atomic.Pointer[handlerBox]
+	// addedHandlers are the handlers attached by AddHandler
+	addedHandlers []slog.Handler
 )
 
 func init() {
@@ -61,53 +116,273 @@ func init() {
 	// Redirect klog to zap
 	initKlogToZap(zapLogger)
 
-	logger := slog.New(slogzap.Option{Level: slog.LevelDebug, Logger: zapLogger}.NewZapHandler())
+	sink.Store(&handlerBox{slogzap.Option{Level: slog.LevelDebug, Logger: zapLogger}.NewZapHandler()})
 	klogger = &Klogger{
-		logger: logger,
-		config: Config{
-			level:           0,
-			v:               0,
-			alsologtostderr: true,
-		},
+		logger: slog.New(newSwapHandler(&sink)),
+		config: NewConfig(),
+	}
+	klogger.config.v = verbosityFromEnv(klogger.config.v)
+}
+
+// verbosityFromEnv returns the verbosity set by VerbosityEnv, clamped to
+// [MinLevel, MaxLevel], or def if unset or invalid. It is read at init so
+// that the --v flag, when set, still takes precedence.
+func verbosityFromEnv(def int32) int32 {
+	value := strings.TrimSpace(os.Getenv(VerbosityEnv))
+	if value == "" {
+		return def
+	}
+	v, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		klogger.Warningf("ignoring %s=%q: %v", VerbosityEnv, value, err)
+		return def
+	}
+	switch {
+	case v < int64(MinLevel):
+		klogger.Warningf("clamping %s=%d to %d", VerbosityEnv, v, MinLevel)
+		v = int64(MinLevel)
+	case v > int64(MaxLevel):
+		klogger.Warningf("clamping %s=%d to %d", VerbosityEnv, v, MaxLevel)
+		v = int64(MaxLevel)
+	}
+	return int32(v)
+}
+
+// Option configures a Config.
+type Option func(c *Config)
+
+// WithVerbosity returns an option to set the verbosity, as the --v flag does.
+func WithVerbosity(v int32) Option {
+	return func(c *Config) {
+		c.v = v
+	}
+}
+
+// WithAlsoLogToStderr returns an option to set the --alsologtostderr behavior.
+func WithAlsoLogToStderr(enabled bool) Option {
+	return func(c *Config) {
+		c.alsologtostderr = enabled
+	}
+}
+
+// WithLogTo returns an option to set where the records are written, as the
+// --log-to flag does: LogToStdout, LogToStderr or LogToBoth. It takes
+// precedence over --alsologtostderr, which otherwise selects stderr when set
+// and stdout when not.
+func WithLogTo(destination string) Option {
+	return func(c *Config) {
+		c.logTo = destination
+	}
+}
+
+// WithMapKeyFormat returns an option to set how map keys are formatted when
+// maps are logged by With and WithFields, fmt.Sprint by default.
+func WithMapKeyFormat(format func(key interface{}) string) Option {
+	return func(c *Config) {
+		c.mapKeyFormat = format
+	}
+}
+
+// WithRotatingFile returns an option to write logs to the file at path,
+// rotated once it reaches maxSizeMB. At most maxBackups rotated files are
+// kept for up to maxAgeDays, zero keeps them all. As with klog, logs still
+// go to stderr when --alsologtostderr is set, or to the --log-to destination.
+func WithRotatingFile(path string, maxSizeMB, maxBackups, maxAgeDays int) Option {
+	return func(c *Config) {
+		c.rotatingFile = &lumberjack.Logger{
+			Filename:   path,
+			MaxSize:    maxSizeMB,
+			MaxBackups: maxBackups,
+			MaxAge:     maxAgeDays,
+		}
+	}
+}
+
+// WithFormat returns an option to set the encoding of the records, as the
+// --log-format flag does: FormatJSON, the default, or the human-readable
+// FormatConsole.
+func WithFormat(format string) Option {
+	return func(c *Config) {
+		c.format = format
+	}
+}
+
+// WithTraceContext returns an option to attach the trace_id and span_id of
+// the span carried by the context of every *Context logging call.
+func WithTraceContext() Option {
+	return func(c *Config) {
+		c.traceContext = true
+	}
+}
+
+// NewConfig returns the default Config with opts applied.
+func NewConfig(opts ...Option) Config {
+	cfg := Config{
+		alsologtostderr: true,
+		format:          FormatJSON,
+	}
+	for _, o := range opts {
+		o(&cfg)
 	}
+	return cfg
 }
 
 // Singleton returns a singleton instance of Klogger.
-// It initializes the logger lazily and ensures thread safety.
-// It returns a pointer to Klogger.
+// It initializes the logger lazily from the flags and ensures thread safety.
+// The verbosity falls back to VerbosityEnv when the --v flag is not set.
+// It panics if the flags are invalid, see InitLogger to handle the error.
+// It returns a pointer to Klogger. Once initialized, it takes no lock. The
+// flags registered by InitFlags must be parsed before the first call.
 func Singleton() *Klogger {
-	once.Do(func() {
-		klogger.config.level = Level(klogger.config.v)
-		if l := klogger.config.level; l < MinLevel || l > MaxLevel {
-			panic(fmt.Errorf("FATAL: 'v' must be in the range [0, 4]"))
-		}
-		klogger.config.zapConfig = zap.NewProductionConfig()
-		// change time from ns to formatted
-		klogger.config.zapConfig.EncoderConfig.TimeKey = "time"
-		klogger.config.zapConfig.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
-		// always set to debug level
-		klogger.config.zapConfig.Level = zap.NewAtomicLevelAt(zapcore.DebugLevel)
-		// due to gaps between zap and klog
-		if !klogger.config.alsologtostderr {
-			klogger.config.zapConfig.OutputPaths = []string{"stdout"}
+	if initialized.Load() {
+		return klogger
+	}
+	return MustInit(currentConfig())
+}
+
+// currentConfig returns the configuration the singleton is initialized from,
+// read under initMu as Configure and the flags write it.
+func currentConfig() Config {
+	initMu.Lock()
+	defer initMu.Unlock()
+	return klogger.config
+}
+
+// Configure applies opts to the configuration Singleton initializes the
+// logger from. It fails once the logger is initialized.
+func Configure(opts ...Option) error {
+	initMu.Lock()
+	defer initMu.Unlock()
+	if initialized.Load() {
+		return fmt.Errorf("logger already initialized")
+	}
+	for _, o := range opts {
+		o(&klogger.config)
+	}
+	return nil
+}
+
+// MustInit is like InitLogger but panics on error.
+func MustInit(cfg Config) *Klogger {
+	k, err := InitLogger(cfg)
+	if err != nil {
+		panic(err)
+	}
+	return k
+}
+
+// InitLogger initializes the singleton Klogger from cfg and returns it.
+// Once initialized, later calls return the singleton and ignore cfg.
+// On error the singleton is left uninitialized so the call can be retried.
+func InitLogger(cfg Config) (*Klogger, error) {
+	initMu.Lock()
+	defer initMu.Unlock()
+	if initialized.Load() {
+		return klogger, nil
+	}
+
+	level := Level(cfg.v)
+	if level < MinLevel || level > MaxLevel {
+		return nil, fmt.Errorf("FATAL: 'v' must be in the range [0, 4], get %d", cfg.v)
+	}
+	zapConfig, err := newZapConfig(cfg.format)
+	if err != nil {
+		return nil, err
+	}
+	cfg.zapConfig = zapConfig
+	if cfg.zapConfig.OutputPaths, err = outputPaths(cfg.logTo, cfg.alsologtostderr); err != nil {
+		return nil, err
+	}
+	// trace the real source caller due to manual inline is not supported
+	opts := []zap.Option{zap.AddCallerSkip(1)}
+	if cfg.rotatingFile != nil {
+		cfg.fileSink = &zapcore.BufferedWriteSyncer{WS: zapcore.AddSync(cfg.rotatingFile)}
+		// log files stay JSON whatever the format
+		fileConfig, _ := newZapConfig(FormatJSON)
+		fileCore := zapcore.NewCore(zapcore.NewJSONEncoder(fileConfig.EncoderConfig), cfg.fileSink, cfg.zapConfig.Level)
+		opts = append(opts, zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			if cfg.logTo != "" || cfg.alsologtostderr {
+				return zapcore.NewTee(core, fileCore)
+			}
+			return fileCore
+		}))
+	}
+	if cfg.sampling != nil || cfg.samplingBypass != nil {
+		sampling := cfg.zapConfig.Sampling
+		if cfg.sampling != nil {
+			sampling = cfg.sampling
 		}
-		// trace the real source caller due to manual inline is not supported
-		zapLogger, err := klogger.config.zapConfig.Build(zap.AddCallerSkip(1))
-		if err != nil {
-			panic(err)
+		cfg.zapConfig.Sampling = nil
+		opts = append(opts, zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			return sampleCore(core, sampling, cfg.samplingBypass)
+		}))
+	}
+	if cfg.dedupWindow > 0 {
+		opts = append(opts, zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			return dedupCoreFor(core, cfg.dedupWindow)
+		}))
+	}
+	zapLogger, err := cfg.zapConfig.Build(opts...)
+	if err != nil {
+		return nil, err
+	}
+	// klogHandler := NewKlogHandler()
+
+	// Combine the zap handler with those attached by AddHandler
+	multiHandler := NewMultiHandler(append(
+		[]slog.Handler{slogzap.Option{Level: slog.LevelDebug, Logger: zapLogger}.NewZapHandler()},
+		addedHandlers...,
+	)...)
+	cfg.zapLogger = zapLogger
+	if cfg.traceContext {
+		AddContextExtractor(TraceContextExtractor)
+	}
+	klogger.config = cfg
+	klogger.level.set(level)
+	sink.Store(&handlerBox{multiHandler})
+	klogger.logger = slog.New(newSwapHandler(&sink))
+	initialized.Store(true)
+	Infof("Initialized zap logger...")
+	return klogger, nil
+}
+
+// newZapConfig returns the production zap config encoding records in format,
+// with ISO8601 time and every level enabled.
+func newZapConfig(format string) (zap.Config, error) {
+	config := zap.NewProductionConfig()
+	switch format {
+	case "", FormatJSON:
+	case FormatConsole:
+		config.Encoding = "console"
+		config.EncoderConfig = zap.NewDevelopmentEncoderConfig()
+	default:
+		return config, fmt.Errorf("FATAL: 'log-format' must be %s or %s, get %q", FormatJSON, FormatConsole, format)
+	}
+	// change time from ns to formatted
+	config.EncoderConfig.TimeKey = "time"
+	config.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	// always set to debug level
+	config.Level = zap.NewAtomicLevelAt(zapcore.DebugLevel)
+	return config, nil
+}
+
+// outputPaths returns the zap output paths of the logTo destination. Without
+// one, due to gaps between zap and klog, alsologtostderr selects stderr and
+// its absence stdout.
+func outputPaths(logTo string, alsologtostderr bool) ([]string, error) {
+	switch logTo {
+	case "":
+		if alsologtostderr {
+			return []string{"stderr"}, nil
 		}
-		// klogHandler := NewKlogHandler()
-
-		// Combine handlers using slogmulti
-		multiHandler := slogmulti.Fanout(
-			slogzap.Option{Level: slog.LevelDebug, Logger: zapLogger}.NewZapHandler(),
-			// klogHandler,
-		)
-		logger := slog.New(multiHandler)
-		klogger.logger = logger
-		Infof("Initialized zap logger...")
-	})
-	return klogger
+		return []string{"stdout"}, nil
+	case LogToStdout, LogToStderr:
+		return []string{logTo}, nil
+	case LogToBoth:
+		return []string{"stdout", "stderr"}, nil
+	default:
+		return nil, fmt.Errorf("FATAL: 'log-to' must be %s, %s or %s, get %q", LogToStdout, LogToStderr, LogToBoth, logTo)
+	}
 }
 
 // SetLogger sets the slog.Logger instance
@@ -120,18 +395,78 @@ func (k *Klogger) GetLogger() *slog.Logger {
 	return k.logger
 }
 
-// InitFlags is a shim, only accepts
+// Slog returns the slog.Logger of the singleton, see Klogger.Slog.
+func Slog() *slog.Logger {
+	return klogger.Slog()
+}
+
+// Slog returns the wrapped slog.Logger, to hand to code accepting a standard
+// logger. Records logged through it skip the V() level gating, which only
+// applies to the Klogger calls.
+func (k *Klogger) Slog() *slog.Logger {
+	return k.logger
+}
+
+// FromSlog returns a Klogger adopting logger, e.g. one configured by a
+// third-party library, with the configuration of the singleton.
+func FromSlog(logger *slog.Logger) *Klogger {
+	return klogger.derive(logger)
+}
+
+// derive returns a Klogger logging to logger with the configuration of k,
+// following the level of k.
+func (k *Klogger) derive(logger *slog.Logger) *Klogger {
+	return &Klogger{logger: logger, config: k.config, parent: k}
+}
+
+// InitFlags registers the logger flags on flagset, pflag.CommandLine if nil.
+// The flags write the configuration Singleton initializes the logger from, so
+// flagset must be parsed before the first log call, and is ignored after.
 func InitFlags(flagset *pflag.FlagSet) {
 	if flagset == nil {
 		flagset = pflag.CommandLine
 	}
+	initMu.Lock()
+	defer initMu.Unlock()
 	flagset.Int32Var(&klogger.config.v, "v", klogger.config.v, "verbosity of info log")
 	flagset.BoolVar(&klogger.config.alsologtostderr, "alsologtostderr", klogger.config.alsologtostderr, "also write logs to stderr, default to true")
+	flagset.StringVar(&klogger.config.logTo, "log-to", klogger.config.logTo, "write logs to stdout, stderr or both, overrides --alsologtostderr")
+	flagset.StringVar(&klogger.config.format, "log-format", klogger.config.format, "log format, json or console")
+}
+
+// Flush syncs the zap logger, writing the buffered logs to the log file if any,
+// and flushes the registered writers, e.g. the Kafka one
+func Flush() error {
+	return klogger.Flush()
+}
+
+// Flush syncs the zap logger, writing the buffered logs to the log file if any,
+// and flushes the registered writers, e.g. the Kafka one. The errors syncing
+// stdout and stderr, which some platforms do not support, are ignored.
+func (k *Klogger) Flush() error {
+	// the singleton config is written by InitLogger, under initMu
+	initMu.Lock()
+	zapLogger := k.config.zapLogger
+	initMu.Unlock()
+	var errs []error
+	if zapLogger != nil {
+		for _, err := range multierr.Errors(zapLogger.Sync()) {
+			if !isConsoleSyncError(err) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	errs = append(errs, flushClosers())
+	return errors.Join(errs...)
 }
 
-// Flush is a shim
-func Flush() {
-	// No-op, as slog doesn't have a Flush method
+// isConsoleSyncError reports whether err is the benign error of syncing stdout or stderr.
+func isConsoleSyncError(err error) bool {
+	var pathErr *os.PathError
+	if !errors.As(err, &pathErr) {
+		return false
+	}
+	return pathErr.Path == os.Stdout.Name() || pathErr.Path == os.Stderr.Name()
 }
 
 // SetLevel updates level on the fly
@@ -139,15 +474,24 @@ func SetLevel(v Level) {
 	klogger.SetLevel(v)
 }
 
-// SetLevel updates level on the fly
+// SetLevel updates level on the fly, for k and the loggers derived from it
+// that did not set their own
 func (k *Klogger) SetLevel(v Level) {
 	if v < MinLevel || v > MaxLevel {
 		k.Warningf("failed setting level: expect [0, 4], get %d", v)
 		return
 	}
-	if k.config.level.get() != v {
-		k.config.level.set(v)
+	k.level.set(v)
+	k.levelSet.Store(true)
+}
+
+// currentLevel returns the level gating V, the one of the parent of k unless
+// k set its own.
+func (k *Klogger) currentLevel() Level {
+	for k.parent != nil && !k.levelSet.Load() {
+		k = k.parent
 	}
+	return k.level.get()
 }
 
 // Set sets the value of the Level.
@@ -162,12 +506,12 @@ func (l *Level) get() Level {
 
 // V is a shim
 func V(level Level) Verbose {
-	return Verbose(level <= klogger.config.level.get())
+	return klogger.V(level)
 }
 
 // V is a shim
 func (k *Klogger) V(level Level) Verbose {
-	return Verbose(level <= k.config.level.get())
+	return Verbose(level <= k.currentLevel())
 }
 
 // Info is a shim
@@ -323,6 +667,41 @@ func (k *Klogger) Warningf(format string, args ...interface{}) {
 	k.logger.Log(context.Background(), slog.LevelWarn, fmt.Sprintf(format, args...))
 }
 
+// Warn is an alias of Warning
+//
+//go:noinline
+func Warn(args ...interface{}) {
+	klogger.logger.Log(context.Background(), slog.LevelWarn, fmt.Sprint(args...))
+}
+
+// Warn is an alias of Warning
+//
+//go:noinline
+func (k *Klogger) Warn(args ...interface{}) {
+	k.logger.Log(context.Background(), slog.LevelWarn, fmt.Sprint(args...))
+}
+
+// Warnln is an alias of Warningln
+//
+//go:noinline
+func Warnln(args ...interface{}) {
+	klogger.logger.Log(context.Background(), slog.LevelWarn, fmt.Sprint(args...))
+}
+
+// Warnln is an alias of Warningln
+//
+//go:noinline
+func (k *Klogger) Warnln(args ...interface{}) {
+	k.logger.Log(context.Background(), slog.LevelWarn, fmt.Sprint(args...))
+}
+
+// Warnf is an alias of Warningf
+//
+//go:noinline
+func Warnf(format string, args ...interface{}) {
+	klogger.logger.Log(context.Background(), slog.LevelWarn, fmt.Sprintf(format, args...))
+}
+
 // Error is a shim
 //
 //go:noinline
@@ -516,12 +895,9 @@ func With(args ...interface{}) *Klogger {
 func (k *Klogger) With(args ...interface{}) *Klogger {
 	newLogger := k.logger
 	if len(args) > 0 {
-		newLogger = newLogger.With(slog.Group("", args...))
-	}
-	return &Klogger{
-		logger: newLogger,
-		config: k.config,
+		newLogger = newLogger.With(k.config.mapValues(args)...)
 	}
+	return k.derive(newLogger)
 }
 
 // WithFields adds structured context to the logger.
@@ -529,16 +905,19 @@ func WithFields(args map[string]interface{}) *Klogger {
 	return klogger.WithFields(args)
 }
 
-// WithFields adds structured context to the logger.
+// WithFields adds structured context to the logger, each field a top-level
+// attribute in key order.
 func (k *Klogger) WithFields(fields map[string]interface{}) *Klogger {
 	newLogger := k.logger
 	if len(fields) > 0 {
-		newLogger = newLogger.With(slog.Group("", slog.Any("fields", fields)))
-	}
-	return &Klogger{
-		logger: newLogger,
-		config: k.config,
+		attrs := k.config.mapValue(reflect.ValueOf(fields)).Group()
+		args := make([]interface{}, len(attrs))
+		for i, attr := range attrs {
+			args[i] = attr
+		}
+		newLogger = newLogger.With(args...)
 	}
+	return k.derive(newLogger)
 }
 
 // WithAll fills each arg directly without parsing fields and values.
@@ -549,30 +928,140 @@ func WithAll(args ...interface{}) *Klogger {
 
 // WithAll fills each arg directly without parsing fields and values.
 // Only valid for exported fields.
+// Args and fields implementing slog.LogValuer or fmt.Stringer are logged
+// as they represent themselves.
 func (k *Klogger) WithAll(args ...interface{}) *Klogger {
 	newLogger := k.logger
 	for _, arg := range args {
-		t := reflect.TypeOf(arg)
-		v := reflect.ValueOf(arg)
+		var fields []interface{}
+		switch a := arg.(type) {
+		case slog.LogValuer:
+			value := a.LogValue().Resolve()
+			if value.Kind() == slog.KindGroup {
+				for _, attr := range value.Group() {
+					fields = append(fields, attr)
+				}
+			} else {
+				fields = append(fields, typeKey(arg), value)
+			}
+		case fmt.Stringer:
+			fields = append(fields, typeKey(arg), a.String())
+		default:
+			v := reflect.ValueOf(arg)
+
+			// Check if the type is a struct
+			if v.Kind() != reflect.Struct {
+				continue // or handle error
+			}
+
+			fields = structFields(v, make([]interface{}, 0, v.NumField()*2))
+		}
+		newLogger = newLogger.With(fields...)
+	}
+	return k.derive(newLogger)
+}
 
-		// Check if the type is a struct
-		if t.Kind() != reflect.Struct {
-			continue // or handle error
+// mapValues replaces the maps in args by their mapValue.
+func (c *Config) mapValues(args []interface{}) []interface{} {
+	out := make([]interface{}, len(args))
+	for i, arg := range args {
+		if v := reflect.ValueOf(arg); v.Kind() == reflect.Map {
+			out[i] = c.mapValue(v)
+			continue
 		}
+		out[i] = arg
+	}
+	return out
+}
 
-		fields := make([]interface{}, 0, t.NumField()*2)
-		for i := 0; i < t.NumField(); i++ {
-			field := t.Field(i)
-			if field.IsExported() {
-				fields = append(fields, field.Name, v.Field(i).Interface())
+// mapValue renders the map v as a group sorted by formatted key, so that
+// the output is deterministic whatever the key type. Nested maps are
+// rendered the same way.
+func (c *Config) mapValue(v reflect.Value) slog.Value {
+	format := c.mapKeyFormat
+	if format == nil {
+		format = func(key interface{}) string { return fmt.Sprint(key) }
+	}
+	attrs := make([]slog.Attr, 0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		value := iter.Value()
+		for value.Kind() == reflect.Interface && !value.IsNil() {
+			value = value.Elem()
+		}
+		key := format(iter.Key().Interface())
+		if value.Kind() == reflect.Map {
+			attrs = append(attrs, slog.Attr{Key: key, Value: c.mapValue(value)})
+			continue
+		}
+		attrs = append(attrs, slog.Any(key, iter.Value().Interface()))
+	}
+	sort.SliceStable(attrs, func(i, j int) bool { return attrs[i].Key < attrs[j].Key })
+	return slog.GroupValue(attrs...)
+}
+
+// structFields appends the exported fields of the struct v as key-value pairs,
+// promoting the fields of embedded structs into the same level. As with
+// encoding/json, the exported fields of an embedded struct are promoted even
+// if its type is unexported, unless it is embedded by pointer.
+func structFields(v reflect.Value, fields []interface{}) []interface{} {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fv := v.Field(i)
+		if field.Anonymous {
+			embedded := fv
+			if embedded.Kind() == reflect.Pointer {
+				if embedded.IsNil() || !field.IsExported() {
+					continue
+				}
+				embedded = embedded.Elem()
 			}
+			if embedded.Kind() == reflect.Struct && (!fv.CanInterface() || !representsItself(fv.Interface())) {
+				fields = structFields(embedded, fields)
+				continue
+			}
+		}
+		// unexported fields cannot be read
+		if !fv.CanInterface() {
+			continue
 		}
-		newLogger = newLogger.With(slog.Group("", fields...))
+		fields = append(fields, field.Name, fieldValue(fv.Interface()))
+	}
+	return fields
+}
+
+// representsItself reports whether value implements slog.LogValuer or fmt.Stringer.
+func representsItself(value interface{}) bool {
+	switch value.(type) {
+	case slog.LogValuer, fmt.Stringer:
+		return true
+	default:
+		return false
+	}
+}
+
+// typeKey returns the attribute key of an arg logged as a single value.
+func typeKey(arg interface{}) string {
+	if name := reflect.TypeOf(arg).Name(); name != "" {
+		return name
 	}
-	return &Klogger{
-		logger: newLogger,
-		config: k.config,
+	return "value"
+}
+
+// fieldValue returns the representation of a struct field value,
+// preferring fmt.Stringer unless slog can resolve a slog.LogValuer itself.
+func fieldValue(value interface{}) interface{} {
+	if _, ok := value.(slog.LogValuer); ok {
+		return value
+	}
+	if s, ok := value.(fmt.Stringer); ok {
+		if v := reflect.ValueOf(value); v.Kind() == reflect.Pointer && v.IsNil() {
+			return value
+		}
+		return s.String()
 	}
+	return value
 }
 
 // Debugf implements log.Logger.
@@ -1,15 +1,39 @@
 package logger
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+
+	slogzap "github.com/samber/slog-zap"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 func TestProduction(t *testing.T) {
 	InitFlags(nil)
 	klogger.config.v = 1 // enable DEBUG level
 	Singleton()
+	_, observer := NewObserver()
+	box, level := sink.Load(), klogger.level.get()
+	t.Cleanup(func() {
+		sink.Store(box)
+		klogger.level.set(level)
+	})
+	SetHandler(observer)
+	SetLevel(1)
 
 	arg := fmt.Errorf("hello")
 	arg2 := fmt.Errorf("world")
@@ -31,6 +55,15 @@ func TestProduction(t *testing.T) {
 	V(2).Info(arg)
 	V(2).Infoln(arg)
 	V(2).Infof("%s", arg)
+
+	for level, want := range map[slog.Level]int{slog.LevelError: 4, slog.LevelWarn: 4, slog.LevelInfo: 7} {
+		if got := len(observer.FilterByLevel(level)); got != want {
+			t.Errorf("expected %d %s records, got %d", want, level, got)
+		}
+	}
+	if got := len(observer.FilterByMessage("hello world")); got != 3 {
+		t.Errorf("expected the 3 *ln calls to join their args, got %d", got)
+	}
 }
 
 func TestWith(t *testing.T) {
@@ -168,3 +201,572 @@ func BenchmarkContextWith(b *testing.B) {
 		newLogger.Info("world")
 	}
 }
+
+// lastAttrs returns the attributes of the last record captured by h.
+func lastAttrs(h *MemoryHandler) map[string]any {
+	r, _ := h.Last()
+	return r.Attrs
+}
+
+type secret string
+
+func (secret) LogValue() slog.Value { return slog.StringValue("***") }
+
+type point struct{ X, Y int }
+
+func (p point) LogValue() slog.Value {
+	return slog.GroupValue(slog.Int("x", p.X), slog.Int("y", p.Y))
+}
+
+type color int
+
+func (color) String() string { return "red" }
+
+func TestWithAllLogValuer(t *testing.T) {
+	handler := NewMemoryHandler()
+	l := &Klogger{}
+	l.SetLogger(slog.New(handler))
+
+	l.WithAll(point{1, 2}).Info("point")
+	if lastAttrs(handler)["x"] != int64(1) || lastAttrs(handler)["y"] != int64(2) {
+		t.Errorf("expected LogValue group x=1 y=2, got %v", lastAttrs(handler))
+	}
+	if _, ok := lastAttrs(handler)["X"]; ok {
+		t.Errorf("expected reflected fields to be replaced by LogValue, got %v", lastAttrs(handler))
+	}
+
+	l.WithAll(struct {
+		Token secret
+		Color color
+		Count int
+	}{"s3cr3t", 1, 3}).Info("fields")
+	if lastAttrs(handler)["Token"] != "***" {
+		t.Errorf("expected Token LogValue ***, got %v", lastAttrs(handler)["Token"])
+	}
+	if lastAttrs(handler)["Color"] != "red" {
+		t.Errorf("expected Color String red, got %v", lastAttrs(handler)["Color"])
+	}
+	if lastAttrs(handler)["Count"] != int64(3) {
+		t.Errorf("expected Count 3, got %v", lastAttrs(handler)["Count"])
+	}
+
+	l.WithAll(color(1)).Info("stringer")
+	if lastAttrs(handler)["color"] != "red" {
+		t.Errorf("expected stringer arg keyed by type name, got %v", lastAttrs(handler))
+	}
+}
+
+type Meta struct {
+	Namespace string
+	Name      string
+}
+
+type inner struct {
+	Hidden string
+}
+
+type labels struct {
+	Team    string
+	private string
+}
+
+func TestWithAllEmbedded(t *testing.T) {
+	handler := NewMemoryHandler()
+	l := &Klogger{}
+	l.SetLogger(slog.New(handler))
+
+	type Object struct {
+		Meta
+		*inner
+		labels
+		Kind    string
+		private string
+	}
+	l.WithAll(Object{Meta: Meta{"default", "web"}, inner: &inner{"x"}, labels: labels{"payments", "p"}, Kind: "Service", private: "p"}).Info("embedded")
+
+	// the exported fields of the unexported labels are promoted, not those behind *inner
+	want := map[string]any{"Namespace": "default", "Name": "web", "Team": "payments", "Kind": "Service"}
+	if len(lastAttrs(handler)) != len(want) {
+		t.Errorf("expected %v, got %v", want, lastAttrs(handler))
+	}
+	for k, v := range want {
+		if lastAttrs(handler)[k] != v {
+			t.Errorf("expected %s=%v, got %v", k, v, lastAttrs(handler)[k])
+		}
+	}
+
+	type WithNil struct {
+		*Meta
+		Kind string
+	}
+	l.WithAll(WithNil{Kind: "Pod"}).Info("nil embedded")
+	if len(lastAttrs(handler)) != 1 || lastAttrs(handler)["Kind"] != "Pod" {
+		t.Errorf("expected only Kind=Pod, got %v", lastAttrs(handler))
+	}
+}
+
+// resetSingleton makes the next InitLogger call initialize the singleton again.
+func resetSingleton(t *testing.T) {
+	initMu.Lock()
+	config, logger, wasInitialized, box, added := klogger.config, klogger.logger, initialized.Load(), sink.Load(), addedHandlers
+	level := klogger.level.get()
+	initialized.Store(false)
+	initMu.Unlock()
+	t.Cleanup(func() {
+		initMu.Lock()
+		klogger.config, klogger.logger, addedHandlers = config, logger, added
+		initialized.Store(wasInitialized)
+		klogger.level.set(level)
+		sink.Store(box)
+		initMu.Unlock()
+	})
+}
+
+func TestInitLoggerInvalidVerbosity(t *testing.T) {
+	resetSingleton(t)
+	k, err := InitLogger(NewConfig(WithVerbosity(7)))
+	if err == nil {
+		t.Fatal("expected an error for verbosity 7")
+	}
+	if k != nil {
+		t.Errorf("expected no logger on error, got %v", k)
+	}
+	if initialized.Load() {
+		t.Errorf("expected the singleton to stay uninitialized after an error")
+	}
+}
+
+func TestInitLogger(t *testing.T) {
+	resetSingleton(t)
+	k, err := InitLogger(NewConfig(WithVerbosity(2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k != Singleton() {
+		t.Errorf("expected InitLogger to return the singleton")
+	}
+	if !V(2) {
+		t.Errorf("expected verbosity 2 to be enabled")
+	}
+	if again, err := InitLogger(NewConfig(WithVerbosity(7))); err != nil || again != k {
+		t.Errorf("expected later calls to return the singleton, got %v, %v", again, err)
+	}
+}
+
+func TestMustInitPanics(t *testing.T) {
+	resetSingleton(t)
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected MustInit to panic")
+		}
+	}()
+	MustInit(NewConfig(WithVerbosity(-1)))
+}
+
+func TestVerbosityFromEnv(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int32
+	}{
+		{"", 1},
+		{"3", 3},
+		{" 2 ", 2},
+		{"9", 4},
+		{"-3", 0},
+		{"debug", 1},
+	}
+	for _, tt := range tests {
+		t.Setenv(VerbosityEnv, tt.value)
+		if got := verbosityFromEnv(1); got != tt.want {
+			t.Errorf("%s=%q: expected %d, got %d", VerbosityEnv, tt.value, tt.want, got)
+		}
+	}
+}
+
+func TestVerbosityFromEnvLevel(t *testing.T) {
+	t.Setenv(VerbosityEnv, "3")
+	resetSingleton(t)
+	if _, err := InitLogger(NewConfig(WithVerbosity(verbosityFromEnv(0)))); err != nil {
+		t.Fatal(err)
+	}
+	if !V(3) {
+		t.Errorf("expected verbosity 3 to be enabled")
+	}
+	if V(4) {
+		t.Errorf("expected verbosity 4 to be disabled")
+	}
+}
+
+func TestWithMapSorted(t *testing.T) {
+	handler := NewMemoryHandler()
+	l := &Klogger{}
+	l.SetLogger(slog.New(handler))
+
+	counts := map[struct{ A string }]int{{A: "c"}: 3, {A: "a"}: 1, {A: "b"}: 2}
+	want := []string{"{a}", "{b}", "{c}"}
+	for i := 0; i < 10; i++ {
+		var keys []string
+		for _, a := range l.config.mapValue(reflect.ValueOf(counts)).Group() {
+			keys = append(keys, a.Key)
+		}
+		if !reflect.DeepEqual(keys, want) {
+			t.Fatalf("expected keys %v, got %v", want, keys)
+		}
+	}
+
+	l.With("counts", counts, "nested", map[string]interface{}{"inner": map[int]string{2: "b", 1: "a"}}).Info("maps")
+	attrs := lastAttrs(handler)
+	if attrs["counts.{b}"] != int64(2) {
+		t.Errorf("expected counts.{b}=2, got %v", attrs)
+	}
+	if attrs["nested.inner.1"] != "a" {
+		t.Errorf("expected nested.inner.1=a, got %v", attrs)
+	}
+
+	l.WithFields(map[string]interface{}{"b": 2, "a": 1}).Info("fields")
+	if attrs := lastAttrs(handler); attrs["a"] != int64(1) || attrs["b"] != int64(2) {
+		t.Errorf("expected a=1 b=2, got %v", attrs)
+	}
+}
+
+func TestWithMapKeyFormat(t *testing.T) {
+	handler := NewMemoryHandler()
+	l := &Klogger{config: NewConfig(WithMapKeyFormat(func(key interface{}) string {
+		return key.(struct{ A string }).A
+	}))}
+	l.SetLogger(slog.New(handler))
+
+	l.With("counts", map[struct{ A string }]int{{A: "a"}: 1}).Info("maps")
+	if attrs := lastAttrs(handler); attrs["counts.a"] != int64(1) {
+		t.Errorf("expected counts.a=1, got %v", attrs)
+	}
+}
+
+func TestWarnAliases(t *testing.T) {
+	Singleton()
+	box := sink.Load()
+	t.Cleanup(func() { sink.Store(box) })
+	handler := NewMemoryHandler()
+	SetHandler(handler)
+
+	arg := fmt.Errorf("hello")
+	pairs := []struct {
+		name           string
+		warning, alias func()
+	}{
+		{"Warn", func() { Warning(arg) }, func() { Warn(arg) }},
+		{"Warnln", func() { Warningln(arg, 1) }, func() { Warnln(arg, 1) }},
+		{"Warnf", func() { Warningf("%s %d", arg, 1) }, func() { Warnf("%s %d", arg, 1) }},
+		{"Klogger.Warn", func() { klogger.Warning(arg) }, func() { klogger.Warn(arg) }},
+		{"Klogger.Warnln", func() { klogger.Warningln(arg, 1) }, func() { klogger.Warnln(arg, 1) }},
+		{"Klogger.Warnf", func() { klogger.Warningf("%s %d", arg, 1) }, func() { klogger.Warnf("%s %d", arg, 1) }},
+	}
+	for _, p := range pairs {
+		handler.Reset()
+		p.warning()
+		p.alias()
+		records := handler.Records()
+		if len(records) != 2 {
+			t.Fatalf("%s: expected 2 records, got %d", p.name, len(records))
+		}
+		if records[0].Level != slog.LevelWarn || records[1].Level != records[0].Level || records[1].Message != records[0].Message {
+			t.Errorf("%s: expected identical warnings, got %v %q and %v %q", p.name,
+				records[0].Level, records[0].Message, records[1].Level, records[1].Message)
+		}
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	resetSingleton(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "pkg.log")
+	k, err := InitLogger(NewConfig(WithAlsoLogToStderr(false), WithRotatingFile(path, 1, 2, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = k.config.fileSink.Stop()
+		_ = k.config.rotatingFile.Close()
+	})
+
+	line := strings.Repeat("x", 1024)
+	for i := 0; i < 1500; i++ {
+		Info(line)
+	}
+	if err := Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) < 2 {
+		t.Errorf("expected a rotated backup next to %s, got %v", path, entries)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("expected the current log file: %v", err)
+	}
+}
+
+func TestConfigureAfterInit(t *testing.T) {
+	Singleton()
+	if err := Configure(WithVerbosity(2)); err == nil {
+		t.Errorf("expected Configure to fail once initialized")
+	}
+}
+
+func TestSingletonConcurrent(t *testing.T) {
+	resetSingleton(t)
+	var wg sync.WaitGroup
+	loggers := make([]*Klogger, 8)
+	for i := range loggers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			loggers[i] = Singleton()
+		}(i)
+	}
+	wg.Wait()
+	for i, k := range loggers {
+		if k != klogger {
+			t.Errorf("call %d: expected the singleton, got %p", i, k)
+		}
+	}
+	if !initialized.Load() {
+		t.Errorf("expected the singleton initialized")
+	}
+}
+
+func TestFlush(t *testing.T) {
+	resetSingleton(t)
+	path := filepath.Join(t.TempDir(), "pkg.log")
+	k, err := InitLogger(NewConfig(WithAlsoLogToStderr(false), WithRotatingFile(path, 10, 1, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = k.config.fileSink.Stop()
+		_ = k.config.rotatingFile.Close()
+	})
+
+	Info("flush-me")
+	if data, _ := os.ReadFile(path); strings.Contains(string(data), "flush-me") {
+		t.Fatalf("expected the log to be buffered until Flush")
+	}
+	if err := k.With("a", 1).Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); !strings.Contains(string(data), "flush-me") {
+		t.Errorf("expected the log on disk after Flush, got %q", data)
+	}
+}
+
+func TestIsConsoleSyncError(t *testing.T) {
+	if !isConsoleSyncError(&os.PathError{Op: "sync", Path: os.Stdout.Name(), Err: errors.New("invalid argument")}) {
+		t.Errorf("expected syncing stdout to be benign")
+	}
+	if isConsoleSyncError(&os.PathError{Op: "sync", Path: "/var/log/pkg.log", Err: errors.New("no space left on device")}) {
+		t.Errorf("expected syncing a file to be a real error")
+	}
+}
+
+func TestSlogRoundTrip(t *testing.T) {
+	handler := NewMemoryHandler()
+	k := FromSlog(slog.New(handler))
+	if k.Slog() != k.GetLogger() {
+		t.Fatal("expected Slog to return the adopted logger")
+	}
+
+	k.With("request", "r1").Slog().Info("borrowed", "cluster", "web")
+	if attrs := lastAttrs(handler); attrs["request"] != "r1" || attrs["cluster"] != "web" {
+		t.Errorf("expected the borrowed logger to keep the Klogger attrs, got %v", attrs)
+	}
+
+	FromSlog(k.Slog()).Infof("adopted %d", 1)
+	if r, _ := handler.Last(); r.Message != "adopted 1" {
+		t.Errorf("expected the re-adopted logger to log to the handler, got %q", r.Message)
+	}
+	if Slog() != klogger.logger {
+		t.Errorf("expected the package Slog to return the singleton logger")
+	}
+}
+
+func TestDerivedLoggerLevels(t *testing.T) {
+	resetSingleton(t)
+	if _, err := InitLogger(NewConfig(WithVerbosity(0))); err != nil {
+		t.Fatal(err)
+	}
+
+	subsystem := With("subsystem", "xds")
+	other := WithFields(map[string]interface{}{"subsystem": "lrs"})
+	subsystem.SetLevel(3)
+
+	if !subsystem.V(3) {
+		t.Errorf("expected the subsystem logger at V(3)")
+	}
+	if other.V(1) || V(1) {
+		t.Errorf("expected the other loggers to stay at V(0)")
+	}
+	child := subsystem.WithAll()
+	if !child.V(3) {
+		t.Errorf("expected a logger derived from the subsystem to inherit V(3)")
+	}
+
+	// a level changed at runtime reaches the loggers derived before
+	SetLevel(2)
+	if !subsystem.V(3) || subsystem.V(4) {
+		t.Errorf("expected the subsystem level set independently of the singleton")
+	}
+	if !other.V(2) || other.V(3) {
+		t.Errorf("expected a derived logger to follow the singleton at V(2)")
+	}
+	if adopted := FromSlog(slog.Default()); !adopted.V(2) {
+		t.Errorf("expected an adopted logger to follow the singleton at V(2)")
+	}
+	subsystem.SetLevel(1)
+	if !child.V(1) || child.V(2) {
+		t.Errorf("expected the child to follow the subsystem at V(1)")
+	}
+}
+
+func TestWithTopLevelAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(&buf), zapcore.DebugLevel)
+	k := FromSlog(slog.New(slogzap.Option{Level: slog.LevelDebug, Logger: zap.New(core)}.NewZapHandler()))
+
+	type S struct{ C int }
+	tests := []struct {
+		name   string
+		logger *Klogger
+		want   map[string]any
+	}{
+		{"With", k.With("a", 1), map[string]any{"a": float64(1)}},
+		{"WithFields", k.WithFields(map[string]interface{}{"b": 2, "a": 1}), map[string]any{"a": float64(1), "b": float64(2)}},
+		{"WithAll", k.WithAll(S{C: 3}), map[string]any{"C": float64(3)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+			tt.logger.Info("x")
+			var entry map[string]any
+			if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+				t.Fatalf("invalid JSON %q: %v", buf.String(), err)
+			}
+			if _, ok := entry[""]; ok {
+				t.Errorf("expected no empty group key, got %s", buf.String())
+			}
+			for key, value := range tt.want {
+				if entry[key] != value {
+					t.Errorf("expected top-level %s=%v, got %s", key, value, buf.String())
+				}
+			}
+		})
+	}
+}
+
+func TestZapConfigFormat(t *testing.T) {
+	iso8601 := regexp.MustCompile(`\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{3}`)
+	output := func(t *testing.T, format string) string {
+		config, err := newZapConfig(format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(t.TempDir(), "log")
+		config.OutputPaths = []string{path}
+		zapLogger, err := config.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		zapLogger.Info("hello", zap.String("k", "v"))
+		_ = zapLogger.Sync()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(string(data))
+	}
+
+	jsonLine := output(t, FormatJSON)
+	var record map[string]any
+	if err := json.Unmarshal([]byte(jsonLine), &record); err != nil {
+		t.Fatalf("expected a JSON record, got %q: %v", jsonLine, err)
+	}
+	if record["msg"] != "hello" || record["k"] != "v" || !iso8601.MatchString(fmt.Sprint(record["time"])) {
+		t.Errorf("unexpected JSON record %v", record)
+	}
+
+	consoleLine := output(t, FormatConsole)
+	if json.Valid([]byte(consoleLine)) {
+		t.Errorf("expected a console line, got JSON %q", consoleLine)
+	}
+	fields := strings.Split(consoleLine, "\t")
+	if len(fields) < 4 || !iso8601.MatchString(fields[0]) || fields[1] != "INFO" || !strings.Contains(consoleLine, "hello") || !strings.Contains(consoleLine, `{"k": "v"}`) {
+		t.Errorf("unexpected console line %q", consoleLine)
+	}
+
+	if _, err := newZapConfig("xml"); err == nil {
+		t.Errorf("expected an error for an unknown format")
+	}
+}
+
+func TestInitLoggerFormat(t *testing.T) {
+	resetSingleton(t)
+	if _, err := InitLogger(NewConfig(WithFormat("xml"))); err == nil || initialized.Load() {
+		t.Fatalf("expected an unknown format to fail, got %v", err)
+	}
+	if _, err := InitLogger(NewConfig(WithFormat(FormatConsole))); err != nil {
+		t.Fatal(err)
+	}
+	if klogger.config.zapConfig.Encoding != "console" {
+		t.Errorf("expected the console encoding, got %s", klogger.config.zapConfig.Encoding)
+	}
+}
+
+func TestOutputPaths(t *testing.T) {
+	tests := []struct {
+		logTo           string
+		alsologtostderr bool
+		want            []string
+	}{
+		{"", true, []string{"stderr"}},
+		{"", false, []string{"stdout"}},
+		{LogToStdout, true, []string{"stdout"}},
+		{LogToStderr, false, []string{"stderr"}},
+		{LogToBoth, false, []string{"stdout", "stderr"}},
+	}
+	for _, tt := range tests {
+		got, err := outputPaths(tt.logTo, tt.alsologtostderr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("log-to %q alsologtostderr %t: expected %v, got %v", tt.logTo, tt.alsologtostderr, tt.want, got)
+		}
+	}
+	if _, err := outputPaths("syslog", true); err == nil {
+		t.Errorf("expected an error for an unknown destination")
+	}
+}
+
+func TestInitLoggerLogTo(t *testing.T) {
+	flagset := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	InitFlags(flagset)
+	t.Cleanup(func() { klogger.config.logTo = "" })
+	if err := flagset.Parse([]string{"--log-to=both"}); err != nil {
+		t.Fatal(err)
+	}
+
+	resetSingleton(t)
+	if _, err := InitLogger(klogger.config); err != nil {
+		t.Fatal(err)
+	}
+	if paths := klogger.config.zapConfig.OutputPaths; !slices.Equal(paths, []string{"stdout", "stderr"}) {
+		t.Errorf("expected stdout and stderr, got %v", paths)
+	}
+
+	resetSingleton(t)
+	if _, err := InitLogger(NewConfig(WithLogTo("syslog"))); err == nil || initialized.Load() {
+		t.Fatalf("expected an unknown destination to fail, got %v", err)
+	}
+}
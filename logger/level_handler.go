@@ -0,0 +1,44 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxLevelBodySize bounds the request body read by LevelHandler
+const maxLevelBodySize = 64
+
+// LevelHandler returns an http.Handler reporting the current level on GET
+// and setting it on PUT or POST, the body being the new level in [0, 4].
+func LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxLevelBodySize))
+			if err != nil {
+				http.Error(w, fmt.Sprintf("failed reading level: %v", err), http.StatusBadRequest)
+				return
+			}
+			v, err := strconv.ParseInt(strings.TrimSpace(string(body)), 10, 32)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid level %q: expect an integer in [0, 4]", body), http.StatusBadRequest)
+				return
+			}
+			if l := Level(v); l < MinLevel || l > MaxLevel {
+				http.Error(w, fmt.Sprintf("invalid level: expect [0, 4], get %d", v), http.StatusBadRequest)
+				return
+			}
+			SetLevel(Level(v))
+		default:
+			w.Header().Set("Allow", "GET, PUT, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "%d\n", klogger.level.get())
+	})
+}
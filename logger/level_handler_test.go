@@ -0,0 +1,51 @@
+package logger
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLevelHandler(t *testing.T) {
+	Singleton()
+	previous := klogger.level.get()
+	t.Cleanup(func() { SetLevel(previous) })
+	SetLevel(1)
+
+	handler := LevelHandler()
+	do := func(method, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, "/debug/level", strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := do(http.MethodGet, ""); rec.Code != http.StatusOK || rec.Body.String() != "1\n" {
+		t.Errorf("GET: expected 200 and level 1, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	if rec := do(http.MethodPut, "3\n"); rec.Code != http.StatusOK || rec.Body.String() != "3\n" {
+		t.Errorf("PUT: expected 200 and level 3, got %d %q", rec.Code, rec.Body.String())
+	}
+	if !V(3) || V(4) {
+		t.Errorf("expected verbosity 3 after PUT")
+	}
+
+	if rec := do(http.MethodPost, "2"); rec.Code != http.StatusOK || klogger.level.get() != 2 {
+		t.Errorf("POST: expected 200 and level 2, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	for _, body := range []string{"5", "-1", "debug", ""} {
+		rec := do(http.MethodPut, body)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "[0, 4]") {
+			t.Errorf("PUT %q: expected 400 with the valid range, got %d %q", body, rec.Code, rec.Body.String())
+		}
+	}
+	if got := klogger.level.get(); got != 2 {
+		t.Errorf("expected rejected sets to keep level 2, got %d", got)
+	}
+
+	if rec := do(http.MethodDelete, ""); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE: expected 405, got %d", rec.Code)
+	}
+}
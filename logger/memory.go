@@ -0,0 +1,118 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// MemoryRecord is a log record captured by MemoryHandler
+type MemoryRecord struct {
+	Time    time.Time
+	Level   slog.Level
+	Message string
+	// Attrs holds the resolved attributes, keys of named groups are
+	// qualified as "group.key" and unnamed groups are inlined
+	Attrs map[string]any
+}
+
+// MemoryHandler is a slog.Handler storing every record in memory, meant
+// for asserting on logs in tests
+type MemoryHandler struct {
+	mu      *sync.Mutex
+	records *[]MemoryRecord
+	prefix  string
+	attrs   map[string]any
+}
+
+// NewMemoryHandler returns an empty MemoryHandler.
+// Use it with SetHandler, or SetLogger(slog.New(h)) on a single Klogger.
+func NewMemoryHandler() *MemoryHandler {
+	return &MemoryHandler{mu: &sync.Mutex{}, records: &[]MemoryRecord{}}
+}
+
+func (h *MemoryHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *MemoryHandler) Handle(_ context.Context, r slog.Record) error {
+	record := MemoryRecord{
+		Time:    r.Time,
+		Level:   r.Level,
+		Message: r.Message,
+		Attrs:   make(map[string]any, len(h.attrs)+r.NumAttrs()),
+	}
+	for k, v := range h.attrs {
+		record.Attrs[k] = v
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		flattenAttr(record.Attrs, h.prefix, a)
+		return true
+	})
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	*h.records = append(*h.records, record)
+	return nil
+}
+
+func (h *MemoryHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := *h
+	out.attrs = make(map[string]any, len(h.attrs)+len(attrs))
+	for k, v := range h.attrs {
+		out.attrs[k] = v
+	}
+	for _, a := range attrs {
+		flattenAttr(out.attrs, h.prefix, a)
+	}
+	return &out
+}
+
+func (h *MemoryHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	out := *h
+	out.prefix = h.prefix + name + "."
+	return &out
+}
+
+// Records returns a copy of the records captured so far, oldest first
+func (h *MemoryHandler) Records() []MemoryRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]MemoryRecord{}, *h.records...)
+}
+
+// Last returns the most recent record, or false if none was captured
+func (h *MemoryHandler) Last() (MemoryRecord, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(*h.records) == 0 {
+		return MemoryRecord{}, false
+	}
+	return (*h.records)[len(*h.records)-1], true
+}
+
+// Reset drops every captured record
+func (h *MemoryHandler) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	*h.records = (*h.records)[:0]
+}
+
+func flattenAttr(attrs map[string]any, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			flattenAttr(attrs, prefix, ga)
+		}
+		return
+	}
+	attrs[prefix+a.Key] = a.Value.Any()
+}
@@ -0,0 +1,34 @@
+package logger
+
+import (
+	"log/slog"
+	"testing"
+)
+
+func TestMemoryHandler(t *testing.T) {
+	handler := NewMemoryHandler()
+	l := &Klogger{}
+	l.SetLogger(slog.New(handler))
+
+	l.With("service", "web").Warningf("retrying %d", 2)
+	l.logger.WithGroup("http").Info("request", "status", 503)
+
+	records := handler.Records()
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	if records[0].Level != slog.LevelWarn || records[0].Message != "retrying 2" {
+		t.Errorf("expected warning \"retrying 2\", got %v %q", records[0].Level, records[0].Message)
+	}
+	if records[0].Attrs["service"] != "web" {
+		t.Errorf("expected service=web, got %v", records[0].Attrs)
+	}
+	if records[1].Attrs["http.status"] != int64(503) {
+		t.Errorf("expected http.status=503, got %v", records[1].Attrs)
+	}
+
+	handler.Reset()
+	if _, ok := handler.Last(); ok {
+		t.Errorf("expected no record after Reset, got %v", handler.Records())
+	}
+}
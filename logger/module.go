@@ -0,0 +1,22 @@
+package logger
+
+import (
+	"context"
+
+	"go.uber.org/fx"
+)
+
+// Module provides the singleton Klogger, initialized from the configuration
+// set by InitFlags and Configure, and closes it when the app stops.
+var Module = fx.Options(
+	fx.Provide(func() (*Klogger, error) {
+		return InitLogger(currentConfig())
+	}),
+	fx.Invoke(func(lc fx.Lifecycle, _ *Klogger) {
+		lc.Append(fx.Hook{
+			OnStop: func(ctx context.Context) error {
+				return Close(ctx)
+			},
+		})
+	}),
+)
@@ -0,0 +1,58 @@
+package logger
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+func TestModule(t *testing.T) {
+	resetSingleton(t)
+	if err := Configure(WithVerbosity(2)); err != nil {
+		t.Fatal(err)
+	}
+
+	var k *Klogger
+	app := fx.New(Module, fx.Populate(&k), fx.NopLogger)
+	if err := app.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if k != klogger || !initialized.Load() {
+		t.Fatalf("expected the initialized singleton, got %v", k)
+	}
+	if !k.V(2) || k.V(3) {
+		t.Errorf("expected the configured verbosity 2")
+	}
+
+	writer := &fakeWriter{}
+	RegisterCloser(writer)
+	if err := app.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if writer.closed != 1 {
+		t.Errorf("expected the registered writers closed on stop, got %d closes", writer.closed)
+	}
+}
+
+func TestModuleConcurrentConfigure(t *testing.T) {
+	resetSingleton(t)
+
+	// run with -race: the module reads the config Configure writes, the
+	// sleep letting Configure run first without ordering the two
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = Configure(WithVerbosity(1))
+	}()
+	time.Sleep(10 * time.Millisecond)
+	app := fx.New(Module, fx.NopLogger)
+	<-done
+	if err := app.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,82 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+
+	"go.uber.org/multierr"
+)
+
+// MultiHandler is a slog.Handler dispatching each record to an ordered set
+// of handlers, e.g. zap on stdout, Kafka and klog.
+type MultiHandler struct {
+	handlers []slog.Handler
+}
+
+// NewMultiHandler returns a MultiHandler dispatching to handlers in order.
+func NewMultiHandler(handlers ...slog.Handler) *MultiHandler {
+	return &MultiHandler{handlers: slices.Clone(handlers)}
+}
+
+// Handlers returns the handlers records are dispatched to.
+func (m *MultiHandler) Handlers() []slog.Handler {
+	return slices.Clone(m.handlers)
+}
+
+// Enabled reports whether any handler handles level.
+func (m *MultiHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range m.handlers {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handle dispatches r to every handler enabled for its level, even when an
+// earlier one fails, and returns their combined errors.
+func (m *MultiHandler) Handle(ctx context.Context, r slog.Record) error {
+	var err error
+	for _, h := range m.handlers {
+		if h.Enabled(ctx, r.Level) {
+			err = multierr.Append(err, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return err
+}
+
+func (m *MultiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make([]slog.Handler, len(m.handlers))
+	for i, h := range m.handlers {
+		handlers[i] = h.WithAttrs(attrs)
+	}
+	return &MultiHandler{handlers: handlers}
+}
+
+func (m *MultiHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return m
+	}
+	handlers := make([]slog.Handler, len(m.handlers))
+	for i, h := range m.handlers {
+		handlers[i] = h.WithGroup(name)
+	}
+	return &MultiHandler{handlers: handlers}
+}
+
+// AddHandler attaches h to the singleton, which then logs to its current
+// handlers and h. Loggers already derived from it log to h as well, and h
+// is kept when InitLogger replaces the default handler.
+func AddHandler(h slog.Handler) {
+	initMu.Lock()
+	defer initMu.Unlock()
+	addedHandlers = append(addedHandlers, h)
+	var handlers []slog.Handler
+	if m, ok := sink.Load().Handler.(*MultiHandler); ok {
+		handlers = m.handlers
+	} else {
+		handlers = []slog.Handler{sink.Load().Handler}
+	}
+	sink.Store(&handlerBox{NewMultiHandler(append(slices.Clip(handlers), h)...)})
+}
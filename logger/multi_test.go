@@ -0,0 +1,80 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+type failingHandler struct {
+	slog.Handler
+	err error
+}
+
+func (h failingHandler) Handle(context.Context, slog.Record) error { return h.err }
+
+func TestMultiHandler(t *testing.T) {
+	first, second := NewMemoryHandler(), NewMemoryHandler()
+	log := slog.New(NewMultiHandler(first, second))
+
+	log.Info("hello", "request", "r1")
+	for i, h := range []*MemoryHandler{first, second} {
+		r, ok := h.Last()
+		if !ok || r.Message != "hello" || r.Attrs["request"] != "r1" {
+			t.Errorf("handler %d: expected the record, got %v", i, r)
+		}
+	}
+
+	log.With("node", "n1").WithGroup("lrs").Info("grouped", "cluster", "web")
+	for i, h := range []*MemoryHandler{first, second} {
+		if attrs := lastAttrs(h); attrs["node"] != "n1" || attrs["lrs.cluster"] != "web" {
+			t.Errorf("handler %d: expected attrs and group to propagate, got %v", i, attrs)
+		}
+	}
+}
+
+func TestMultiHandlerErrors(t *testing.T) {
+	errA, errB := errors.New("a"), errors.New("b")
+	memory := NewMemoryHandler()
+	h := NewMultiHandler(failingHandler{memory, errA}, memory, failingHandler{memory, errB})
+
+	err := h.Handle(context.Background(), slog.NewRecord(time.Now(), slog.LevelInfo, "hello", 0))
+	if !errors.Is(err, errA) || !errors.Is(err, errB) {
+		t.Errorf("expected both errors, got %v", err)
+	}
+	if len(memory.Records()) != 1 {
+		t.Errorf("expected the record to reach the handler after a failing one, got %d", len(memory.Records()))
+	}
+}
+
+func TestAddHandler(t *testing.T) {
+	resetSingleton(t)
+	if _, err := InitLogger(NewConfig()); err != nil {
+		t.Fatal(err)
+	}
+	derived := With("request", "r1")
+	first, second := NewMemoryHandler(), NewMemoryHandler()
+	AddHandler(first)
+	AddHandler(second)
+
+	derived.Info("hello")
+	for i, h := range []*MemoryHandler{first, second} {
+		if attrs := lastAttrs(h); attrs["request"] != "r1" {
+			t.Errorf("handler %d: expected the derived record, got %v", i, attrs)
+		}
+	}
+	if got := len(sink.Load().Handler.(*MultiHandler).Handlers()); got != 3 {
+		t.Errorf("expected zap and both added handlers, got %d", got)
+	}
+
+	resetSingleton(t)
+	if _, err := InitLogger(NewConfig()); err != nil {
+		t.Fatal(err)
+	}
+	Info("reinitialized")
+	if r, ok := second.Last(); !ok || r.Message != "reinitialized" {
+		t.Errorf("expected added handlers to survive InitLogger, got %v", r)
+	}
+}
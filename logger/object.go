@@ -0,0 +1,21 @@
+package logger
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// WithObject adds the reference of a Kubernetes object to the logger.
+func WithObject(obj metav1.Object) *Klogger {
+	return klogger.WithObject(obj)
+}
+
+// WithObject adds the reference of a Kubernetes object to the logger,
+// as namespace, name, uid and resourceVersion.
+func (k *Klogger) WithObject(obj metav1.Object) *Klogger {
+	return k.With(
+		"namespace", obj.GetNamespace(),
+		"name", obj.GetName(),
+		"uid", string(obj.GetUID()),
+		"resourceVersion", obj.GetResourceVersion(),
+	)
+}
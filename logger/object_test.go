@@ -0,0 +1,27 @@
+package logger
+
+import (
+	"log/slog"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestWithObject(t *testing.T) {
+	handler := NewMemoryHandler()
+	l := &Klogger{}
+	l.SetLogger(slog.New(handler))
+
+	meta := metav1.ObjectMeta{Namespace: "default", Name: "web", UID: "1234", ResourceVersion: "42"}
+	for _, obj := range []metav1.Object{&corev1.Pod{ObjectMeta: meta}, &corev1.Service{ObjectMeta: meta}} {
+		l.WithObject(obj).Info("synced")
+		attrs := lastAttrs(handler)
+		want := map[string]any{"namespace": "default", "name": "web", "uid": "1234", "resourceVersion": "42"}
+		for k, v := range want {
+			if attrs[k] != v {
+				t.Errorf("%T: expected %s=%v, got %v", obj, k, v, attrs[k])
+			}
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package logger
+
+import (
+	"log/slog"
+)
+
+// Observer captures the records of the Klogger returned with it by
+// NewObserver, meant for asserting on logs in tests. Being a MemoryHandler,
+// it can also observe the singleton through SetHandler or AddHandler.
+type Observer struct {
+	*MemoryHandler
+}
+
+// NewObserver returns a Klogger, configured as the singleton, logging to
+// the returned Observer only.
+func NewObserver() (*Klogger, *Observer) {
+	o := &Observer{NewMemoryHandler()}
+	return FromSlog(slog.New(o.MemoryHandler)), o
+}
+
+// Len returns the number of records captured so far.
+func (o *Observer) Len() int {
+	return len(o.Records())
+}
+
+// FilterByLevel returns the records captured at level, oldest first.
+func (o *Observer) FilterByLevel(level slog.Level) []MemoryRecord {
+	return o.filter(func(r MemoryRecord) bool { return r.Level == level })
+}
+
+// FilterByMessage returns the records captured with msg, oldest first.
+func (o *Observer) FilterByMessage(msg string) []MemoryRecord {
+	return o.filter(func(r MemoryRecord) bool { return r.Message == msg })
+}
+
+func (o *Observer) filter(keep func(MemoryRecord) bool) []MemoryRecord {
+	var out []MemoryRecord
+	for _, r := range o.Records() {
+		if keep(r) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
@@ -0,0 +1,35 @@
+package logger
+
+import (
+	"log/slog"
+	"testing"
+)
+
+func TestObserver(t *testing.T) {
+	k, observer := NewObserver()
+
+	k.With("node", "envoy-1").Info("connected")
+	k.Warningf("slow stream %d", 3)
+	k.Errorf("stream %d failed", 1)
+	k.With("node", "envoy-2").Errorf("stream %d failed", 2)
+
+	if got := observer.Len(); got != 4 {
+		t.Fatalf("expected 4 records, got %d", got)
+	}
+	errors := observer.FilterByLevel(slog.LevelError)
+	if len(errors) != 2 || errors[0].Message != "stream 1 failed" || errors[1].Attrs["node"] != "envoy-2" {
+		t.Errorf("expected the 2 errors in order, got %v", errors)
+	}
+	if warnings := observer.FilterByLevel(slog.LevelWarn); len(warnings) != 1 {
+		t.Errorf("expected 1 warning, got %v", warnings)
+	}
+	connected := observer.FilterByMessage("connected")
+	if len(connected) != 1 || connected[0].Attrs["node"] != "envoy-1" || connected[0].Level != slog.LevelInfo {
+		t.Errorf("expected the connected info with its node, got %v", connected)
+	}
+
+	observer.Reset()
+	if observer.Len() != 0 {
+		t.Errorf("expected no record after Reset")
+	}
+}
@@ -0,0 +1,80 @@
+package logger
+
+import (
+	"log/slog"
+	"strings"
+	"sync/atomic"
+)
+
+// RedactedValue replaces the value of redacted attributes
+const RedactedValue = "***"
+
+// redactedKeys holds the lower-cased keys to redact, nil when none is registered
+var redactedKeys atomic.Pointer[map[string]struct{}]
+
+// RegisterRedactedKeys redacts, case-insensitively, the attributes with any
+// of keys logged by the singleton and its derived loggers, in nested groups
+// as well.
+func RegisterRedactedKeys(keys ...string) {
+	for {
+		current := redactedKeys.Load()
+		next := make(map[string]struct{}, len(keys))
+		if current != nil {
+			for k := range *current {
+				next[k] = struct{}{}
+			}
+		}
+		for _, k := range keys {
+			next[strings.ToLower(k)] = struct{}{}
+		}
+		if redactedKeys.CompareAndSwap(current, &next) {
+			return
+		}
+	}
+}
+
+// redactAttrs returns attrs with the registered keys redacted, attrs itself
+// when no key is registered.
+func redactAttrs(attrs []slog.Attr) []slog.Attr {
+	keys := redactedKeys.Load()
+	if keys == nil {
+		return attrs
+	}
+	out := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		out[i] = redactAttr(*keys, a)
+	}
+	return out
+}
+
+// redactRecord returns r with the registered keys redacted.
+func redactRecord(r slog.Record) slog.Record {
+	keys := redactedKeys.Load()
+	if keys == nil {
+		return r
+	}
+	out := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(redactAttr(*keys, a))
+		return true
+	})
+	return out
+}
+
+func redactAttr(keys map[string]struct{}, a slog.Attr) slog.Attr {
+	if _, ok := keys[strings.ToLower(a.Key)]; ok {
+		return slog.String(a.Key, RedactedValue)
+	}
+	if a.Value.Kind() == slog.KindLogValuer {
+		a.Value = a.Value.Resolve()
+	}
+	if a.Value.Kind() != slog.KindGroup {
+		return a
+	}
+	group := a.Value.Group()
+	attrs := make([]slog.Attr, len(group))
+	for i, ga := range group {
+		attrs[i] = redactAttr(keys, ga)
+	}
+	return slog.Attr{Key: a.Key, Value: slog.GroupValue(attrs...)}
+}
@@ -0,0 +1,51 @@
+package logger
+
+import (
+	"log/slog"
+	"testing"
+)
+
+func TestRegisterRedactedKeys(t *testing.T) {
+	Singleton()
+	box := sink.Load()
+	previous := redactedKeys.Load()
+	t.Cleanup(func() {
+		sink.Store(box)
+		redactedKeys.Store(previous)
+	})
+	handler := NewMemoryHandler()
+	SetHandler(handler)
+
+	RegisterRedactedKeys("token")
+	RegisterRedactedKeys("Password", "authorization")
+
+	assert := func(name string, want map[string]any) {
+		t.Helper()
+		attrs := lastAttrs(handler)
+		for k, v := range want {
+			if attrs[k] != v {
+				t.Errorf("%s: expected %s=%v, got %v", name, k, v, attrs)
+			}
+		}
+	}
+
+	With("Token", "s3cr3t", "name", "bob").Info("with")
+	assert("With", map[string]any{"Token": RedactedValue, "name": "bob"})
+
+	WithFields(map[string]interface{}{"token": "s3cr3t", "role": "admin"}).Info("fields")
+	assert("WithFields", map[string]any{"token": RedactedValue, "role": "admin"})
+
+	type User struct {
+		Name  string
+		Token string
+	}
+	WithAll(User{Name: "bob", Token: "s3cr3t"}).Info("all")
+	assert("WithAll", map[string]any{"Token": RedactedValue, "Name": "bob"})
+
+	InfoS("request", "AUTHORIZATION", "Bearer s3cr3t", slog.Group("basic", "user", "bob", "password", "p"))
+	assert("InfoS", map[string]any{
+		"AUTHORIZATION":  RedactedValue,
+		"basic.password": RedactedValue,
+		"basic.user":     "bob",
+	})
+}
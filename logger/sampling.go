@@ -0,0 +1,75 @@
+package logger
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// WithSampling returns an option to sample repetitive logs: each second, the
+// first initial records with the same level and message are kept, then every
+// thereafter-th one. It replaces the zap production sampling, 100 and 100.
+func WithSampling(initial, thereafter int) Option {
+	return func(c *Config) {
+		c.sampling = &zap.SamplingConfig{Initial: initial, Thereafter: thereafter}
+	}
+}
+
+// WithSamplingBypass returns an option to never sample records at level or
+// above, e.g. zapcore.ErrorLevel to keep every error.
+func WithSamplingBypass(level zapcore.Level) Option {
+	return func(c *Config) {
+		c.samplingBypass = &level
+	}
+}
+
+// sampleCore samples the records of core below bypass, or all of them if nil.
+func sampleCore(core zapcore.Core, sampling *zap.SamplingConfig, bypass *zapcore.Level) zapcore.Core {
+	sampled := zapcore.NewSamplerWithOptions(core, time.Second, sampling.Initial, sampling.Thereafter)
+	if bypass == nil {
+		return sampled
+	}
+	return zapcore.NewTee(
+		&levelCore{Core: sampled, enabled: func(l zapcore.Level) bool { return l < *bypass }},
+		&levelCore{Core: core, enabled: func(l zapcore.Level) bool { return l >= *bypass }},
+	)
+}
+
+// levelCore restricts core to the levels enabled reports
+type levelCore struct {
+	zapcore.Core
+	enabled func(zapcore.Level) bool
+}
+
+func (c *levelCore) Enabled(l zapcore.Level) bool {
+	return c.enabled(l) && c.Core.Enabled(l)
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields), enabled: c.enabled}
+}
+
+func (c *levelCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.enabled(ent.Level) {
+		return ce
+	}
+	return c.Core.Check(ent, ce)
+}
+
+// everyNCounters holds the call count of each EveryN key
+var everyNCounters sync.Map
+
+// EveryN returns true on the first call with key and then on every n-th one,
+// to log 1 in n of a noisy per-object event without sampling every record,
+// e.g. EveryN("pod/"+name, 100).Infof(...). n below 2 always returns true.
+// Counters are never dropped, keys should come from a bounded set.
+func EveryN(key string, n int) Verbose {
+	if n < 2 {
+		return true
+	}
+	counter, _ := everyNCounters.LoadOrStore(key, new(atomic.Uint64))
+	return Verbose((counter.(*atomic.Uint64).Add(1)-1)%uint64(n) == 0)
+}
@@ -0,0 +1,88 @@
+package logger
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestSampleCore(t *testing.T) {
+	const raw = 1000
+	bypass := zapcore.ErrorLevel
+	observed, logs := observer.New(zapcore.DebugLevel)
+	log := zap.New(sampleCore(observed, &zap.SamplingConfig{Initial: 10, Thereafter: 100}, &bypass))
+
+	for i := 0; i < raw; i++ {
+		log.Info("event storm", zap.Int("i", i))
+		log.Error("failure")
+	}
+
+	infos := logs.FilterMessage("event storm").Len()
+	if infos >= raw || infos < 10 {
+		t.Errorf("expected between 10 and %d sampled infos, got %d", raw, infos)
+	}
+	if errors := logs.FilterMessage("failure").Len(); errors != raw {
+		t.Errorf("expected every error to bypass sampling, got %d of %d", errors, raw)
+	}
+
+	observed, logs = observer.New(zapcore.DebugLevel)
+	log = zap.New(sampleCore(observed, &zap.SamplingConfig{Initial: 10, Thereafter: 100}, nil)).With(zap.String("k", "v"))
+	for i := 0; i < raw; i++ {
+		log.Error("failure")
+	}
+	if errors := logs.Len(); errors >= raw {
+		t.Errorf("expected errors to be sampled without bypass, got %d", errors)
+	}
+}
+
+func TestInitLoggerSampling(t *testing.T) {
+	resetSingleton(t)
+	if _, err := InitLogger(NewConfig(WithSampling(1, 10), WithSamplingBypass(zapcore.ErrorLevel))); err != nil {
+		t.Fatal(err)
+	}
+	if klogger.config.zapConfig.Sampling != nil {
+		t.Errorf("expected the zap sampling replaced, got %+v", klogger.config.zapConfig.Sampling)
+	}
+}
+
+func TestEveryN(t *testing.T) {
+	var got []bool
+	for i := 0; i < 7; i++ {
+		got = append(got, bool(EveryN("test/every-3", 3)))
+	}
+	want := []bool{true, false, false, true, false, false, true}
+	if !slices.Equal(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	if !EveryN("test/other", 3) {
+		t.Errorf("expected the first call of another key to return true")
+	}
+	if !EveryN("test/every-1", 1) || !EveryN("test/every-1", 1) {
+		t.Errorf("expected n of 1 to always return true")
+	}
+
+	var (
+		wg   sync.WaitGroup
+		hits atomic.Int64
+	)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if EveryN("test/concurrent", 10) {
+					hits.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if hits.Load() != 100 {
+		t.Errorf("expected exactly 100 of 1000 concurrent calls to return true, got %d", hits.Load())
+	}
+}
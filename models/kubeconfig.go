@@ -1,19 +1,35 @@
 package models
 
 type Kubeconfig struct {
-	APIVersion     string    `yaml:"apiVersion,omitempty" json:"apiVersion,omitempty"`
-	Clusters       []Cluster `yaml:"clusters,omitempty" json:"clusters,omitempty"`
-	Contexts       []Context `yaml:"contexts,omitempty" json:"contexts,omitempty"`
-	CurrentContext string    `yaml:"current-context,omitempty" json:"current-context,omitempty"`
-	Kind           string    `yaml:"kind,omitempty" json:"kind,omitempty"`
-	Users          []User    `yaml:"users,omitempty" json:"users,omitempty"`
+	APIVersion     string       `yaml:"apiVersion,omitempty" json:"apiVersion,omitempty"`
+	Clusters       []Cluster    `yaml:"clusters,omitempty" json:"clusters,omitempty"`
+	Contexts       []Context    `yaml:"contexts,omitempty" json:"contexts,omitempty"`
+	CurrentContext string       `yaml:"current-context,omitempty" json:"current-context,omitempty"`
+	Kind           string       `yaml:"kind,omitempty" json:"kind,omitempty"`
+	Preferences    *Preferences `yaml:"preferences,omitempty" json:"preferences,omitempty"`
+	Users          []User       `yaml:"users,omitempty" json:"users,omitempty"`
+	// sources are the files the kubeconfig was loaded from, none when parsed
+	sources []string
+}
+
+// Preferences holds the kubectl preferences of a kubeconfig.
+type Preferences struct {
+	Colors bool `yaml:"colors,omitempty" json:"colors,omitempty"`
 }
 
 type Cluster struct {
 	Name    string `yaml:"name,omitempty" json:"name,omitempty"`
 	Cluster struct {
-		Server string `yaml:"server,omitempty" json:"server,omitempty"`
+		Server                   string `yaml:"server,omitempty" json:"server,omitempty"`
+		TLSServerName            string `yaml:"tls-server-name,omitempty" json:"tls-server-name,omitempty"`
+		CertificateAuthority     string `yaml:"certificate-authority,omitempty" json:"certificate-authority,omitempty"`
+		CertificateAuthorityData string `yaml:"certificate-authority-data,omitempty" json:"certificate-authority-data,omitempty"`
+		InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify,omitempty" json:"insecure-skip-tls-verify,omitempty"`
+		ProxyURL                 string `yaml:"proxy-url,omitempty" json:"proxy-url,omitempty"`
 	} `yaml:"cluster,omitempty" json:"cluster,omitempty"`
+	// dir is the directory of the file the cluster was loaded from, against
+	// which its relative paths resolve
+	dir string
 }
 
 type Context struct {
@@ -28,6 +44,106 @@ type Context struct {
 type User struct {
 	Name string `yaml:"name,omitempty" json:"name,omitempty"`
 	User struct {
-		Token string `yaml:"token,omitempty" json:"token,omitempty"`
+		Token                 string      `yaml:"token,omitempty" json:"token,omitempty"`
+		TokenFile             string      `yaml:"tokenFile,omitempty" json:"tokenFile,omitempty"`
+		ClientCertificate     string      `yaml:"client-certificate,omitempty" json:"client-certificate,omitempty"`
+		ClientCertificateData string      `yaml:"client-certificate-data,omitempty" json:"client-certificate-data,omitempty"`
+		ClientKey             string      `yaml:"client-key,omitempty" json:"client-key,omitempty"`
+		ClientKeyData         string      `yaml:"client-key-data,omitempty" json:"client-key-data,omitempty"`
+		Username              string      `yaml:"username,omitempty" json:"username,omitempty"`
+		Password              string      `yaml:"password,omitempty" json:"password,omitempty"`
+		Exec                  *ExecConfig `yaml:"exec,omitempty" json:"exec,omitempty"`
 	} `yaml:"user,omitempty" json:"user,omitempty"`
+	// dir is the directory of the file the user was loaded from, against
+	// which its relative paths resolve
+	dir string
+}
+
+// ExecConfig is the credential plugin a user runs to get its credentials.
+type ExecConfig struct {
+	APIVersion         string       `yaml:"apiVersion,omitempty" json:"apiVersion,omitempty"`
+	Command            string       `yaml:"command,omitempty" json:"command,omitempty"`
+	Args               []string     `yaml:"args,omitempty" json:"args,omitempty"`
+	Env                []ExecEnvVar `yaml:"env,omitempty" json:"env,omitempty"`
+	InstallHint        string       `yaml:"installHint,omitempty" json:"installHint,omitempty"`
+	InteractiveMode    string       `yaml:"interactiveMode,omitempty" json:"interactiveMode,omitempty"`
+	ProvideClusterInfo bool         `yaml:"provideClusterInfo,omitempty" json:"provideClusterInfo,omitempty"`
+}
+
+// ExecEnvVar is an environment variable set for a credential plugin.
+type ExecEnvVar struct {
+	Name  string `yaml:"name" json:"name"`
+	Value string `yaml:"value" json:"value"`
+}
+
+// RedactedValue replaces secret values in a KubeconfigView
+const RedactedValue = "***"
+
+// KubeconfigView is a flattened, secret-free view of a Kubeconfig,
+// suitable for serving over an HTTP API.
+type KubeconfigView struct {
+	// CurrentContext is the name of the active context
+	CurrentContext string `json:"currentContext,omitempty"`
+	// Clusters lists the known clusters
+	Clusters []ClusterView `json:"clusters"`
+	// Contexts lists the known contexts
+	Contexts []ContextView `json:"contexts"`
+	// Users lists the known users with credentials redacted
+	Users []UserView `json:"users"`
+}
+
+// ClusterView is the flattened view of a Cluster.
+type ClusterView struct {
+	Name   string `json:"name"`
+	Server string `json:"server,omitempty"`
+}
+
+// ContextView is the flattened view of a Context.
+type ContextView struct {
+	Name      string `json:"name"`
+	Cluster   string `json:"cluster,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	User      string `json:"user,omitempty"`
+	// Current reports whether this is the current context
+	Current bool `json:"current"`
+}
+
+// UserView is the flattened view of a User.
+type UserView struct {
+	Name string `json:"name"`
+	// Token is RedactedValue when the user has a token, empty otherwise
+	Token string `json:"token,omitempty"`
+}
+
+// ToView returns the flattened view of the kubeconfig with secrets redacted.
+func (k *Kubeconfig) ToView() KubeconfigView {
+	view := KubeconfigView{
+		CurrentContext: k.CurrentContext,
+		Clusters:       make([]ClusterView, 0, len(k.Clusters)),
+		Contexts:       make([]ContextView, 0, len(k.Contexts)),
+		Users:          make([]UserView, 0, len(k.Users)),
+	}
+	for _, c := range k.Clusters {
+		view.Clusters = append(view.Clusters, ClusterView{
+			Name:   c.Name,
+			Server: c.Cluster.Server,
+		})
+	}
+	for _, c := range k.Contexts {
+		view.Contexts = append(view.Contexts, ContextView{
+			Name:      c.Name,
+			Cluster:   c.Context.Cluster,
+			Namespace: c.Context.Namespace,
+			User:      c.Context.User,
+			Current:   c.Name == k.CurrentContext,
+		})
+	}
+	for _, u := range k.Users {
+		user := UserView{Name: u.Name}
+		if u.User.Token != "" {
+			user.Token = RedactedValue
+		}
+		view.Users = append(view.Users, user)
+	}
+	return view
 }
@@ -0,0 +1,246 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ParseKubeconfig decodes a kubeconfig from its YAML data, as read from a
+// file, a secret or an environment variable. It fails unless the kubeconfig
+// has clusters, contexts and a current context naming one of them.
+// Its relative file paths resolve against the working directory.
+func ParseKubeconfig(data []byte) (*Kubeconfig, error) {
+	k := &Kubeconfig{}
+	if err := yaml.Unmarshal(data, k); err != nil {
+		return nil, fmt.Errorf("invalid kubeconfig: %w", err)
+	}
+	if err := k.Validate(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Validate checks that the kubeconfig has clusters, contexts and a current
+// context naming one of them.
+func (k *Kubeconfig) Validate() error {
+	switch {
+	case len(k.Clusters) == 0:
+		return errors.New("invalid kubeconfig: no clusters")
+	case len(k.Contexts) == 0:
+		return errors.New("invalid kubeconfig: no contexts")
+	case k.CurrentContext == "":
+		return errors.New("invalid kubeconfig: no current-context")
+	}
+	for _, c := range k.Contexts {
+		if c.Name == k.CurrentContext {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid kubeconfig: current-context %q not found in contexts", k.CurrentContext)
+}
+
+// LoadMergedKubeconfig loads the kubeconfig files listed in $KUBECONFIG, or
+// ~/.kube/config when unset, and merges them in order with MergeKubeconfigs,
+// as kubectl does.
+// Files that do not exist are skipped, as long as one does. The relative
+// file paths of a cluster or user resolve against the directory of its file.
+func LoadMergedKubeconfig() (*Kubeconfig, error) {
+	paths, err := kubeconfigPaths()
+	if err != nil {
+		return nil, err
+	}
+	var configs []*Kubeconfig
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		k := &Kubeconfig{}
+		if err := yaml.Unmarshal(data, k); err != nil {
+			return nil, fmt.Errorf("invalid kubeconfig %s: %w", path, err)
+		}
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		k.setDir(filepath.Dir(abs))
+		k.sources = []string{abs}
+		configs = append(configs, k)
+	}
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("no kubeconfig found in %s", strings.Join(paths, string(filepath.ListSeparator)))
+	}
+	k := MergeKubeconfigs(configs...)
+	if err := k.Validate(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// setDir records dir as the directory the clusters and users were loaded from.
+func (k *Kubeconfig) setDir(dir string) {
+	for i := range k.Clusters {
+		k.Clusters[i].dir = dir
+	}
+	for i := range k.Users {
+		k.Users[i].dir = dir
+	}
+}
+
+// kubeconfigPaths returns the kubeconfig files listed in $KUBECONFIG, or
+// ~/.kube/config when unset.
+func kubeconfigPaths() ([]string, error) {
+	var paths []string
+	for _, path := range filepath.SplitList(os.Getenv("KUBECONFIG")) {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) > 0 {
+		return paths, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	return []string{filepath.Join(home, ".kube", "config")}, nil
+}
+
+// MergeKubeconfigs combines the clusters, contexts and users of configs
+// with the precedence kubectl documents for $KUBECONFIG: the first config to
+// set a value wins, an entry of a later one with the same name being ignored,
+// and so does the first non-empty current context, api version and kind.
+func MergeKubeconfigs(configs ...*Kubeconfig) *Kubeconfig {
+	out := &Kubeconfig{}
+	for _, k := range configs {
+		if out.APIVersion == "" {
+			out.APIVersion = k.APIVersion
+		}
+		if out.Kind == "" {
+			out.Kind = k.Kind
+		}
+		if out.CurrentContext == "" {
+			out.CurrentContext = k.CurrentContext
+		}
+		out.Clusters = mergeNamed(out.Clusters, k.Clusters, func(c Cluster) string { return c.Name })
+		out.Contexts = mergeNamed(out.Contexts, k.Contexts, func(c Context) string { return c.Name })
+		out.Users = mergeNamed(out.Users, k.Users, func(u User) string { return u.Name })
+		out.sources = append(out.sources, k.sources...)
+	}
+	return out
+}
+
+// mergeNamed appends to items those of others whose name they do not have yet.
+func mergeNamed[T any](items, others []T, name func(T) string) []T {
+	for _, o := range others {
+		if !slices.ContainsFunc(items, func(item T) bool { return name(item) == name(o) }) {
+			items = append(items, o)
+		}
+	}
+	return items
+}
+
+// SetCurrentContext makes name the current context, as
+// kubectl config use-context does. It fails if no context has that name.
+func (k *Kubeconfig) SetCurrentContext(name string) error {
+	if _, ok := k.context(name); !ok {
+		return fmt.Errorf("context %q not found", name)
+	}
+	k.CurrentContext = name
+	return nil
+}
+
+// Save writes the current context back to the kubeconfig file it was loaded
+// from, as kubectl config use-context does, leaving the rest of the file as
+// is. It fails when the kubeconfig was parsed from bytes, or merged from
+// several files, whose entries must not be written all into one.
+// The file is replaced atomically: it is written to a temporary file in the
+// same directory, then renamed over the file, readable by the owner only.
+func (k *Kubeconfig) Save() error {
+	switch len(k.sources) {
+	case 0:
+		return errors.New("kubeconfig not loaded from a file")
+	case 1:
+	default:
+		return fmt.Errorf("kubeconfig merged from %d files: %s", len(k.sources), strings.Join(k.sources, ", "))
+	}
+	path := k.sources[0]
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if data, err = setCurrentContext(data, k.CurrentContext); err != nil {
+		return fmt.Errorf("invalid kubeconfig %s: %w", path, err)
+	}
+	return writeFileAtomic(path, data)
+}
+
+// setCurrentContext returns the kubeconfig data with its current-context set
+// to name, keeping the other fields and the comments.
+func setCurrentContext(data []byte, name string) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, errors.New("expect a mapping")
+	}
+	value := &yaml.Node{Kind: yaml.ScalarNode, Value: name}
+	found := false
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "current-context" {
+			root.Content[i+1] = value
+			found = true
+		}
+	}
+	if !found {
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "current-context"}, value)
+	}
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// writeFileAtomic writes data to a temporary file in the directory of path,
+// readable by the owner only, then renames it over path.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,283 @@
+package models
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const validKubeconfig = `
+apiVersion: v1
+kind: Config
+current-context: prod
+clusters:
+- name: prod-cluster
+  cluster:
+    server: https://prod.example.com:6443
+contexts:
+- name: prod
+  context:
+    cluster: prod-cluster
+    namespace: default
+    user: admin
+users:
+- name: admin
+  user:
+    token: s3cr3t-token
+`
+
+func TestParseKubeconfig(t *testing.T) {
+	k, err := ParseKubeconfig([]byte(validKubeconfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.CurrentContext != "prod" || k.Kind != "Config" {
+		t.Errorf("unexpected kubeconfig: %+v", k)
+	}
+	if len(k.Clusters) != 1 || k.Clusters[0].Cluster.Server != "https://prod.example.com:6443" {
+		t.Errorf("unexpected clusters: %+v", k.Clusters)
+	}
+	if len(k.Contexts) != 1 || k.Contexts[0].Context.Namespace != "default" || k.Contexts[0].Context.User != "admin" {
+		t.Errorf("unexpected contexts: %+v", k.Contexts)
+	}
+	if len(k.Users) != 1 || k.Users[0].User.Token != "s3cr3t-token" {
+		t.Errorf("unexpected users: %+v", k.Users)
+	}
+}
+
+func TestParseKubeconfigInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"malformed yaml", "clusters: [\n", "invalid kubeconfig: yaml"},
+		{"wrong type", "clusters: prod", "invalid kubeconfig: yaml"},
+		{"empty", "", "no clusters"},
+		{"no contexts", strings.Split(validKubeconfig, "contexts:")[0], "no contexts"},
+		{"no current context", strings.Replace(validKubeconfig, "current-context: prod", "", 1), "no current-context"},
+		{"unknown current context", strings.Replace(validKubeconfig, "current-context: prod", "current-context: dev", 1), `current-context "dev" not found`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseKubeconfig([]byte(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestLoadMergedKubeconfig(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base")
+	override := filepath.Join(dir, "override")
+	if err := os.WriteFile(base, []byte(validKubeconfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(override, []byte(`
+current-context: dev
+clusters:
+- name: dev-cluster
+  cluster:
+    server: https://dev.example.com:6443
+contexts:
+- name: prod
+  context:
+    cluster: prod-cluster
+    namespace: payments
+    user: admin
+- name: dev
+  context:
+    cluster: dev-cluster
+    user: admin
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing")
+
+	tests := []struct {
+		name        string
+		paths       []string
+		wantCurrent string
+		wantNS      string
+	}{
+		{"base first", []string{base, missing, override}, "prod", "default"},
+		{"override first", []string{override, missing, base}, "dev", "payments"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("KUBECONFIG", strings.Join(tt.paths, string(filepath.ListSeparator)))
+
+			k, err := LoadMergedKubeconfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if k.CurrentContext != tt.wantCurrent || k.APIVersion != "v1" {
+				t.Errorf("unexpected kubeconfig: %+v", k)
+			}
+			if len(k.Clusters) != 2 {
+				t.Errorf("unexpected clusters: %+v", k.Clusters)
+			}
+			if len(k.Contexts) != 2 {
+				t.Fatalf("unexpected contexts: %+v", k.Contexts)
+			}
+			prod, _ := k.context("prod")
+			if ns := prod.Context.Namespace; ns != tt.wantNS {
+				t.Errorf("expected the first file to set context prod, got namespace %q", ns)
+			}
+			if len(k.Users) != 1 || k.Users[0].User.Token != "s3cr3t-token" {
+				t.Errorf("unexpected users: %+v", k.Users)
+			}
+		})
+	}
+}
+
+func TestLoadMergedKubeconfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	malformed := filepath.Join(dir, "malformed")
+	if err := os.WriteFile(malformed, []byte("clusters: [\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		kubeconfig string
+		want       string
+	}{
+		{"missing", filepath.Join(dir, "missing"), "no kubeconfig found"},
+		{"malformed", malformed, "invalid kubeconfig " + malformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("KUBECONFIG", tt.kubeconfig)
+			_, err := LoadMergedKubeconfig()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestSetCurrentContext(t *testing.T) {
+	k := testKubeconfig()
+	if err := k.SetCurrentContext("dev"); err != nil {
+		t.Fatal(err)
+	}
+	if k.CurrentContext != "dev" {
+		t.Errorf("expected current context dev, got %s", k.CurrentContext)
+	}
+	if err := k.SetCurrentContext("staging"); err == nil || !strings.Contains(err.Error(), `context "staging" not found`) {
+		t.Errorf("expected an unknown context error, got %v", err)
+	}
+	if k.CurrentContext != "dev" {
+		t.Errorf("expected an unknown context to leave dev current, got %s", k.CurrentContext)
+	}
+}
+
+func TestKubeconfigSave(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config")
+	original := `# managed by hand
+apiVersion: v1
+current-context: prod
+clusters:
+- name: prod-cluster
+  cluster:
+    server: https://prod.example.com:6443
+    certificate-authority: ca.crt
+contexts:
+- name: prod
+  context:
+    cluster: prod-cluster
+    user: admin
+- name: dev
+  context:
+    cluster: prod-cluster
+    user: admin
+users:
+- name: admin
+  user:
+    token: s3cr3t-token
+`
+	if err := os.WriteFile(path, []byte(original), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("KUBECONFIG", path)
+	k, err := LoadMergedKubeconfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.SetCurrentContext("dev"); err != nil {
+		t.Fatal(err)
+	}
+	// in-memory changes other than the current context are not saved
+	k.Users[0].User.Token = "changed"
+	if err := k.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := ParseKubeconfig(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := ParseKubeconfig([]byte(strings.Replace(original, "current-context: prod", "current-context: dev", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(saved, want) || !strings.HasPrefix(string(data), "# managed by hand\n") {
+		t.Errorf("expected only the current context changed, got %q", data)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("expected mode 0600, got %v %v", info, err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("expected the temporary file removed, got %v", entries)
+	}
+}
+
+func TestKubeconfigSaveRefused(t *testing.T) {
+	dir := t.TempDir()
+	base, dev := filepath.Join(dir, "base"), filepath.Join(dir, "dev")
+	for _, path := range []string{base, dev} {
+		if err := os.WriteFile(path, []byte(validKubeconfig), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("KUBECONFIG", strings.Join([]string{base, dev}, string(filepath.ListSeparator)))
+	merged, err := LoadMergedKubeconfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseKubeconfig([]byte(validKubeconfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		k    *Kubeconfig
+		want string
+	}{
+		{"parsed", parsed, "not loaded from a file"},
+		{"merged", merged, "merged from 2 files"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.k.Save(); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+	for _, path := range []string{base, dev} {
+		if data, _ := os.ReadFile(path); string(data) != validKubeconfig {
+			t.Errorf("expected %s untouched, got %q", path, data)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package models
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+
+	"k8s.io/client-go/rest"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// RestConfigForContext returns the client configuration of the context name,
+// the current context when empty, from the server and CA of its cluster and
+// the credentials of its user: token, client certificate, basic auth or exec
+// plugin. Relative file paths resolve against the directory of the
+// kubeconfig file that set them. It fails when the context, or the cluster
+// or user it references, is missing.
+func (k *Kubeconfig) RestConfigForContext(name string) (*rest.Config, error) {
+	if name == "" {
+		name = k.CurrentContext
+	}
+	if name == "" {
+		return nil, fmt.Errorf("no context given and no current-context set")
+	}
+	ctx, ok := k.context(name)
+	if !ok {
+		return nil, fmt.Errorf("context %q not found", name)
+	}
+	cluster, ok := k.cluster(ctx.Context.Cluster)
+	if !ok {
+		return nil, fmt.Errorf("context %q: cluster %q not found", name, ctx.Context.Cluster)
+	}
+	if cluster.Cluster.Server == "" {
+		return nil, fmt.Errorf("context %q: cluster %q has no server", name, cluster.Name)
+	}
+
+	config := &rest.Config{
+		Host: cluster.Cluster.Server,
+		TLSClientConfig: rest.TLSClientConfig{
+			Insecure:   cluster.Cluster.InsecureSkipTLSVerify,
+			ServerName: cluster.Cluster.TLSServerName,
+			CAFile:     resolvePath(cluster.dir, cluster.Cluster.CertificateAuthority),
+		},
+	}
+	var err error
+	if config.CAData, err = decodeData(cluster.Cluster.CertificateAuthorityData); err != nil {
+		return nil, fmt.Errorf("context %q: cluster %q certificate-authority-data: %w", name, cluster.Name, err)
+	}
+	if cluster.Cluster.ProxyURL != "" {
+		proxy, err := url.Parse(cluster.Cluster.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("context %q: cluster %q proxy-url: %w", name, cluster.Name, err)
+		}
+		config.Proxy = http.ProxyURL(proxy)
+	}
+
+	if ctx.Context.User == "" {
+		return config, nil
+	}
+	user, ok := k.user(ctx.Context.User)
+	if !ok {
+		return nil, fmt.Errorf("context %q: user %q not found", name, ctx.Context.User)
+	}
+	config.BearerToken = user.User.Token
+	config.BearerTokenFile = resolvePath(user.dir, user.User.TokenFile)
+	config.Username = user.User.Username
+	config.Password = user.User.Password
+	config.CertFile = resolvePath(user.dir, user.User.ClientCertificate)
+	config.KeyFile = resolvePath(user.dir, user.User.ClientKey)
+	if config.CertData, err = decodeData(user.User.ClientCertificateData); err != nil {
+		return nil, fmt.Errorf("context %q: user %q client-certificate-data: %w", name, user.Name, err)
+	}
+	if config.KeyData, err = decodeData(user.User.ClientKeyData); err != nil {
+		return nil, fmt.Errorf("context %q: user %q client-key-data: %w", name, user.Name, err)
+	}
+	if exec := user.User.Exec; exec != nil {
+		config.ExecProvider = exec.toClientcmd()
+	}
+	return config, nil
+}
+
+func (k *Kubeconfig) context(name string) (Context, bool) {
+	for _, c := range k.Contexts {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Context{}, false
+}
+
+func (k *Kubeconfig) cluster(name string) (Cluster, bool) {
+	for _, c := range k.Clusters {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Cluster{}, false
+}
+
+func (k *Kubeconfig) user(name string) (User, bool) {
+	for _, u := range k.Users {
+		if u.Name == name {
+			return u, true
+		}
+	}
+	return User{}, false
+}
+
+func (e *ExecConfig) toClientcmd() *clientcmdapi.ExecConfig {
+	out := &clientcmdapi.ExecConfig{
+		APIVersion:         e.APIVersion,
+		Command:            e.Command,
+		Args:               e.Args,
+		InstallHint:        e.InstallHint,
+		InteractiveMode:    clientcmdapi.ExecInteractiveMode(e.InteractiveMode),
+		ProvideClusterInfo: e.ProvideClusterInfo,
+	}
+	if out.InteractiveMode == "" {
+		out.InteractiveMode = clientcmdapi.IfAvailableExecInteractiveMode
+	}
+	for _, env := range e.Env {
+		out.Env = append(out.Env, clientcmdapi.ExecEnvVar{Name: env.Name, Value: env.Value})
+	}
+	return out
+}
+
+// resolvePath resolves the relative path against dir, if known.
+func resolvePath(dir, path string) string {
+	if path == "" || dir == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// decodeData decodes the base64 value of a kubeconfig *-data field.
+func decodeData(data string) ([]byte, error) {
+	if data == "" {
+		return nil, nil
+	}
+	return base64.StdEncoding.DecodeString(data)
+}
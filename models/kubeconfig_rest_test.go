@@ -0,0 +1,125 @@
+package models
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRestConfigForContextToken(t *testing.T) {
+	for _, name := range []string{"", "prod"} {
+		config, err := testKubeconfig().RestConfigForContext(name)
+		if err != nil {
+			t.Fatalf("context %q: %s", name, err)
+		}
+		if config.Host != "https://prod.example.com:6443" || config.BearerToken != "s3cr3t-token" {
+			t.Errorf("context %q: unexpected config %+v", name, config)
+		}
+	}
+}
+
+func TestRestConfigForContextCertificates(t *testing.T) {
+	k, err := ParseKubeconfig([]byte(realisticKubeconfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eks, err := k.RestConfigForContext("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(eks.CAData), "-----BEGIN CERTIFICATE") {
+		t.Errorf("expected decoded CA data, got %q", eks.CAData)
+	}
+	if eks.ExecProvider == nil || eks.ExecProvider.Command != "aws" || eks.ExecProvider.Env[0].Value != "prod" {
+		t.Errorf("expected the aws exec provider, got %+v", eks.ExecProvider)
+	}
+
+	kind, err := k.RestConfigForContext("kind")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !kind.Insecure || string(kind.CertData) != "cert" || string(kind.KeyData) != "key" {
+		t.Errorf("unexpected kind config %+v", kind.TLSClientConfig)
+	}
+}
+
+func TestRestConfigForContextRelativePaths(t *testing.T) {
+	kubedir := filepath.Join(t.TempDir(), "kube")
+	if err := os.Mkdir(kubedir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(kubedir, "config")
+	if err := os.WriteFile(path, []byte(`
+apiVersion: v1
+current-context: dev
+clusters:
+- name: dev-cluster
+  cluster:
+    server: https://dev.example.com:6443
+    certificate-authority: certs/ca.crt
+contexts:
+- name: dev
+  context:
+    cluster: dev-cluster
+    user: dev
+users:
+- name: dev
+  user:
+    tokenFile: token
+    client-certificate: certs/client.crt
+    client-key: /etc/kube/client.key
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("KUBECONFIG", path)
+
+	k, err := LoadMergedKubeconfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := k.RestConfigForContext("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		field, got, want string
+	}{
+		{"certificate-authority", config.CAFile, filepath.Join(kubedir, "certs", "ca.crt")},
+		{"tokenFile", config.BearerTokenFile, filepath.Join(kubedir, "token")},
+		{"client-certificate", config.CertFile, filepath.Join(kubedir, "certs", "client.crt")},
+		{"client-key", config.KeyFile, "/etc/kube/client.key"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.field, tt.want, tt.got)
+		}
+	}
+}
+
+func TestRestConfigForContextErrors(t *testing.T) {
+	k := testKubeconfig()
+	k.Contexts = append(k.Contexts, Context{Name: "orphan"}, Context{Name: "stranger"})
+	k.Contexts[2].Context.Cluster = "missing-cluster"
+	k.Contexts[3].Context.Cluster = "prod-cluster"
+	k.Contexts[3].Context.User = "missing-user"
+
+	tests := []struct {
+		name    string
+		context string
+		want    string
+	}{
+		{"missing context", "staging", `context "staging" not found`},
+		{"missing cluster", "orphan", `cluster "missing-cluster" not found`},
+		{"missing user", "stranger", `user "missing-user" not found`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := k.RestConfigForContext(tt.context)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
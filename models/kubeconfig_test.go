@@ -0,0 +1,159 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func testKubeconfig() *Kubeconfig {
+	k := &Kubeconfig{
+		APIVersion:     "v1",
+		Kind:           "Config",
+		CurrentContext: "prod",
+		Clusters:       make([]Cluster, 1),
+		Contexts:       make([]Context, 2),
+		Users:          make([]User, 2),
+	}
+	k.Clusters[0].Name = "prod-cluster"
+	k.Clusters[0].Cluster.Server = "https://prod.example.com:6443"
+	k.Contexts[0].Name = "prod"
+	k.Contexts[0].Context.Cluster = "prod-cluster"
+	k.Contexts[0].Context.Namespace = "default"
+	k.Contexts[0].Context.User = "admin"
+	k.Contexts[1].Name = "dev"
+	k.Contexts[1].Context.Cluster = "prod-cluster"
+	k.Contexts[1].Context.User = "anonymous"
+	k.Users[0].Name = "admin"
+	k.Users[0].User.Token = "s3cr3t-token"
+	k.Users[1].Name = "anonymous"
+	return k
+}
+
+func TestKubeconfigToView(t *testing.T) {
+	view := testKubeconfig().ToView()
+
+	if view.CurrentContext != "prod" {
+		t.Errorf("expected current context prod, got %s", view.CurrentContext)
+	}
+	if len(view.Clusters) != 1 || view.Clusters[0].Server != "https://prod.example.com:6443" {
+		t.Errorf("unexpected clusters: %+v", view.Clusters)
+	}
+	if len(view.Contexts) != 2 {
+		t.Fatalf("expected 2 contexts, got %d", len(view.Contexts))
+	}
+	if c := view.Contexts[0]; !c.Current || c.Cluster != "prod-cluster" || c.Namespace != "default" || c.User != "admin" {
+		t.Errorf("unexpected context: %+v", c)
+	}
+	if view.Contexts[1].Current {
+		t.Errorf("context dev should not be current")
+	}
+	if len(view.Users) != 2 {
+		t.Fatalf("expected 2 users, got %d", len(view.Users))
+	}
+	if view.Users[0].Token != RedactedValue {
+		t.Errorf("expected token to be redacted, got %q", view.Users[0].Token)
+	}
+	if view.Users[1].Token != "" {
+		t.Errorf("expected empty token, got %q", view.Users[1].Token)
+	}
+}
+
+func TestKubeconfigToViewJSONHasNoSecrets(t *testing.T) {
+	data, err := json.Marshal(testKubeconfig().ToView())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "s3cr3t-token") {
+		t.Errorf("secret leaked into view: %s", data)
+	}
+}
+
+const realisticKubeconfig = `apiVersion: v1
+kind: Config
+preferences:
+  colors: true
+current-context: eks
+clusters:
+- name: eks-cluster
+  cluster:
+    server: https://ABCDEF.gr7.eu-west-1.eks.amazonaws.com
+    certificate-authority-data: LS0tLS1CRUdJTiBDRVJUSUZJQ0FURS0tLS0t
+- name: kind-cluster
+  cluster:
+    server: https://127.0.0.1:6443
+    insecure-skip-tls-verify: true
+contexts:
+- name: eks
+  context:
+    cluster: eks-cluster
+    user: eks-user
+- name: kind
+  context:
+    cluster: kind-cluster
+    user: kind-user
+users:
+- name: eks-user
+  user:
+    exec:
+      apiVersion: client.authentication.k8s.io/v1beta1
+      command: aws
+      args:
+      - eks
+      - get-token
+      - --cluster-name
+      - prod
+      env:
+      - name: AWS_PROFILE
+        value: prod
+      interactiveMode: IfAvailable
+- name: kind-user
+  user:
+    client-certificate-data: Y2VydA==
+    client-key-data: a2V5
+`
+
+func TestKubeconfigRoundTrip(t *testing.T) {
+	k, err := ParseKubeconfig([]byte(realisticKubeconfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := yaml.Marshal(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ParseKubeconfig(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, k) {
+		t.Errorf("round trip changed the kubeconfig:\n%s", data)
+	}
+
+	if ca := got.Clusters[0].Cluster.CertificateAuthorityData; ca != "LS0tLS1CRUdJTiBDRVJUSUZJQ0FURS0tLS0t" {
+		t.Errorf("expected the CA data to survive, got %q", ca)
+	}
+	if !got.Clusters[1].Cluster.InsecureSkipTLSVerify {
+		t.Errorf("expected insecure-skip-tls-verify to survive")
+	}
+	exec := got.Users[0].User.Exec
+	want := &ExecConfig{
+		APIVersion:      "client.authentication.k8s.io/v1beta1",
+		Command:         "aws",
+		Args:            []string{"eks", "get-token", "--cluster-name", "prod"},
+		Env:             []ExecEnvVar{{Name: "AWS_PROFILE", Value: "prod"}},
+		InteractiveMode: "IfAvailable",
+	}
+	if !reflect.DeepEqual(exec, want) {
+		t.Errorf("expected exec %+v, got %+v", want, exec)
+	}
+	if u := got.Users[1].User; u.ClientCertificateData != "Y2VydA==" || u.ClientKeyData != "a2V5" {
+		t.Errorf("expected the client certificate and key to survive, got %+v", u)
+	}
+	if got.Preferences == nil || !got.Preferences.Colors {
+		t.Errorf("expected the preferences to survive, got %+v", got.Preferences)
+	}
+}
@@ -0,0 +1,88 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// Policy configures the exponential backoff between attempts.
+type Policy struct {
+	// Base is the delay before the second attempt
+	Base time.Duration
+	// Max caps the delay between attempts, 0 means no cap
+	Max time.Duration
+	// Multiplier grows the delay after each attempt, defaults to 2
+	Multiplier float64
+	// Jitter randomizes each delay by up to this fraction, in [0, 1]
+	Jitter float64
+	// Attempts is the maximum number of attempts, 0 means until ctx is done
+	Attempts int
+}
+
+// DefaultPolicy retries 5 times from 100ms up to 10s with 20% jitter.
+var DefaultPolicy = Policy{
+	Base:     100 * time.Millisecond,
+	Max:      10 * time.Second,
+	Jitter:   0.2,
+	Attempts: 5,
+}
+
+// Delay returns the delay after the given attempt, counted from 1, before jitter.
+func (p Policy) Delay(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+	delay := float64(p.Base)
+	for i := 1; i < attempt; i++ {
+		delay *= multiplier
+		if p.Max > 0 && delay >= float64(p.Max) {
+			return p.Max
+		}
+	}
+	if p.Max > 0 && delay > float64(p.Max) {
+		return p.Max
+	}
+	return time.Duration(delay)
+}
+
+// jittered spreads delay uniformly over [delay*(1-Jitter), delay*(1+Jitter)].
+func (p Policy) jittered(delay time.Duration) time.Duration {
+	jitter := p.Jitter
+	if jitter <= 0 {
+		return delay
+	}
+	if jitter > 1 {
+		jitter = 1
+	}
+	return time.Duration(float64(delay) * (1 + jitter*(2*rand.Float64()-1)))
+}
+
+// Do calls fn until it succeeds, the policy is exhausted, or ctx is done.
+// On exhaustion it returns the last error of fn. When ctx is done it returns
+// ctx.Err() joined with the last error of fn, if any.
+func Do(ctx context.Context, policy Policy, fn func(ctx context.Context) error) error {
+	var lastErr error
+	for attempt := 1; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return errors.Join(err, lastErr)
+		}
+		lastErr = fn(ctx)
+		if lastErr == nil {
+			return nil
+		}
+		if policy.Attempts > 0 && attempt >= policy.Attempts {
+			return lastErr
+		}
+
+		timer := time.NewTimer(policy.jittered(policy.Delay(attempt)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(ctx.Err(), lastErr)
+		case <-timer.C:
+		}
+	}
+}
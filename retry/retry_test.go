@@ -0,0 +1,108 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPolicyDelay(t *testing.T) {
+	p := Policy{Base: 10 * time.Millisecond, Max: 50 * time.Millisecond}
+	want := []time.Duration{10, 20, 40, 50, 50}
+	for i, w := range want {
+		if got := p.Delay(i + 1); got != w*time.Millisecond {
+			t.Errorf("attempt %d: expected %s, got %s", i+1, w*time.Millisecond, got)
+		}
+	}
+
+	p = Policy{Base: time.Second, Multiplier: 3}
+	if got := p.Delay(3); got != 9*time.Second {
+		t.Errorf("expected 9s with multiplier 3, got %s", got)
+	}
+}
+
+func TestPolicyJitterBounds(t *testing.T) {
+	p := Policy{Jitter: 0.5}
+	delay := 100 * time.Millisecond
+	for i := 0; i < 1000; i++ {
+		got := p.jittered(delay)
+		if got < 50*time.Millisecond || got > 150*time.Millisecond {
+			t.Fatalf("jittered delay %s out of [50ms, 150ms]", got)
+		}
+	}
+	if got := (Policy{}).jittered(delay); got != delay {
+		t.Errorf("expected no jitter, got %s", got)
+	}
+}
+
+func TestDoSuccessAfterFailure(t *testing.T) {
+	calls := 0
+	start := time.Now()
+	err := Do(context.Background(), Policy{Base: 5 * time.Millisecond, Attempts: 5}, func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return errors.New("not yet")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if calls != 3 {
+		t.Errorf("expected 3 calls, got %d", calls)
+	}
+	// waited 5ms then 10ms between the attempts
+	if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+		t.Errorf("expected at least 15ms of backoff, got %s", elapsed)
+	}
+}
+
+func TestDoExhaustionReturnsLastError(t *testing.T) {
+	calls := 0
+	err := Do(context.Background(), Policy{Base: time.Millisecond, Attempts: 3}, func(ctx context.Context) error {
+		calls++
+		return fmt.Errorf("attempt %d", calls)
+	})
+	if calls != 3 {
+		t.Errorf("expected 3 calls, got %d", calls)
+	}
+	if err == nil || err.Error() != "attempt 3" {
+		t.Errorf("expected last error, got %v", err)
+	}
+}
+
+func TestDoContextCancellation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	failure := errors.New("unavailable")
+	start := time.Now()
+	err := Do(ctx, Policy{Base: time.Hour}, func(ctx context.Context) error {
+		return failure
+	})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected Do to return on cancellation, took %s", elapsed)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, failure) {
+		t.Errorf("expected deadline and last error, got %v", err)
+	}
+}
+
+func TestDoCancelledBeforeFirstAttempt(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	called := false
+	err := Do(ctx, DefaultPolicy, func(ctx context.Context) error {
+		called = true
+		return nil
+	})
+	if called {
+		t.Errorf("expected fn not to be called")
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,374 @@
+// Package xds gathers the configuration of the control plane components,
+// the snapshotter, the load reporting server and the meter exporter.
+package xds
+
+import (
+	"time"
+
+	"github.com/edgedb/edgedb-go"
+	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	"github.com/nebucloud/pkg/xds/meter"
+	"github.com/nebucloud/pkg/xds/report"
+	"github.com/nebucloud/pkg/xds/snapshot"
+	"go.uber.org/fx"
+	"google.golang.org/grpc"
+)
+
+// Config is the configuration of a control plane, turned into the options
+// of each component.
+type Config struct {
+	resyncPeriod           time.Duration
+	apiGateway             bool
+	gatewayCatchAll        bool
+	catchAllStatus         uint32
+	catchAllBody           string
+	egressBasePort         uint32
+	egressFilterChainMatch bool
+	egressServerNames      []string
+	egressTransport        string
+	listenerDrainType      listenerv3.Listener_DrainType
+	envoyVersionGating     bool
+	persistenceCompression bool
+	upstreamBindAddress    string
+	tcpKeepalive           bool
+	keepaliveProbes        int
+	keepaliveIdle          time.Duration
+	keepaliveInterval      time.Duration
+	edgedbOptions          edgedb.Options
+	edgedbTLS              *snapshot.EdgeDBTLS
+	edgedbInsecure         bool
+	dependencyReadiness    bool
+	resourceLimits         map[string]int
+	resourceTTLs           map[string]time.Duration
+	minEndpoints           int
+	drainGrace             time.Duration
+	emitInterval           time.Duration
+	runtimeNamespace       string
+	runtimeName            string
+
+	statsInterval time.Duration
+	drainTimeout  time.Duration
+	clusterLimit  int
+	nodeLimit     int
+
+	histogramBuckets map[string][]float64
+
+	grpcMaxRecvMsgSize int
+	grpcMaxSendMsgSize int
+}
+
+// Option is a function type used to configure the Config.
+type Option func(c *Config)
+
+// NewConfig returns the default Config with opts applied.
+func NewConfig(opts ...Option) Config {
+	c := Config{
+		resyncPeriod:     10 * time.Minute,
+		apiGateway:       true,
+		edgedbOptions:    snapshot.DefaultEdgeDBOptions(),
+		statsInterval:    300 * time.Second,
+		resourceLimits:   make(map[string]int),
+		resourceTTLs:     make(map[string]time.Duration),
+		histogramBuckets: make(map[string][]float64),
+	}
+	for _, o := range opts {
+		o(&c)
+	}
+	return c
+}
+
+// WithResyncPeriod returns an option to set the resync period of the snapshotter reflectors.
+func WithResyncPeriod(period time.Duration) Option {
+	return func(c *Config) {
+		c.resyncPeriod = period
+	}
+}
+
+// WithApiGateway returns an option to enable or disable the api gateway resources.
+func WithApiGateway(enabled bool) Option {
+	return func(c *Config) {
+		c.apiGateway = enabled
+	}
+}
+
+// WithGatewayCatchAll returns an option to answer the unmatched api gateway requests with status and body.
+func WithGatewayCatchAll(status uint32, body string) Option {
+	return func(c *Config) {
+		c.gatewayCatchAll = true
+		c.catchAllStatus, c.catchAllBody = status, body
+	}
+}
+
+// WithEgressListeners returns an option to emit egress socket listeners from basePort.
+func WithEgressListeners(basePort uint32) Option {
+	return func(c *Config) {
+		c.egressBasePort = basePort
+	}
+}
+
+// WithEnvoyVersionGating returns an option to serve legacy Envoy nodes an adjusted snapshot.
+func WithEnvoyVersionGating() Option {
+	return func(c *Config) {
+		c.envoyVersionGating = true
+	}
+}
+
+// WithPersistenceCompression returns an option to gzip the snapshots persisted in EdgeDB.
+func WithPersistenceCompression(enabled bool) Option {
+	return func(c *Config) {
+		c.persistenceCompression = enabled
+	}
+}
+
+// WithUpstreamBindAddress returns an option to originate upstream connections from address.
+func WithUpstreamBindAddress(address string) Option {
+	return func(c *Config) {
+		c.upstreamBindAddress = address
+	}
+}
+
+// WithListenerDrainType returns an option to set the drain type of the generated listeners.
+func WithListenerDrainType(drainType listenerv3.Listener_DrainType) Option {
+	return func(c *Config) {
+		c.listenerDrainType = drainType
+	}
+}
+
+// WithEgressFilterChainMatch returns an option to match the egress listeners on SNI and transport protocol.
+func WithEgressFilterChainMatch(serverNames []string, transportProtocol string) Option {
+	return func(c *Config) {
+		c.egressFilterChainMatch = true
+		c.egressServerNames, c.egressTransport = serverNames, transportProtocol
+	}
+}
+
+// WithTCPKeepalive returns an option to enable TCP keepalive on the generated clusters.
+func WithTCPKeepalive(probes int, idle, interval time.Duration) Option {
+	return func(c *Config) {
+		c.tcpKeepalive = true
+		c.keepaliveProbes, c.keepaliveIdle, c.keepaliveInterval = probes, idle, interval
+	}
+}
+
+// WithEdgeDBOptions returns an option to set the options of the EdgeDB client.
+func WithEdgeDBOptions(options edgedb.Options) Option {
+	return func(c *Config) {
+		c.edgedbOptions = options
+	}
+}
+
+// WithEdgeDBTLS returns an option to verify the EdgeDB server with tls.
+func WithEdgeDBTLS(tls snapshot.EdgeDBTLS) Option {
+	return func(c *Config) {
+		c.edgedbTLS = &tls
+	}
+}
+
+// WithEdgeDBInsecure returns an option to connect to EdgeDB without verifying its certificate.
+func WithEdgeDBInsecure() Option {
+	return func(c *Config) {
+		c.edgedbInsecure = true
+	}
+}
+
+// WithDependencyReadiness returns an option to gate the snapshotter readiness on EdgeDB and Consul.
+func WithDependencyReadiness() Option {
+	return func(c *Config) {
+		c.dependencyReadiness = true
+	}
+}
+
+// WithResourceLimit returns an option to cap the snapshot resources of typeURL.
+func WithResourceLimit(typeURL string, max int) Option {
+	return func(c *Config) {
+		c.resourceLimits[typeURL] = max
+	}
+}
+
+// WithResourceTTL returns an option to publish the snapshot resources of typeURL with a ttl.
+func WithResourceTTL(typeURL string, ttl time.Duration) Option {
+	return func(c *Config) {
+		c.resourceTTLs[typeURL] = ttl
+	}
+}
+
+// WithMinEndpoints returns an option to hold back the cluster assignments below min ready endpoints.
+func WithMinEndpoints(min int) Option {
+	return func(c *Config) {
+		c.minEndpoints = min
+	}
+}
+
+// WithEndpointDraining returns an option to serve the removed endpoints as DRAINING for grace.
+func WithEndpointDraining(grace time.Duration) Option {
+	return func(c *Config) {
+		c.drainGrace = grace
+	}
+}
+
+// WithEmitCoalescing returns an option to rebuild each snapshot at most once per interval.
+func WithEmitCoalescing(interval time.Duration) Option {
+	return func(c *Config) {
+		c.emitInterval = interval
+	}
+}
+
+// WithRuntimeConfigMap returns an option to serve the ConfigMap namespace/name over RTDS.
+func WithRuntimeConfigMap(namespace, name string) Option {
+	return func(c *Config) {
+		c.runtimeNamespace = namespace
+		c.runtimeName = name
+	}
+}
+
+// WithStatsInterval returns an option to set the load reporting interval, rounded to seconds.
+func WithStatsInterval(interval time.Duration) Option {
+	return func(c *Config) {
+		c.statsInterval = interval
+	}
+}
+
+// WithDrainTimeout returns an option to set how long the load reporting server drains on stop.
+func WithDrainTimeout(d time.Duration) Option {
+	return func(c *Config) {
+		c.drainTimeout = d
+	}
+}
+
+// WithClusterLimit returns an option to cap the distinct clusters the load reporting metrics are labeled with.
+func WithClusterLimit(max int) Option {
+	return func(c *Config) {
+		c.clusterLimit = max
+	}
+}
+
+// WithNodeLimit returns an option to cap the distinct node IDs the load reporting metrics are labeled with.
+func WithNodeLimit(max int) Option {
+	return func(c *Config) {
+		c.nodeLimit = max
+	}
+}
+
+// WithHistogramBuckets returns an option to set the bucket boundaries of a histogram instrument.
+func WithHistogramBuckets(instrument string, boundaries []float64) Option {
+	return func(c *Config) {
+		c.histogramBuckets[instrument] = boundaries
+	}
+}
+
+// WithGRPCMaxMessageSize returns an option to set the largest message the xDS
+// gRPC server receives and sends, in bytes, zero keeping the gRPC default of
+// 4MB received and unlimited sent. A snapshot too large for an Envoy client
+// to receive fails its stream, WithResourceLimit bounds the resource counts
+// but not their size.
+func WithGRPCMaxMessageSize(recv, send int) Option {
+	return func(c *Config) {
+		c.grpcMaxRecvMsgSize, c.grpcMaxSendMsgSize = recv, send
+	}
+}
+
+// SnapshotterOptions returns the options of snapshot.NewSnapshotter.
+func (c Config) SnapshotterOptions() []snapshot.Option {
+	opts := []snapshot.Option{
+		snapshot.WithResyncPeriod(c.resyncPeriod),
+		snapshot.WithApiGateway(c.apiGateway),
+		snapshot.WithPersistenceCompression(c.persistenceCompression),
+		snapshot.WithEdgeDBOptions(c.edgedbOptions),
+	}
+	if c.gatewayCatchAll {
+		opts = append(opts, snapshot.WithGatewayCatchAll(c.catchAllStatus, c.catchAllBody))
+	}
+	if c.edgedbTLS != nil {
+		opts = append(opts, snapshot.WithEdgeDBTLS(*c.edgedbTLS))
+	}
+	if c.edgedbInsecure {
+		opts = append(opts, snapshot.WithEdgeDBInsecure())
+	}
+	if c.egressBasePort != 0 {
+		opts = append(opts, snapshot.WithEgressListeners(c.egressBasePort))
+	}
+	if c.egressFilterChainMatch {
+		opts = append(opts, snapshot.WithEgressFilterChainMatch(c.egressServerNames, c.egressTransport))
+	}
+	if c.listenerDrainType != listenerv3.Listener_DEFAULT {
+		opts = append(opts, snapshot.WithListenerDrainType(c.listenerDrainType))
+	}
+	if c.envoyVersionGating {
+		opts = append(opts, snapshot.WithEnvoyVersionGating())
+	}
+	if c.dependencyReadiness {
+		opts = append(opts, snapshot.WithDependencyReadiness())
+	}
+	if c.upstreamBindAddress != "" {
+		opts = append(opts, snapshot.WithUpstreamBindAddress(c.upstreamBindAddress))
+	}
+	if c.tcpKeepalive {
+		opts = append(opts, snapshot.WithTCPKeepalive(c.keepaliveProbes, c.keepaliveIdle, c.keepaliveInterval))
+	}
+	if c.runtimeName != "" {
+		opts = append(opts, snapshot.WithRuntimeConfigMap(c.runtimeNamespace, c.runtimeName))
+	}
+	if c.minEndpoints != 0 {
+		opts = append(opts, snapshot.WithMinEndpoints(c.minEndpoints))
+	}
+	if c.drainGrace != 0 {
+		opts = append(opts, snapshot.WithEndpointDraining(c.drainGrace))
+	}
+	if c.emitInterval != 0 {
+		opts = append(opts, snapshot.WithEmitCoalescing(c.emitInterval))
+	}
+	for typeURL, max := range c.resourceLimits {
+		opts = append(opts, snapshot.WithResourceLimit(typeURL, max))
+	}
+	for typeURL, ttl := range c.resourceTTLs {
+		opts = append(opts, snapshot.WithResourceTTL(typeURL, ttl))
+	}
+	return opts
+}
+
+// MeterServerOptions returns the options of report.NewMeterServer.
+func (c Config) MeterServerOptions() []report.Option {
+	return []report.Option{
+		report.WithStatsIntervalInSeconds(int64(c.statsInterval / time.Second)),
+		report.WithDrainTimeout(c.drainTimeout),
+		report.WithClusterLimit(c.clusterLimit),
+		report.WithNodeLimit(c.nodeLimit),
+	}
+}
+
+// MeterOptions returns the options of meter.InstallPromExporter.
+func (c Config) MeterOptions() []meter.Option {
+	opts := make([]meter.Option, 0, len(c.histogramBuckets))
+	for instrument, boundaries := range c.histogramBuckets {
+		opts = append(opts, meter.WithHistogramBuckets(instrument, boundaries))
+	}
+	return opts
+}
+
+// GRPCServerOptions returns the options of the xDS grpc.NewServer.
+func (c Config) GRPCServerOptions() []grpc.ServerOption {
+	var opts []grpc.ServerOption
+	if c.grpcMaxRecvMsgSize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(c.grpcMaxRecvMsgSize))
+	}
+	if c.grpcMaxSendMsgSize > 0 {
+		opts = append(opts, grpc.MaxSendMsgSize(c.grpcMaxSendMsgSize))
+	}
+	return opts
+}
+
+// Module supplies the Config, the meter options to meter.MeterModule and the
+// xDS gRPC server options to the grpc_server_options group.
+func Module(c Config) fx.Option {
+	return fx.Options(
+		fx.Supply(c),
+		fx.Provide(fx.Annotate(
+			c.MeterOptions,
+			fx.ResultTags(`group:"meter_options,flatten"`),
+		)),
+		fx.Provide(fx.Annotate(
+			c.GRPCServerOptions,
+			fx.ResultTags(`group:"grpc_server_options,flatten"`),
+		)),
+	)
+}
@@ -0,0 +1,152 @@
+package xds
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	loadReportingService "github.com/envoyproxy/go-control-plane/envoy/service/load_stats/v3"
+	"github.com/nebucloud/pkg/logger"
+	"github.com/nebucloud/pkg/xds/meter"
+	"github.com/nebucloud/pkg/xds/report"
+	"github.com/nebucloud/pkg/xds/snapshot"
+	"go.uber.org/fx"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// unavailableDatabase fails every GetDatabase, stopping the snapshotter loops.
+type unavailableDatabase struct{}
+
+func (unavailableDatabase) GetDatabase(context.Context) (snapshot.Database, error) {
+	return nil, errors.New("unavailable")
+}
+
+// fakeStream is a load reporting stream recording every response.
+type fakeStream struct {
+	loadReportingService.LoadReportingService_StreamLoadStatsServer
+	responses []*loadReportingService.LoadStatsResponse
+}
+
+func (*fakeStream) Context() context.Context { return context.Background() }
+
+func (f *fakeStream) Send(response *loadReportingService.LoadStatsResponse) error {
+	f.responses = append(f.responses, response)
+	return nil
+}
+
+func TestConfigPropagates(t *testing.T) {
+	cfg := NewConfig(
+		WithResyncPeriod(time.Minute),
+		WithStatsInterval(30*time.Second),
+		WithEgressListeners(15000),
+		WithHistogramBuckets("xds_latency", []float64{1, 5}),
+	)
+
+	ss := snapshot.NewSnapshotter(nil, logger.Singleton(), unavailableDatabase{}, nil, nil, cfg.SnapshotterOptions()...)
+	defer ss.Close()
+	if ss.ResyncPeriod != time.Minute {
+		t.Errorf("expected resync period 1m, got %s", ss.ResyncPeriod)
+	}
+
+	lrs := report.NewMeterServer(logger.Singleton(), cfg.MeterServerOptions()...)
+	stream := &fakeStream{}
+	lrs.(*report.MeterServer).HandleRequest(stream, &loadReportingService.LoadStatsRequest{Node: &corev3.Node{Id: "envoy-1"}})
+	if len(stream.responses) != 1 || stream.responses[0].LoadReportingInterval.GetSeconds() != 30 {
+		t.Errorf("expected a 30s load reporting interval, got %v", stream.responses)
+	}
+
+	if got := len(cfg.MeterOptions()); got != 1 {
+		t.Errorf("expected one meter option, got %d", got)
+	}
+}
+
+func TestNewConfigDefaults(t *testing.T) {
+	cfg := NewConfig()
+	if cfg.resyncPeriod != 10*time.Minute || !cfg.apiGateway || cfg.statsInterval != 300*time.Second {
+		t.Errorf("unexpected defaults %+v", cfg)
+	}
+	if !reflect.DeepEqual(cfg.edgedbOptions, snapshot.DefaultEdgeDBOptions()) {
+		t.Errorf("expected the default EdgeDB options, got %+v", cfg.edgedbOptions)
+	}
+}
+
+func TestModule(t *testing.T) {
+	var opts []meter.Option
+	app := fx.New(
+		Module(NewConfig(WithHistogramBuckets("xds_latency", []float64{1, 5}))),
+		fx.Invoke(fx.Annotate(func(o []meter.Option) { opts = o }, fx.ParamTags(`group:"meter_options"`))),
+		fx.NopLogger,
+	)
+	if err := app.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(opts) != 1 {
+		t.Errorf("expected the meter options in the meter_options group, got %d", len(opts))
+	}
+}
+
+func TestGRPCServerOptions(t *testing.T) {
+	// check calls the health service of a server built from cfg
+	check := func(t *testing.T, cfg Config, service string) codes.Code {
+		listener := bufconn.Listen(1 << 20)
+		server := grpc.NewServer(cfg.GRPCServerOptions()...)
+		healthpb.RegisterHealthServer(server, health.NewServer())
+		go server.Serve(listener)
+		t.Cleanup(server.Stop)
+
+		conn, err := grpc.NewClient("passthrough:///bufnet",
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		_, err = healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		return status.Code(err)
+	}
+	long := strings.Repeat("x", 128)
+
+	tests := []struct {
+		name    string
+		cfg     Config
+		service string
+		want    codes.Code
+	}{
+		{"default", NewConfig(), long, codes.NotFound},
+		{"overall health", NewConfig(WithGRPCMaxMessageSize(64, 0)), "", codes.OK},
+		{"request too large", NewConfig(WithGRPCMaxMessageSize(64, 0)), long, codes.ResourceExhausted},
+		{"response too large", NewConfig(WithGRPCMaxMessageSize(0, 1)), "", codes.ResourceExhausted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := check(t, tt.cfg, tt.service); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+
+	var opts []grpc.ServerOption
+	app := fx.New(
+		Module(NewConfig(WithGRPCMaxMessageSize(16<<20, 16<<20))),
+		fx.Invoke(fx.Annotate(func(o []grpc.ServerOption) { opts = o }, fx.ParamTags(`group:"grpc_server_options"`))),
+		fx.NopLogger,
+	)
+	if err := app.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(opts) != 2 {
+		t.Errorf("expected the gRPC server options in the grpc_server_options group, got %d", len(opts))
+	}
+}
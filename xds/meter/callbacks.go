@@ -15,8 +15,28 @@ var (
 	TypeURLAttrKey    attribute.Key = "type_url"
 	APIGatewayAttrKey attribute.Key = "api_gateway"
 	ResourceAttrKey   attribute.Key = "resource"
+	OperationAttrKey  attribute.Key = "operation"
+	OutcomeAttrKey    attribute.Key = "outcome"
+	NodeIDAttrKey     attribute.Key = "node_id"
+	ClusterAttrKey    attribute.Key = "cluster"
+	RequestsAttrKey   attribute.Key = "requests"
+	AnnotationAttrKey attribute.Key = "annotation"
 )
 
+// Operation outcomes recorded under OutcomeAttrKey
+const (
+	OutcomeSuccess = "success"
+	OutcomeError   = "error"
+)
+
+// Outcome returns the OutcomeAttrKey attribute matching err.
+func Outcome(err error) attribute.KeyValue {
+	if err != nil {
+		return OutcomeAttrKey.String(OutcomeError)
+	}
+	return OutcomeAttrKey.String(OutcomeSuccess)
+}
+
 func NewXdsServerCallbackFuncs(meter metric.Meter) server.CallbackFuncs {
 	streamGauge, _ := meter.Int64UpDownCounter("xds_server_streams")
 	deltaStreamGauge, _ := meter.Int64UpDownCounter("xds_server_delta_streams")
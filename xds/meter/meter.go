@@ -1,6 +1,7 @@
 package meter
 
 import (
+	"github.com/nebucloud/pkg/logger"
 	"go.opentelemetry.io/otel"
 	otelprom "go.opentelemetry.io/otel/exporters/prometheus"
 	"go.opentelemetry.io/otel/metric"
@@ -12,19 +13,76 @@ func GetMeter() metric.Meter {
 	return otel.Meter("k8sxds")
 }
 
-func InstallPromExporter() error {
+type exporterConfig struct {
+	logger *logger.Klogger
+	views  []sdkmetric.View
+}
+
+// Option is a function type used to configure the metrics exporter.
+type Option func(c *exporterConfig)
+
+// WithLogger returns an option to report exporter setup and export errors through logger.
+func WithLogger(logger *logger.Klogger) Option {
+	return func(c *exporterConfig) {
+		c.logger = logger
+	}
+}
+
+// WithHistogramBuckets returns an option to aggregate the histogram instrument
+// named instrument into the explicit bucket boundaries instead of the SDK defaults.
+func WithHistogramBuckets(instrument string, boundaries []float64) Option {
+	return func(c *exporterConfig) {
+		c.views = append(c.views, sdkmetric.NewView(
+			sdkmetric.Instrument{Name: instrument, Kind: sdkmetric.InstrumentKindHistogram},
+			sdkmetric.Stream{Aggregation: sdkmetric.AggregationExplicitBucketHistogram{Boundaries: boundaries}},
+		))
+	}
+}
+
+// newMeterProvider creates a meter provider exporting through reader with the configured views.
+func newMeterProvider(reader sdkmetric.Reader, cfg *exporterConfig) *sdkmetric.MeterProvider {
+	return sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader), sdkmetric.WithView(cfg.views...))
+}
+
+func InstallPromExporter(opts ...Option) error {
+	cfg := &exporterConfig{}
+	for _, o := range opts {
+		o(cfg)
+	}
+
+	if cfg.logger != nil {
+		otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+			cfg.logger.Errorf("OpenTelemetry export error: %v", err)
+		}))
+	}
+
 	promReader, err := otelprom.New()
 	if err != nil {
+		if cfg.logger != nil {
+			cfg.logger.Errorf("Failed to create Prometheus exporter: %v", err)
+		}
 		return err
 	}
-	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(promReader))
-	otel.SetMeterProvider(provider)
+	otel.SetMeterProvider(newMeterProvider(promReader, cfg))
 	return nil
 }
 
+type exporterParams struct {
+	fx.In
+
+	Logger  *logger.Klogger `optional:"true"`
+	Options []Option        `group:"meter_options"`
+}
+
+// MeterModule installs the Prometheus exporter, applying any Option
+// provided to the "meter_options" value group.
 var MeterModule = fx.Options(
 	fx.Provide(GetMeter),
-	fx.Invoke(func() error {
-		return InstallPromExporter()
+	fx.Invoke(func(p exporterParams) error {
+		opts := p.Options
+		if p.Logger != nil {
+			opts = append([]Option{WithLogger(p.Logger)}, opts...)
+		}
+		return InstallPromExporter(opts...)
 	}),
 )
@@ -0,0 +1,73 @@
+package meter
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nebucloud/pkg/logger"
+	"go.opentelemetry.io/otel"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestInstallPromExporterWithLogger(t *testing.T) {
+	previous, previousHandler := otel.GetMeterProvider(), otel.GetErrorHandler()
+	t.Cleanup(func() {
+		otel.SetMeterProvider(previous)
+		otel.SetErrorHandler(previousHandler)
+	})
+
+	handler := logger.NewMemoryHandler()
+	l := &logger.Klogger{}
+	l.SetLogger(slog.New(handler))
+
+	if err := InstallPromExporter(WithLogger(l)); err != nil {
+		t.Fatal(err)
+	}
+	otel.Handle(errors.New("scrape failed"))
+
+	for _, r := range handler.Records() {
+		if strings.Contains(r.Message, "scrape failed") {
+			return
+		}
+	}
+	t.Errorf("expected export error to be logged, got %v", handler.Records())
+}
+
+func TestWithHistogramBuckets(t *testing.T) {
+	cfg := &exporterConfig{}
+	WithHistogramBuckets("xds_snapshot_build_seconds", []float64{0.001, 0.01, 0.1})(cfg)
+
+	reader := sdkmetric.NewManualReader()
+	m := newMeterProvider(reader, cfg).Meter("test")
+	custom, _ := m.Float64Histogram("xds_snapshot_build_seconds")
+	other, _ := m.Float64Histogram("other_seconds")
+	custom.Record(context.Background(), 0.005)
+	other.Record(context.Background(), 0.005)
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	bounds := map[string][]float64{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, metric := range sm.Metrics {
+			hist, ok := metric.Data.(metricdata.Histogram[float64])
+			if !ok {
+				t.Fatalf("metric %s is not a float64 histogram", metric.Name)
+			}
+			bounds[metric.Name] = hist.DataPoints[0].Bounds
+		}
+	}
+
+	if want := []float64{0.001, 0.01, 0.1}; !reflect.DeepEqual(bounds["xds_snapshot_build_seconds"], want) {
+		t.Errorf("expected custom bounds %v, got %v", want, bounds["xds_snapshot_build_seconds"])
+	}
+	if len(bounds["other_seconds"]) <= 3 {
+		t.Errorf("expected default bounds for other instruments, got %v", bounds["other_seconds"])
+	}
+}
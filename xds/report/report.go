@@ -2,7 +2,10 @@ package report
 
 import (
 	"context"
+	"log/slog"
+	"slices"
 	"sync"
+	"time"
 
 	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	loadReportingService "github.com/envoyproxy/go-control-plane/envoy/service/load_stats/v3"
@@ -19,15 +22,45 @@ import (
 type MeterServer struct {
 	loadReportingService.UnimplementedLoadReportingServiceServer
 
-	lock           sync.Mutex
-	nodesConnected map[string]bool
+	lock sync.Mutex
+	// nodesConnected holds the tokens of the connections of every node ID,
+	// Envoys sharing a node ID each have their own connection token
+	nodesConnected map[string]map[uint64]bool
+	streams        map[loadReportingService.LoadReportingService_StreamLoadStatsServer]*connection
+	nextToken      uint64
 
 	statsIntervalInSeconds int64
 	statsUpdateCounter     metric.Int64Counter
 	nodeGauge              metric.Int64UpDownCounter
+	requestsCounter        metric.Int64Counter
+	requestRateGauge       metric.Float64Gauge
 	logger                 *logger.Klogger
 
-	stopCh chan struct{}
+	now     func() time.Time
+	windows map[statsKey]*statsWindow
+	// clusterLabels and nodeLabels bound the clusters and node IDs recorded
+	// under their own label
+	clusterLabels labelLimit
+	nodeLabels    labelLimit
+
+	clusters        func() []string
+	clusterSelector func(node *corev3.Node, cluster string) bool
+
+	drainTimeout  time.Duration
+	stopCh        chan struct{}
+	stopOnce      sync.Once
+	configLogOnce sync.Once
+}
+
+// drainPollInterval is how often Stop checks for connected nodes while draining
+const drainPollInterval = 50 * time.Millisecond
+
+// connection is a load reporting stream of a node
+type connection struct {
+	token uint64
+	node  *corev3.Node
+	// clusters are the clusters the node was last requested to report on
+	clusters []string
 }
 
 // Option is a function type used to configure the MeterServer.
@@ -38,12 +71,22 @@ func NewMeterServer(logger *logger.Klogger, opts ...Option) loadReportingService
 	meter := meter.GetMeter()
 	lrsUpdatesCounter, _ := meter.Int64Counter("lrs_updates")
 	lrsNodesCounter, _ := meter.Int64UpDownCounter("lrs_nodes")
+	lrsRequestsCounter, _ := meter.Int64Counter("lrs_requests")
+	lrsRequestRateGauge, _ := meter.Float64Gauge("lrs_request_rate")
 	s := &MeterServer{
-		nodesConnected:         make(map[string]bool),
+		nodesConnected:         make(map[string]map[uint64]bool),
+		streams:                make(map[loadReportingService.LoadReportingService_StreamLoadStatsServer]*connection),
 		statsIntervalInSeconds: 300,
 		statsUpdateCounter:     lrsUpdatesCounter,
 		nodeGauge:              lrsNodesCounter,
+		requestsCounter:        lrsRequestsCounter,
+		requestRateGauge:       lrsRequestRateGauge,
 		logger:                 logger,
+		now:                    time.Now,
+		windows:                make(map[statsKey]*statsWindow),
+		clusterLabels:          labelLimit{overflow: OverflowCluster},
+		nodeLabels:             labelLimit{overflow: OverflowNode},
+		stopCh:                 make(chan struct{}),
 	}
 
 	for _, o := range opts {
@@ -60,10 +103,14 @@ func (s *MeterServer) StreamLoadStats(stream loadReportingService.LoadReportingS
 		req, err := stream.Recv()
 		if err != nil {
 			if node != nil {
-				s.removeNode(stream.Context(), node)
+				s.removeNode(stream.Context(), stream, node)
 			}
 			return err
 		}
+		if req.GetNode().GetId() == "" {
+			s.logger.Warnf("Ignoring load stats request without node ID")
+			continue
+		}
 		if node == nil {
 			node = req.Node
 		}
@@ -73,32 +120,39 @@ func (s *MeterServer) StreamLoadStats(stream loadReportingService.LoadReportingS
 }
 
 // HandleRequest handles a single load stats request.
+// Requests without node ID are ignored, they cannot be accounted for.
 func (s *MeterServer) HandleRequest(stream loadReportingService.LoadReportingService_StreamLoadStatsServer, request *loadReportingService.LoadStatsRequest) {
 	nodeID := request.GetNode().GetId()
+	if nodeID == "" {
+		s.logger.Warnf("Ignoring load stats request without node ID")
+		return
+	}
 
 	s.statsUpdateCounter.Add(stream.Context(), 1)
 
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	if _, exist := s.nodesConnected[nodeID]; !exist {
+	conn, exist := s.streams[stream]
+	if !exist {
+		s.nextToken++
+		conn = &connection{token: s.nextToken}
+		s.streams[stream] = conn
+	}
+
+	if tokens := s.nodesConnected[nodeID]; !tokens[conn.token] {
+		if len(tokens) > 0 {
+			s.logger.Warnf("Node %s connected while already connected %d times, node IDs should be unique", nodeID, len(tokens))
+		}
 		s.logger.InfoS("New node connected", "node_id", nodeID, "cluster_str", request.Node.Cluster)
-		s.nodesConnected[nodeID] = true
+		conn.node = request.Node
+		s.addConnection(nodeID, conn.token)
 		s.nodeGauge.Add(stream.Context(), 1)
 
-		// Use the actual cluster name instead of a dummy value
-		clusterName := request.Node.Cluster
-
-		err := stream.Send(&loadReportingService.LoadStatsResponse{
-			Clusters:                  []string{clusterName},
-			LoadReportingInterval:     &durationpb.Duration{Seconds: s.statsIntervalInSeconds},
-			ReportEndpointGranularity: true,
-		})
-		if err != nil {
+		if err := s.sendClusters(stream, conn, s.reportedClusters(request.Node)); err != nil {
 			s.logger.Errorf("Unable to send response to node %s due to err: %s", nodeID, err)
-			delete(s.nodesConnected, nodeID)
+			s.removeConnection(stream.Context(), stream, nodeID)
 			s.logger.InfoS("Node disconnected", "node_id", nodeID, "cluster_str", request.Node.Cluster)
-			s.nodeGauge.Add(stream.Context(), -1)
 		}
 		return
 	}
@@ -108,18 +162,109 @@ func (s *MeterServer) HandleRequest(stream loadReportingService.LoadReportingSer
 			s.logger.InfoS("Got stats", "node_id", request.Node.Id, "cluster_str", request.Node.Cluster, "cluster_stats", clusterStats)
 		}
 	}
+	s.aggregateStats(nodeID, request.ClusterStats)
+	s.flushWindows(stream.Context(), false, func(statsKey) bool { return true })
 }
 
-// removeNode removes a node from the nodesConnected map.
-func (s *MeterServer) removeNode(ctx context.Context, node *corev3.Node) {
+// reportedClusters returns the clusters node is requested to report on.
+func (s *MeterServer) reportedClusters(node *corev3.Node) []string {
+	// Use the actual cluster name instead of a dummy value
+	candidates := []string{node.GetCluster()}
+	if s.clusters != nil {
+		candidates = s.clusters()
+	}
+	if s.clusterSelector == nil {
+		return candidates
+	}
+	clusters := make([]string, 0, len(candidates))
+	for _, cluster := range candidates {
+		if s.clusterSelector(node, cluster) {
+			clusters = append(clusters, cluster)
+		}
+	}
+	return clusters
+}
+
+// sendClusters requests the node of conn to report on clusters.
+// The caller must hold s.lock.
+func (s *MeterServer) sendClusters(stream loadReportingService.LoadReportingService_StreamLoadStatsServer, conn *connection, clusters []string) error {
+	err := stream.Send(&loadReportingService.LoadStatsResponse{
+		Clusters:                  clusters,
+		LoadReportingInterval:     &durationpb.Duration{Seconds: s.statsIntervalInSeconds},
+		ReportEndpointGranularity: true,
+	})
+	if err == nil {
+		conn.clusters = clusters
+	}
+	return err
+}
+
+// ClustersChanged sends the connected nodes whose reported clusters changed
+// their new cluster list. Call it when the clusters change, e.g. from
+// snapshot.WithSnapshotNotifier.
+func (s *MeterServer) ClustersChanged() {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	delete(s.nodesConnected, node.Id)
+	for stream, conn := range s.streams {
+		if conn.node == nil {
+			continue
+		}
+		clusters := s.reportedClusters(conn.node)
+		if slices.Equal(clusters, conn.clusters) {
+			continue
+		}
+		if err := s.sendClusters(stream, conn, clusters); err != nil {
+			s.logger.Errorf("Unable to send updated clusters to node %s due to err: %s", conn.node.GetId(), err)
+		}
+	}
+}
 
-	s.logger.InfoS("Node disconnected", "node_id", node.Id, "cluster_str", node.Cluster)
+// addConnection marks the connection token of node ID as connected.
+// The caller must hold s.lock.
+func (s *MeterServer) addConnection(nodeID string, token uint64) {
+	tokens, ok := s.nodesConnected[nodeID]
+	if !ok {
+		tokens = make(map[uint64]bool)
+		s.nodesConnected[nodeID] = tokens
+	}
+	tokens[token] = true
+}
 
+// removeConnection forgets the connection of stream, and node ID once it
+// has no connection left, uncounting it from the connected nodes. It reports
+// whether node ID is gone, false if stream was already forgotten.
+// The caller must hold s.lock.
+func (s *MeterServer) removeConnection(ctx context.Context, stream loadReportingService.LoadReportingService_StreamLoadStatsServer, nodeID string) bool {
+	conn, ok := s.streams[stream]
+	if !ok {
+		return false
+	}
+	delete(s.streams, stream)
 	s.nodeGauge.Add(ctx, -1)
+	tokens := s.nodesConnected[nodeID]
+	delete(tokens, conn.token)
+	if len(tokens) > 0 {
+		return false
+	}
+	delete(s.nodesConnected, nodeID)
+	return true
+}
+
+// removeNode removes the connection of stream from the nodesConnected map,
+// unless it was already removed on a failed send.
+func (s *MeterServer) removeNode(ctx context.Context, stream loadReportingService.LoadReportingService_StreamLoadStatsServer, node *corev3.Node) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.streams[stream]; !ok {
+		return
+	}
+	if s.removeConnection(ctx, stream, node.Id) {
+		s.flushWindows(ctx, true, func(key statsKey) bool { return key.nodeID == node.Id })
+	}
+
+	s.logger.InfoS("Node disconnected", "node_id", node.Id, "cluster_str", node.Cluster)
 }
 
 // WithStatsIntervalInSeconds returns an option to set the stats interval in seconds.
@@ -129,14 +274,97 @@ func WithStatsIntervalInSeconds(statsIntervalInSeconds int64) Option {
 	}
 }
 
-// Run starts the MeterServer.
+// WithDrainTimeout returns an option to make Stop wait up to d for the
+// connected nodes to disconnect.
+func WithDrainTimeout(d time.Duration) Option {
+	return func(s *MeterServer) {
+		s.drainTimeout = d
+	}
+}
+
+// WithClusters returns an option to set the clusters nodes may report on,
+// instead of the cluster of each node.
+func WithClusters(clusters func() []string) Option {
+	return func(s *MeterServer) {
+		s.clusters = clusters
+	}
+}
+
+// WithClusterSelector returns an option to request each node to report only
+// on the clusters selector returns true for, e.g. based on node metadata.
+func WithClusterSelector(selector func(node *corev3.Node, cluster string) bool) Option {
+	return func(s *MeterServer) {
+		s.clusterSelector = selector
+	}
+}
+
+// Run starts the MeterServer, logging its effective configuration once.
 func (s *MeterServer) Run() {
+	s.configLogOnce.Do(func() {
+		s.logger.InfoS("Load reporting server configuration", s.configAttrs()...)
+	})
 	<-s.stopCh
 }
 
-// Stop stops the MeterServer.
+// configAttrs returns the effective configuration of the MeterServer as attributes.
+func (s *MeterServer) configAttrs() []any {
+	clusters := "node"
+	if s.clusters != nil {
+		clusters = "provided"
+	}
+	return []any{
+		slog.Int64("stats_interval_seconds", s.statsIntervalInSeconds),
+		slog.Duration("drain_timeout", s.drainTimeout),
+		slog.String("clusters", clusters),
+		slog.Bool("cluster_selector", s.clusterSelector != nil),
+		slog.Int("cluster_limit", s.clusterLabels.max),
+		slog.Int("node_limit", s.nodeLabels.max),
+	}
+}
+
+// Stop stops the MeterServer. With a drain timeout, it then waits for the
+// connected nodes to disconnect and returns once none is left or the timeout
+// elapses.
 func (s *MeterServer) Stop() {
-	close(s.stopCh)
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+	})
+	defer func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.flushWindows(context.Background(), true, func(statsKey) bool { return true })
+	}()
+	if s.drainTimeout <= 0 {
+		return
+	}
+
+	deadline := time.NewTimer(s.drainTimeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		remaining := s.connectedNodes()
+		if remaining == 0 {
+			return
+		}
+		select {
+		case <-deadline.C:
+			s.logger.Warnf("Drain timeout %s elapsed with %d nodes still connected", s.drainTimeout, remaining)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// connectedNodes returns the number of node connections currently open.
+func (s *MeterServer) connectedNodes() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	connected := 0
+	for _, tokens := range s.nodesConnected {
+		connected += len(tokens)
+	}
+	return connected
 }
 
 // RegisterLoadReportingService registers the load reporting service with the gRPC server.
@@ -0,0 +1,560 @@
+package report
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	loadReportingService "github.com/envoyproxy/go-control-plane/envoy/service/load_stats/v3"
+	"github.com/nebucloud/pkg/logger"
+	"github.com/nebucloud/pkg/xds/meter"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func newTestMeterServer(opts ...Option) *MeterServer {
+	return NewMeterServer(logger.Singleton(), opts...).(*MeterServer)
+}
+
+func TestStopDrainTimeout(t *testing.T) {
+	s := newTestMeterServer(WithDrainTimeout(200 * time.Millisecond))
+	s.nodesConnected["lingering"] = map[uint64]bool{1: true}
+
+	done := make(chan struct{})
+	go func() {
+		s.Run()
+		close(done)
+	}()
+
+	start := time.Now()
+	s.Stop()
+	elapsed := time.Since(start)
+	if elapsed < 200*time.Millisecond {
+		t.Errorf("expected Stop to wait for the drain timeout, returned after %s", elapsed)
+	}
+	if elapsed > 2*time.Second {
+		t.Errorf("expected Stop to return after the drain timeout, returned after %s", elapsed)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("expected Run to return once stopped")
+	}
+}
+
+func TestStopDrainsNodes(t *testing.T) {
+	s := newTestMeterServer(WithDrainTimeout(10 * time.Second))
+	stream := &fakeStream{}
+	request := statsRequest("envoy-1")
+	s.HandleRequest(stream, request)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		s.removeNode(context.Background(), stream, request.Node)
+	}()
+
+	start := time.Now()
+	s.Stop()
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected Stop to return once the node disconnected, returned after %s", elapsed)
+	}
+	s.Stop()
+}
+
+// fakeStream is a load reporting stream accepting every response.
+type fakeStream struct {
+	loadReportingService.LoadReportingService_StreamLoadStatsServer
+	requests  []*loadReportingService.LoadStatsRequest
+	responses []*loadReportingService.LoadStatsResponse
+	sendErr   error
+}
+
+func (f *fakeStream) Recv() (*loadReportingService.LoadStatsRequest, error) {
+	if len(f.requests) == 0 {
+		return nil, io.EOF
+	}
+	request := f.requests[0]
+	f.requests = f.requests[1:]
+	return request, nil
+}
+
+func (*fakeStream) Context() context.Context { return context.Background() }
+
+func (f *fakeStream) Send(response *loadReportingService.LoadStatsResponse) error {
+	if f.sendErr != nil {
+		return f.sendErr
+	}
+	f.responses = append(f.responses, response)
+	return nil
+}
+
+// fakeClock is a clock advanced manually.
+type fakeClock struct {
+	current time.Time
+}
+
+func (c *fakeClock) now() time.Time { return c.current }
+
+func statsRequest(nodeID string, clusterStats ...*endpointv3.ClusterStats) *loadReportingService.LoadStatsRequest {
+	return &loadReportingService.LoadStatsRequest{
+		Node:         &corev3.Node{Id: nodeID, Cluster: "edge"},
+		ClusterStats: clusterStats,
+	}
+}
+
+func clusterStats(cluster string, successful, errors, dropped uint64) *endpointv3.ClusterStats {
+	return &endpointv3.ClusterStats{
+		ClusterName:          cluster,
+		TotalDroppedRequests: dropped,
+		UpstreamLocalityStats: []*endpointv3.UpstreamLocalityStats{{
+			TotalSuccessfulRequests: successful,
+			TotalErrorRequests:      errors,
+			TotalIssuedRequests:     successful + errors,
+		}},
+	}
+}
+
+// dataPoint returns the value of the int64 sum or float64 gauge name for
+// the data point carrying every one of attrs.
+func dataPoint(t *testing.T, reader *sdkmetric.ManualReader, name string, attrs ...attribute.KeyValue) (float64, bool) {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	matches := func(set attribute.Set) bool {
+		for _, attr := range attrs {
+			if v, ok := set.Value(attr.Key); !ok || v != attr.Value {
+				return false
+			}
+		}
+		return true
+	}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != name {
+				continue
+			}
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				for _, dp := range data.DataPoints {
+					if matches(dp.Attributes) {
+						return float64(dp.Value), true
+					}
+				}
+			case metricdata.Gauge[float64]:
+				for _, dp := range data.DataPoints {
+					if matches(dp.Attributes) {
+						return dp.Value, true
+					}
+				}
+			}
+		}
+	}
+	return 0, false
+}
+
+func TestStatsWindowAggregation(t *testing.T) {
+	previous := otel.GetMeterProvider()
+	reader := sdkmetric.NewManualReader()
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	t.Cleanup(func() { otel.SetMeterProvider(previous) })
+
+	clock := &fakeClock{current: time.Unix(1700000000, 0)}
+	s := newTestMeterServer(WithStatsIntervalInSeconds(60), WithClock(clock.now))
+	stream := &fakeStream{}
+
+	s.HandleRequest(stream, statsRequest("envoy-1"))
+	clock.current = clock.current.Add(10 * time.Second)
+	s.HandleRequest(stream, statsRequest("envoy-1", clusterStats("web", 5, 1, 1), clusterStats("api", 2, 0, 0)))
+	clock.current = clock.current.Add(10 * time.Second)
+	s.HandleRequest(stream, statsRequest("envoy-1", clusterStats("web", 7, 0, 0)))
+
+	web := func(kind string) []attribute.KeyValue {
+		return []attribute.KeyValue{
+			meter.NodeIDAttrKey.String("envoy-1"),
+			meter.ClusterAttrKey.String("web"),
+			meter.RequestsAttrKey.String(kind),
+		}
+	}
+	if _, ok := dataPoint(t, reader, "lrs_requests", web(requestsSuccessful)...); ok {
+		t.Fatalf("expected no stats recorded within the window")
+	}
+
+	clock.current = clock.current.Add(50 * time.Second)
+	s.HandleRequest(stream, statsRequest("envoy-1", clusterStats("web", 3, 0, 0)))
+
+	tests := []struct {
+		metric string
+		kind   string
+		want   float64
+	}{
+		{"lrs_requests", requestsSuccessful, 15},
+		{"lrs_requests", requestsError, 1},
+		{"lrs_requests", requestsIssued, 16},
+		{"lrs_requests", requestsDropped, 1},
+		{"lrs_request_rate", requestsSuccessful, 0.25},
+	}
+	for _, tt := range tests {
+		got, ok := dataPoint(t, reader, tt.metric, web(tt.kind)...)
+		if !ok || got != tt.want {
+			t.Errorf("%s %s: expected %v, got %v (recorded %t)", tt.metric, tt.kind, tt.want, got, ok)
+		}
+	}
+
+	api := []attribute.KeyValue{meter.ClusterAttrKey.String("api"), meter.RequestsAttrKey.String(requestsSuccessful)}
+	if got, ok := dataPoint(t, reader, "lrs_requests", api...); !ok || got != 2 {
+		t.Errorf("expected the api window to be flushed with 2 requests, got %v (recorded %t)", got, ok)
+	}
+	if len(s.windows) != 0 {
+		t.Errorf("expected every elapsed window to be flushed, got %v", s.windows)
+	}
+}
+
+func TestDuplicateNodeID(t *testing.T) {
+	previous := otel.GetMeterProvider()
+	reader := sdkmetric.NewManualReader()
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	t.Cleanup(func() { otel.SetMeterProvider(previous) })
+
+	handler := logger.NewMemoryHandler()
+	l := &logger.Klogger{}
+	l.SetLogger(slog.New(handler))
+	s := NewMeterServer(l).(*MeterServer)
+
+	first, second := &fakeStream{}, &fakeStream{}
+	request := statsRequest("envoy-1")
+	s.HandleRequest(first, request)
+	s.HandleRequest(first, request)
+	s.HandleRequest(second, request)
+
+	var warnings int
+	for _, r := range handler.Records() {
+		if r.Level == slog.LevelWarn && strings.Contains(r.Message, "envoy-1") {
+			warnings++
+		}
+	}
+	if warnings != 1 {
+		t.Errorf("expected one duplicate node warning, got %d", warnings)
+	}
+	if got, _ := dataPoint(t, reader, "lrs_nodes"); got != 2 {
+		t.Errorf("expected 2 connected nodes, got %v", got)
+	}
+
+	s.removeNode(context.Background(), first, request.Node)
+	if got, _ := dataPoint(t, reader, "lrs_nodes"); got != 1 {
+		t.Errorf("expected 1 connected node after the first disconnects, got %v", got)
+	}
+	if s.connectedNodes() != 1 {
+		t.Errorf("expected the second connection to remain, got %v", s.nodesConnected)
+	}
+	s.removeNode(context.Background(), second, request.Node)
+	if got, _ := dataPoint(t, reader, "lrs_nodes"); got != 0 {
+		t.Errorf("expected no connected node, got %v", got)
+	}
+	if len(s.nodesConnected) != 0 || len(s.streams) != 0 {
+		t.Errorf("expected every connection to be forgotten, got %v %v", s.nodesConnected, s.streams)
+	}
+}
+
+func TestNodeGaugeSendFailure(t *testing.T) {
+	previous := otel.GetMeterProvider()
+	reader := sdkmetric.NewManualReader()
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	t.Cleanup(func() { otel.SetMeterProvider(previous) })
+
+	s := newTestMeterServer()
+	request := statsRequest("envoy-1")
+	stream := &fakeStream{requests: []*loadReportingService.LoadStatsRequest{request}, sendErr: errors.New("stream broken")}
+	if err := s.StreamLoadStats(stream); err != io.EOF {
+		t.Fatalf("expected the stream to end with EOF, got %v", err)
+	}
+	if got, _ := dataPoint(t, reader, "lrs_nodes"); got != 0 {
+		t.Errorf("expected the failed node uncounted once, got %v connected", got)
+	}
+	if len(s.nodesConnected) != 0 || len(s.streams) != 0 {
+		t.Errorf("expected the connection forgotten, got %v %v", s.nodesConnected, s.streams)
+	}
+}
+
+func TestClusterSelector(t *testing.T) {
+	s := newTestMeterServer(
+		WithClusters(func() []string { return []string{"web.default:http", "api.default:grpc", "db.billing:5432"} }),
+		WithClusterSelector(func(node *corev3.Node, cluster string) bool {
+			namespace := node.GetMetadata().GetFields()["namespace"].GetStringValue()
+			return strings.Contains(cluster, "."+namespace+":")
+		}),
+	)
+
+	tests := []struct {
+		namespace string
+		want      []string
+	}{
+		{"default", []string{"web.default:http", "api.default:grpc"}},
+		{"billing", []string{"db.billing:5432"}},
+		{"other", []string{}},
+	}
+	for _, tt := range tests {
+		stream := &fakeStream{}
+		request := statsRequest("envoy-" + tt.namespace)
+		request.Node.Metadata = &structpb.Struct{Fields: map[string]*structpb.Value{
+			"namespace": structpb.NewStringValue(tt.namespace),
+		}}
+		s.HandleRequest(stream, request)
+		if len(stream.responses) != 1 {
+			t.Fatalf("%s: expected one response, got %d", tt.namespace, len(stream.responses))
+		}
+		if got := stream.responses[0].Clusters; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected clusters %v, got %v", tt.namespace, tt.want, got)
+		}
+	}
+}
+
+func TestReportedClustersDefault(t *testing.T) {
+	stream := &fakeStream{}
+	newTestMeterServer().HandleRequest(stream, statsRequest("envoy-1"))
+	if got := stream.responses[0].Clusters; !reflect.DeepEqual(got, []string{"edge"}) {
+		t.Errorf("expected the node cluster, got %v", got)
+	}
+}
+
+func TestStreamLoadStatsNilNode(t *testing.T) {
+	handler := logger.NewMemoryHandler()
+	l := &logger.Klogger{}
+	l.SetLogger(slog.New(handler))
+	s := NewMeterServer(l).(*MeterServer)
+
+	stream := &fakeStream{requests: []*loadReportingService.LoadStatsRequest{
+		{},
+		{Node: &corev3.Node{}},
+		statsRequest("envoy-1"),
+	}}
+	if err := s.StreamLoadStats(stream); !errors.Is(err, io.EOF) {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+
+	var warnings int
+	for _, r := range handler.Records() {
+		if r.Level == slog.LevelWarn {
+			warnings++
+		}
+	}
+	if warnings != 2 {
+		t.Errorf("expected a warning per request without node ID, got %d", warnings)
+	}
+	if len(stream.responses) != 1 {
+		t.Errorf("expected only the valid node to be answered, got %d responses", len(stream.responses))
+	}
+	if s.connectedNodes() != 0 || len(s.streams) != 0 {
+		t.Errorf("expected the node to be removed once the stream ends, got %v", s.nodesConnected)
+	}
+
+	s.HandleRequest(&fakeStream{}, &loadReportingService.LoadStatsRequest{})
+	if s.connectedNodes() != 0 {
+		t.Errorf("expected a request without node to be ignored, got %v", s.nodesConnected)
+	}
+}
+
+func TestClustersChanged(t *testing.T) {
+	clusters := []string{"web.default:http"}
+	s := newTestMeterServer(WithClusters(func() []string { return clusters }))
+
+	connected, idle := &fakeStream{}, &fakeStream{}
+	s.HandleRequest(connected, statsRequest("envoy-1"))
+	s.HandleRequest(idle, statsRequest("envoy-2"))
+	s.removeNode(context.Background(), idle, &corev3.Node{Id: "envoy-2"})
+
+	s.ClustersChanged()
+	if len(connected.responses) != 1 {
+		t.Fatalf("expected no update while clusters are unchanged, got %d responses", len(connected.responses))
+	}
+
+	clusters = []string{"web.default:http", "api.default:grpc"}
+	s.ClustersChanged()
+	if len(connected.responses) != 2 {
+		t.Fatalf("expected an updated response, got %d responses", len(connected.responses))
+	}
+	if got := connected.responses[1].Clusters; !reflect.DeepEqual(got, clusters) {
+		t.Errorf("expected clusters %v, got %v", clusters, got)
+	}
+	if len(idle.responses) != 1 {
+		t.Errorf("expected disconnected nodes not to be updated, got %d responses", len(idle.responses))
+	}
+}
+
+func TestClusterLimit(t *testing.T) {
+	previous := otel.GetMeterProvider()
+	reader := sdkmetric.NewManualReader()
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	t.Cleanup(func() { otel.SetMeterProvider(previous) })
+
+	handler := logger.NewMemoryHandler()
+	l := &logger.Klogger{}
+	l.SetLogger(slog.New(handler))
+	s := NewMeterServer(l, WithClusterLimit(2)).(*MeterServer)
+
+	stream := &fakeStream{}
+	s.HandleRequest(stream, statsRequest("envoy-1"))
+	s.HandleRequest(stream, statsRequest("envoy-1",
+		clusterStats("web", 1, 0, 0),
+		clusterStats("api", 2, 0, 0),
+		clusterStats("db", 3, 0, 0),
+		clusterStats("cache", 4, 0, 0),
+	))
+	// clusters already labeled keep their label
+	other := &fakeStream{}
+	s.HandleRequest(other, statsRequest("envoy-2"))
+	s.HandleRequest(other, statsRequest("envoy-2", clusterStats("api", 5, 0, 0), clusterStats("queue", 6, 0, 0)))
+	s.Stop()
+
+	tests := []struct {
+		nodeID, cluster string
+		want            float64
+	}{
+		{"envoy-1", "web", 1},
+		{"envoy-1", "api", 2},
+		{"envoy-1", OverflowCluster, 7},
+		{"envoy-2", "api", 5},
+		{"envoy-2", OverflowCluster, 6},
+	}
+	for _, tt := range tests {
+		got, ok := dataPoint(t, reader, "lrs_requests",
+			meter.NodeIDAttrKey.String(tt.nodeID),
+			meter.ClusterAttrKey.String(tt.cluster),
+			meter.RequestsAttrKey.String(requestsSuccessful),
+		)
+		if !ok || got != tt.want {
+			t.Errorf("%s %s: expected %v requests, got %v (recorded %t)", tt.nodeID, tt.cluster, tt.want, got, ok)
+		}
+	}
+	for _, cluster := range []string{"db", "cache", "queue"} {
+		if _, ok := dataPoint(t, reader, "lrs_requests", meter.ClusterAttrKey.String(cluster)); ok {
+			t.Errorf("expected no series for cluster %s beyond the limit", cluster)
+		}
+	}
+
+	var warnings int
+	for _, r := range handler.Records() {
+		if r.Level == slog.LevelWarn && strings.Contains(r.Message, OverflowCluster) {
+			warnings++
+		}
+	}
+	if warnings != 1 {
+		t.Errorf("expected one warning once the limit is hit, got %d", warnings)
+	}
+}
+
+// seriesValues returns the values of key across the series recorded for name
+func seriesValues(t *testing.T, reader *sdkmetric.ManualReader, name string, key attribute.Key) map[string]bool {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]bool{}
+	add := func(set attribute.Set) {
+		if v, ok := set.Value(key); ok {
+			values[v.AsString()] = true
+		}
+	}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != name {
+				continue
+			}
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				for _, dp := range data.DataPoints {
+					add(dp.Attributes)
+				}
+			case metricdata.Gauge[float64]:
+				for _, dp := range data.DataPoints {
+					add(dp.Attributes)
+				}
+			}
+		}
+	}
+	return values
+}
+
+func TestLabelLimitsExportedSeries(t *testing.T) {
+	previous := otel.GetMeterProvider()
+	reader := sdkmetric.NewManualReader()
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	t.Cleanup(func() { otel.SetMeterProvider(previous) })
+
+	clock := &fakeClock{current: time.Unix(1700000000, 0)}
+	s := newTestMeterServer(WithStatsIntervalInSeconds(60), WithClusterLimit(2), WithNodeLimit(1), WithClock(clock.now))
+
+	// every interval, a new node reports a new cluster
+	for i := 0; i < 5; i++ {
+		clock.current = clock.current.Add(time.Minute)
+		stream := &fakeStream{}
+		nodeID := fmt.Sprintf("envoy-%d", i)
+		s.HandleRequest(stream, statsRequest(nodeID))
+		s.HandleRequest(stream, statsRequest(nodeID, clusterStats(fmt.Sprintf("cluster-%d", i), 1, 0, 0), clusterStats("web", 1, 0, 0)))
+	}
+	s.Stop()
+
+	for _, name := range []string{"lrs_requests", "lrs_request_rate"} {
+		clusters := seriesValues(t, reader, name, meter.ClusterAttrKey)
+		if want := map[string]bool{"cluster-0": true, "web": true, OverflowCluster: true}; !maps.Equal(clusters, want) {
+			t.Errorf("%s: expected the clusters %v exported, got %v", name, want, clusters)
+		}
+		nodes := seriesValues(t, reader, name, meter.NodeIDAttrKey)
+		if want := map[string]bool{"envoy-0": true, OverflowNode: true}; !maps.Equal(nodes, want) {
+			t.Errorf("%s: expected the nodes %v exported, got %v", name, want, nodes)
+		}
+	}
+	if got, ok := dataPoint(t, reader, "lrs_requests",
+		meter.NodeIDAttrKey.String(OverflowNode),
+		meter.ClusterAttrKey.String("web"),
+		meter.RequestsAttrKey.String(requestsSuccessful),
+	); !ok || got != 4 {
+		t.Errorf("expected the 4 web requests of the nodes beyond the limit summed, got %v (recorded %t)", got, ok)
+	}
+}
+
+func TestRunLogsConfig(t *testing.T) {
+	l, observer := logger.NewObserver()
+	s := NewMeterServer(l, WithStatsIntervalInSeconds(30), WithDrainTimeout(time.Second), WithClusterLimit(50)).(*MeterServer)
+
+	done := make(chan struct{})
+	go func() {
+		s.Run()
+		close(done)
+	}()
+	s.Stop()
+	<-done
+	s.Run()
+
+	records := observer.FilterByMessage("Load reporting server configuration")
+	if len(records) != 1 {
+		t.Fatalf("expected the configuration logged once, got %d", len(records))
+	}
+	want := map[string]any{
+		"stats_interval_seconds": int64(30),
+		"drain_timeout":          time.Second,
+		"clusters":               "node",
+		"cluster_selector":       false,
+		"cluster_limit":          int64(50),
+		"node_limit":             int64(0),
+	}
+	for key, value := range want {
+		if records[0].Attrs[key] != value {
+			t.Errorf("%s: expected %v, got %v", key, value, records[0].Attrs[key])
+		}
+	}
+}
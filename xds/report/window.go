@@ -0,0 +1,166 @@
+package report
+
+import (
+	"context"
+	"time"
+
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	"github.com/nebucloud/pkg/xds/meter"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Request kinds recorded under meter.RequestsAttrKey
+const (
+	requestsSuccessful = "successful"
+	requestsError      = "error"
+	requestsIssued     = "issued"
+	requestsDropped    = "dropped"
+)
+
+// OverflowCluster is the cluster label of the stats of the clusters beyond
+// the limit set by WithClusterLimit
+const OverflowCluster = "__other__"
+
+// OverflowNode is the node ID label of the stats of the nodes beyond the
+// limit set by WithNodeLimit
+const OverflowNode = "__other__"
+
+// labelLimit hands out at most max distinct labels for the lifetime of the
+// server, as the exported series are cumulative, the values beyond sharing
+// overflow. Zero or less disables the limit.
+type labelLimit struct {
+	max      int
+	overflow string
+	labeled  map[string]bool
+	hit      bool
+}
+
+// label returns the label of value, reporting the first value beyond max.
+func (l *labelLimit) label(value string) (label string, firstOverflow bool) {
+	if l.max <= 0 || l.labeled[value] {
+		return value, false
+	}
+	if len(l.labeled) < l.max {
+		if l.labeled == nil {
+			l.labeled = make(map[string]bool)
+		}
+		l.labeled[value] = true
+		return value, false
+	}
+	firstOverflow = !l.hit
+	l.hit = true
+	return l.overflow, firstOverflow
+}
+
+// statsKey identifies the stats of a cluster reported by a node
+type statsKey struct {
+	nodeID, cluster string
+}
+
+// statsWindow sums the stats reported for a statsKey since start
+type statsWindow struct {
+	start  time.Time
+	totals map[string]uint64
+}
+
+func newStatsWindow(start time.Time) *statsWindow {
+	return &statsWindow{start: start, totals: make(map[string]uint64)}
+}
+
+func (w *statsWindow) add(stats *endpointv3.ClusterStats) {
+	for _, locality := range stats.GetUpstreamLocalityStats() {
+		w.totals[requestsSuccessful] += locality.GetTotalSuccessfulRequests()
+		w.totals[requestsError] += locality.GetTotalErrorRequests()
+		w.totals[requestsIssued] += locality.GetTotalIssuedRequests()
+	}
+	w.totals[requestsDropped] += stats.GetTotalDroppedRequests()
+}
+
+// window returns the aggregation window, the reporting interval.
+func (s *MeterServer) window() time.Duration {
+	return time.Duration(s.statsIntervalInSeconds) * time.Second
+}
+
+// aggregateStats adds the cluster stats reported by nodeID to their window.
+// The caller must hold s.lock.
+func (s *MeterServer) aggregateStats(nodeID string, clusterStats []*endpointv3.ClusterStats) {
+	nodeLabel, firstOverflow := s.nodeLabels.label(nodeID)
+	if firstOverflow {
+		s.logger.Warnf("Node limit %d reached, recording the stats of node %s and further ones as %s", s.nodeLabels.max, nodeID, OverflowNode)
+	}
+	for _, stats := range clusterStats {
+		key := statsKey{nodeID: nodeLabel, cluster: s.clusterLabel(stats.GetClusterName())}
+		w, ok := s.windows[key]
+		if !ok {
+			w = newStatsWindow(s.now())
+			s.windows[key] = w
+		}
+		w.add(stats)
+	}
+}
+
+// clusterLabel returns the label cluster is recorded under: the cluster itself
+// while the labeled clusters are within the limit, OverflowCluster beyond.
+// The caller must hold s.lock.
+func (s *MeterServer) clusterLabel(cluster string) string {
+	label, firstOverflow := s.clusterLabels.label(cluster)
+	if firstOverflow {
+		s.logger.Warnf("Cluster limit %d reached, recording the stats of cluster %s and further ones as %s", s.clusterLabels.max, cluster, OverflowCluster)
+	}
+	return label
+}
+
+// flushWindows records the totals and rates of the windows matching keep
+// that lasted for the reporting interval, or all of them when force is set.
+// The caller must hold s.lock.
+func (s *MeterServer) flushWindows(ctx context.Context, force bool, keep func(statsKey) bool) {
+	now := s.now()
+	for key, w := range s.windows {
+		if !keep(key) {
+			continue
+		}
+		elapsed := now.Sub(w.start)
+		if !force && elapsed < s.window() {
+			continue
+		}
+		for kind, total := range w.totals {
+			attrs := metric.WithAttributes(
+				meter.NodeIDAttrKey.String(key.nodeID),
+				meter.ClusterAttrKey.String(key.cluster),
+				meter.RequestsAttrKey.String(kind),
+			)
+			s.requestsCounter.Add(ctx, int64(total), attrs)
+			if elapsed > 0 {
+				s.requestRateGauge.Record(ctx, float64(total)/elapsed.Seconds(), attrs)
+			}
+		}
+		delete(s.windows, key)
+	}
+}
+
+// WithClock returns an option to set the clock used for aggregation windows.
+func WithClock(now func() time.Time) Option {
+	return func(s *MeterServer) {
+		s.now = now
+	}
+}
+
+// WithClusterLimit returns an option to record the stats of at most max
+// distinct clusters, bounding the metrics cardinality. The stats of the
+// clusters first reported beyond max are recorded as OverflowCluster. Zero or
+// less disables the limit.
+func WithClusterLimit(max int) Option {
+	return func(s *MeterServer) {
+		s.clusterLabels.max = max
+	}
+}
+
+// WithNodeLimit returns an option to record the stats of at most max
+// distinct node IDs, bounding the metrics cardinality. The stats of the
+// nodes first reported beyond max are recorded as OverflowNode. Zero or less
+// disables the limit.
+func WithNodeLimit(max int) Option {
+	return func(s *MeterServer) {
+		s.nodeLabels.max = max
+	}
+}
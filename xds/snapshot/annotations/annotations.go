@@ -0,0 +1,89 @@
+package annotations
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error reports an annotation whose value failed to parse or validate.
+type Error struct {
+	Key    string
+	Value  string
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("annotation %s=%q: %s", e.Key, e.Value, e.Reason)
+}
+
+// GetDuration returns the duration stored under key, or def if absent.
+// Negative durations are rejected.
+func GetDuration(annotations map[string]string, key string, def time.Duration) (time.Duration, error) {
+	raw, ok := annotations[key]
+	if !ok {
+		return def, nil
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(raw))
+	if err != nil {
+		return def, &Error{Key: key, Value: raw, Reason: "expect a duration such as 500ms or 5s"}
+	}
+	if d < 0 {
+		return def, &Error{Key: key, Value: raw, Reason: "expect a non-negative duration"}
+	}
+	return d, nil
+}
+
+// GetInt returns the integer stored under key, or def if absent.
+func GetInt(annotations map[string]string, key string, def int) (int, error) {
+	raw, ok := annotations[key]
+	if !ok {
+		return def, nil
+	}
+	i, err := strconv.Atoi(strings.TrimSpace(raw))
+	if err != nil {
+		return def, &Error{Key: key, Value: raw, Reason: "expect an integer"}
+	}
+	return i, nil
+}
+
+// GetEnum returns the value stored under key, or def if absent.
+// The value must be one of allowed, compared case-insensitively, and is
+// returned in the spelling given by allowed.
+func GetEnum(annotations map[string]string, key string, def string, allowed ...string) (string, error) {
+	raw, ok := annotations[key]
+	if !ok {
+		return def, nil
+	}
+	value := strings.TrimSpace(raw)
+	for _, a := range allowed {
+		if strings.EqualFold(value, a) {
+			return a, nil
+		}
+	}
+	return def, &Error{Key: key, Value: raw, Reason: fmt.Sprintf("expect one of [%s]", strings.Join(allowed, ", "))}
+}
+
+// GetStringList returns the comma separated list stored under key, or nil if
+// absent. Items are trimmed, must be non-empty, and must match pattern when
+// pattern is not nil.
+func GetStringList(annotations map[string]string, key string, pattern *regexp.Regexp) ([]string, error) {
+	raw, ok := annotations[key]
+	if !ok {
+		return nil, nil
+	}
+	items := strings.Split(raw, ",")
+	for i, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			return nil, &Error{Key: key, Value: raw, Reason: "expect a comma separated list without empty items"}
+		}
+		if pattern != nil && !pattern.MatchString(item) {
+			return nil, &Error{Key: key, Value: raw, Reason: fmt.Sprintf("item %q does not match regex %s", item, pattern.String())}
+		}
+		items[i] = item
+	}
+	return items, nil
+}
@@ -0,0 +1,119 @@
+package annotations
+
+import (
+	"errors"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+const key = "xds.nebucloud.com/test"
+
+func TestGetDuration(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  map[string]string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"absent", nil, time.Second, false},
+		{"valid", map[string]string{key: "250ms"}, 250 * time.Millisecond, false},
+		{"malformed", map[string]string{key: "soon"}, time.Second, true},
+		{"negative", map[string]string{key: "-5s"}, time.Second, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetDuration(tt.values, key, time.Second)
+			checkErr(t, err, tt.wantErr)
+			if got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestGetInt(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  map[string]string
+		want    int
+		wantErr bool
+	}{
+		{"absent", nil, 7, false},
+		{"valid", map[string]string{key: " 42 "}, 42, false},
+		{"malformed", map[string]string{key: "4x2"}, 7, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetInt(tt.values, key, 7)
+			checkErr(t, err, tt.wantErr)
+			if got != tt.want {
+				t.Errorf("expected %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestGetEnum(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  map[string]string
+		want    string
+		wantErr bool
+	}{
+		{"absent", nil, "eds", false},
+		{"valid", map[string]string{key: "STRICT_DNS"}, "strict_dns", false},
+		{"malformed", map[string]string{key: "dns"}, "eds", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetEnum(tt.values, key, "eds", "eds", "strict_dns")
+			checkErr(t, err, tt.wantErr)
+			if got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestGetStringList(t *testing.T) {
+	pattern := regexp.MustCompile("^[a-z]+$")
+	tests := []struct {
+		name    string
+		values  map[string]string
+		want    []string
+		wantErr bool
+	}{
+		{"absent", nil, nil, false},
+		{"valid", map[string]string{key: "a, b,c"}, []string{"a", "b", "c"}, false},
+		{"empty item", map[string]string{key: "a,,c"}, nil, true},
+		{"pattern mismatch", map[string]string{key: "a,B"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetStringList(tt.values, key, pattern)
+			checkErr(t, err, tt.wantErr)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func checkErr(t *testing.T, err error, wantErr bool) {
+	t.Helper()
+	if !wantErr {
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return
+	}
+	var annotationErr *Error
+	if !errors.As(err, &annotationErr) {
+		t.Fatalf("expected *Error, got %v", err)
+	}
+	if annotationErr.Key != key {
+		t.Errorf("expected error key %s, got %s", key, annotationErr.Key)
+	}
+}
@@ -3,8 +3,10 @@ package apigateway
 import (
 	"fmt"
 	"regexp"
-	"strings"
+	"slices"
+	"sort"
 
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
 	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
 	routerv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/router/v3"
@@ -12,6 +14,8 @@ import (
 	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
 	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
 	"github.com/nebucloud/pkg/logger"
+	"github.com/nebucloud/pkg/xds/snapshot/annotations"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/anypb"
 	v1 "k8s.io/api/core/v1"
 )
@@ -20,81 +24,155 @@ const (
 	NameAnnotation    = "xds.nebucloud.com/api-gateway"
 	ServiceAnnotation = "xds.nebucloud.com/grpc-service"
 	PortName          = "grpc"
+	// CatchAllRouteName names the route of the unmatched gateway requests
+	CatchAllRouteName = "catch-all"
+	// PriorityAnnotation orders the routes of a service within its gateways,
+	// higher priorities first
+	PriorityAnnotation = "xds.nebucloud.com/route-priority"
 )
 
 var nameRegex = regexp.MustCompile("^[a-z0-9][a-z0-9-]{0,63}$")
 
+// ParseAnnotations returns the gateways and the gRPC services of a service
+// annotations, nil when absent.
+func ParseAnnotations(values map[string]string) (gateways, rpcs []string, err error) {
+	if gateways, err = annotations.GetStringList(values, NameAnnotation, nameRegex); err != nil {
+		return nil, nil, err
+	}
+	if rpcs, err = annotations.GetStringList(values, ServiceAnnotation, nil); err != nil {
+		return nil, nil, err
+	}
+	return gateways, rpcs, nil
+}
+
+// ParsePriority returns the route priority of a service annotations, 0 when absent.
+func ParsePriority(values map[string]string) (int, error) {
+	return annotations.GetInt(values, PriorityAnnotation, 0)
+}
+
+// HasPort reports whether svc has the port named PortName gateways route to.
+func HasPort(svc *v1.Service) bool {
+	for _, port := range svc.Spec.Ports {
+		if port.Name == PortName {
+			return true
+		}
+	}
+	return false
+}
+
 func FromKubeServices(services []*v1.Service, logger *logger.Klogger) ([]types.Resource, map[string]int) {
+	var routes []Route
+	for _, svc := range services {
+		routes = append(routes, RoutesFromKubeService(svc, logger)...)
+	}
+	return FromRoutes(routes, nil)
+}
+
+// Route routes a gRPC service to the cluster of its Kubernetes service on a gateway
+type Route struct {
+	Gateway  string
+	Route    *routev3.Route
+	Priority int
+}
+
+// RoutesFromKubeService returns the gateway routes of svc, in annotation order.
+func RoutesFromKubeService(svc *v1.Service, logger *logger.Klogger) []Route {
+	apiGateways, rpcs, err := ParseAnnotations(svc.Annotations)
+	if err != nil {
+		logger.Warnf("Service %s/%s API Gateway: %s", svc.Namespace, svc.Name, err)
+		return nil
+	}
+	if apiGateways == nil || rpcs == nil {
+		return nil
+	}
+	if !HasPort(svc) {
+		logger.Warnf("Service %s/%s has API Gateway annotation but no grpc named port", svc.Namespace, svc.Name)
+		return nil
+	}
+	priority, err := ParsePriority(svc.Annotations)
+	if err != nil {
+		logger.Warnf("Service %s/%s API Gateway: %s, using priority 0", svc.Namespace, svc.Name, err)
+	}
+	var out []Route
+	for _, gateway := range apiGateways {
+		for _, rpc := range rpcs {
+			out = append(out, Route{Gateway: gateway, Priority: priority, Route: &routev3.Route{
+				Name: rpc,
+				Match: &routev3.RouteMatch{
+					PathSpecifier: &routev3.RouteMatch_Prefix{
+						Prefix: "/" + rpc + "/",
+					},
+				},
+				Action: &routev3.Route_Route{
+					Route: &routev3.RouteAction{
+						ClusterSpecifier: &routev3.RouteAction_Cluster{
+							Cluster: fmt.Sprintf("%s.%s:%s", svc.Name, svc.Namespace, PortName),
+						},
+					},
+				},
+			}})
+		}
+	}
+	return out
+}
+
+// CatchAllRoute returns a route answering every request with a direct
+// response of status and body. The status must be within [200, 599].
+func CatchAllRoute(status uint32, body string) (*routev3.Route, error) {
+	if status < 200 || status > 599 {
+		return nil, fmt.Errorf("direct response status %d out of [200, 599]", status)
+	}
+	action := &routev3.DirectResponseAction{Status: status}
+	if body != "" {
+		action.Body = &corev3.DataSource{
+			Specifier: &corev3.DataSource_InlineString{InlineString: body},
+		}
+	}
+	return &routev3.Route{
+		Name: CatchAllRouteName,
+		Match: &routev3.RouteMatch{
+			PathSpecifier: &routev3.RouteMatch_Prefix{Prefix: "/"},
+		},
+		Action: &routev3.Route_DirectResponse{DirectResponse: action},
+	}, nil
+}
+
+// FromRoutes returns the gateway listeners and route configurations serving
+// routes, along with the number of routes per gateway. The routes of a gateway
+// are sorted by decreasing priority then name. catchAll, if not nil, ends
+// every gateway virtual host, each with its own copy, and is not counted.
+func FromRoutes(routes []Route, catchAll *routev3.Route) ([]types.Resource, map[string]int) {
+	routes = slices.Clone(routes)
+	sort.SliceStable(routes, func(i, j int) bool {
+		if routes[i].Priority != routes[j].Priority {
+			return routes[i].Priority > routes[j].Priority
+		}
+		return routes[i].Route.GetName() < routes[j].Route.GetName()
+	})
 	routerConfigs := map[string]*routev3.RouteConfiguration{}
 	gateways := map[string]*listenerv3.Listener{}
 	router, _ := anypb.New(&routerv3.Router{})
 
-outer:
-	for _, svc := range services {
-		apiGatewayRaw, ok := svc.Annotations[NameAnnotation]
-		if !ok {
-			continue
-		}
-		apiGateways := strings.Split(apiGatewayRaw, ",")
-		for _, name := range apiGateways {
-			if !nameRegex.MatchString(name) {
-				logger.Warnf("Service %s/%s API Gateway %s does not match regex %s", svc.Namespace, svc.Name, name, nameRegex.String())
-				continue outer
+	for _, r := range routes {
+		if _, ok := gateways[r.Gateway]; !ok {
+			gateways[r.Gateway] = &listenerv3.Listener{
+				Name: r.Gateway,
 			}
 		}
-		grpcServiceRaw, ok := svc.Annotations[ServiceAnnotation]
+		routeConfig, ok := routerConfigs[r.Gateway]
 		if !ok {
-			continue
-		}
-		rpcs := strings.Split(grpcServiceRaw, ",")
-		hasGrpcPort := false
-		for _, port := range svc.Spec.Ports {
-			if port.Name == PortName {
-				hasGrpcPort = true
-				break
-			}
-		}
-		if !hasGrpcPort {
-			logger.Warnf("Service %s/%s has API Gateway annotation but no grpc named port", svc.Namespace, svc.Name)
-			continue
-		}
-		for _, gateway := range apiGateways {
-			if _, ok = gateways[gateway]; !ok {
-				gateways[gateway] = &listenerv3.Listener{
-					Name: gateway,
-				}
-			}
-			routeConfig, ok := routerConfigs[gateway]
-			if !ok {
-				routeConfig = &routev3.RouteConfiguration{
-					Name: gateway,
-					VirtualHosts: []*routev3.VirtualHost{
-						{
-							Name:    gateway,
-							Domains: []string{gateway},
-						},
-					},
-				}
-				routerConfigs[gateway] = routeConfig
-			}
-			for _, rpc := range rpcs {
-				routeConfig.VirtualHosts[0].Routes = append(routeConfig.VirtualHosts[0].Routes, &routev3.Route{
-					Name: rpc,
-					Match: &routev3.RouteMatch{
-						PathSpecifier: &routev3.RouteMatch_Prefix{
-							Prefix: "/" + rpc + "/",
-						},
-					},
-					Action: &routev3.Route_Route{
-						Route: &routev3.RouteAction{
-							ClusterSpecifier: &routev3.RouteAction_Cluster{
-								Cluster: fmt.Sprintf("%s.%s:%s", svc.Name, svc.Namespace, PortName),
-							},
-						},
+			routeConfig = &routev3.RouteConfiguration{
+				Name: r.Gateway,
+				VirtualHosts: []*routev3.VirtualHost{
+					{
+						Name:    r.Gateway,
+						Domains: []string{r.Gateway},
 					},
-				})
+				},
 			}
+			routerConfigs[r.Gateway] = routeConfig
 		}
+		routeConfig.VirtualHosts[0].Routes = append(routeConfig.VirtualHosts[0].Routes, r.Route)
 	}
 
 	var out []types.Resource
@@ -120,6 +198,9 @@ outer:
 		stats[gateway.Name] = len(routerConfigs[name].VirtualHosts[0].Routes)
 	}
 	for _, route := range routerConfigs {
+		if catchAll != nil {
+			route.VirtualHosts[0].Routes = append(route.VirtualHosts[0].Routes, proto.Clone(catchAll).(*routev3.Route))
+		}
 		out = append(out, route)
 	}
 	return out, stats
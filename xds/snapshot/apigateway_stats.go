@@ -0,0 +1,30 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+)
+
+// ApiGatewayStats returns the number of routes of each api gateway in the
+// last services snapshot.
+func (s *Snapshotter) ApiGatewayStats() map[string]int {
+	stats := maps.Clone(s.getAPIGatewayStats())
+	if stats == nil {
+		stats = map[string]int{}
+	}
+	return stats
+}
+
+// ApiGatewayStatsHandler returns an http.Handler reporting ApiGatewayStats as JSON.
+func (s *Snapshotter) ApiGatewayStatsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(s.ApiGatewayStats())
+	})
+}
@@ -0,0 +1,57 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestApiGatewayStats(t *testing.T) {
+	s := newTestSnapshotter()
+	if stats := s.ApiGatewayStats(); len(stats) != 0 {
+		t.Errorf("expected no stats before an emit, got %v", stats)
+	}
+
+	memdb, err := s.createMemDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loop := &servicesLoop{memdb: memdb, edgedb: &fakeEdgeDB{}, consul: &fakeConsul{}}
+	services := []*corev1.Service{
+		gatewayService("api", "default", "public"),
+		gatewayService("users", "default", "public"),
+		gatewayService("admin", "default", "internal"),
+	}
+	s.emitServices(context.Background(), loop, "1", services)
+
+	want := map[string]int{"public": 2, "internal": 1}
+	stats := s.ApiGatewayStats()
+	if !maps.Equal(stats, want) {
+		t.Errorf("expected stats %v, got %v", want, stats)
+	}
+	stats["public"] = 0
+	if got := s.ApiGatewayStats()["public"]; got != 2 {
+		t.Errorf("expected the stats unaffected by the caller, got %d", got)
+	}
+
+	rec := httptest.NewRecorder()
+	s.ApiGatewayStatsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/apigateway", nil))
+	var served map[string]int
+	if err := json.Unmarshal(rec.Body.Bytes(), &served); err != nil {
+		t.Fatalf("expected a JSON body, got %q: %v", rec.Body.String(), err)
+	}
+	if rec.Code != http.StatusOK || !maps.Equal(served, want) {
+		t.Errorf("expected 200 with %v, got %d %v", want, rec.Code, served)
+	}
+
+	rec = httptest.NewRecorder()
+	s.ApiGatewayStatsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/apigateway", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 for a POST, got %d", rec.Code)
+	}
+}
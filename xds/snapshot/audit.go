@@ -0,0 +1,243 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/nebucloud/pkg/logger"
+	"github.com/nebucloud/pkg/xds/meter"
+	"github.com/nebucloud/pkg/xds/snapshot/annotations"
+	"github.com/nebucloud/pkg/xds/snapshot/apigateway"
+	"go.opentelemetry.io/otel/metric"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// annotationPrefix prefixes every annotation the snapshotter interprets
+const annotationPrefix = "xds.nebucloud.com/"
+
+// annotationAudit records how the annotations of a service were interpreted
+type annotationAudit struct {
+	parsed  map[string]any
+	ignored map[string]string
+	// invalid holds the keys of the annotations failing to parse
+	invalid map[string]bool
+}
+
+func (a *annotationAudit) parse(key string, value any) {
+	a.parsed[key] = value
+}
+
+// ignore records reason for every key of keys present in values.
+func (a *annotationAudit) ignore(values map[string]string, reason string, keys ...string) {
+	for _, key := range keys {
+		if _, ok := values[key]; ok {
+			a.ignored[key] = reason
+		}
+	}
+}
+
+// reject ignores the keys of keys present in values for err, recording as
+// invalid the annotation err is about, or all of them if err does not say.
+func (a *annotationAudit) reject(values map[string]string, err error, keys ...string) {
+	a.ignore(values, err.Error(), keys...)
+	var annotationErr *annotations.Error
+	if errors.As(err, &annotationErr) {
+		a.invalid[annotationErr.Key] = true
+		return
+	}
+	for _, key := range keys {
+		if _, ok := values[key]; ok {
+			a.invalid[key] = true
+		}
+	}
+}
+
+// auditAnnotations logs at debug level, per service, the annotations found,
+// their parsed values and the ones ignored along with the reason, and counts
+// the annotations failing to parse in xds_annotation_errors once per
+// resource version of a service.
+func (s *Snapshotter) auditAnnotations(ctx context.Context, log *logger.Klogger, services []*corev1.Service) {
+	debug := log.Slog().Enabled(ctx, slog.LevelDebug)
+	versions := make(map[string]string, len(services))
+	var clusters map[string]bool
+	for _, svc := range services {
+		var found []string
+		for key := range svc.Annotations {
+			if strings.HasPrefix(key, annotationPrefix) {
+				found = append(found, key)
+			}
+		}
+		if len(found) == 0 {
+			continue
+		}
+		sort.Strings(found)
+
+		key := svc.Namespace + "/" + svc.Name
+		version, counted := s.annotationErrorVersions[key]
+		count := !counted || version != svc.ResourceVersion
+		versions[key] = svc.ResourceVersion
+		if !debug && !count {
+			continue
+		}
+
+		if clusters == nil {
+			clusters = serviceClusterNames(services)
+		}
+		audit := s.auditService(svc, clusters)
+		for _, key := range found {
+			_, parsed := audit.parsed[key]
+			if _, ignored := audit.ignored[key]; !parsed && !ignored {
+				audit.ignored[key] = "unknown annotation"
+			}
+			if count && audit.invalid[key] {
+				s.annotationErrorCounter.Add(ctx, 1, metric.WithAttributes(meter.AnnotationAttrKey.String(key)))
+			}
+		}
+
+		if debug {
+			log.WithObject(svc).DebugContext(ctx, "annotations audit",
+				"found", found,
+				slog.Group("parsed", sortedAttrs(audit.parsed)...),
+				slog.Group("ignored", sortedAttrs(audit.ignored)...),
+			)
+		}
+	}
+	s.annotationErrorVersions = versions
+}
+
+// auditService interprets the annotations of svc, clusters holding the
+// cluster names of the emitted services.
+func (s *Snapshotter) auditService(svc *corev1.Service, clusters map[string]bool) *annotationAudit {
+	audit := &annotationAudit{parsed: map[string]any{}, ignored: map[string]string{}, invalid: map[string]bool{}}
+	s.auditAPIGateway(audit, svc)
+	s.auditTCPKeepalive(audit, svc)
+	auditClusterType(audit, svc)
+	auditMirror(audit, svc, clusters)
+	auditHeaders(audit, svc)
+	return audit
+}
+
+func (s *Snapshotter) auditAPIGateway(audit *annotationAudit, svc *corev1.Service) {
+	keys := []string{apigateway.NameAnnotation, apigateway.ServiceAnnotation, apigateway.PriorityAnnotation}
+	gateways, rpcs, err := apigateway.ParseAnnotations(svc.Annotations)
+	switch {
+	case !s.apiGateway:
+		audit.ignore(svc.Annotations, "api gateway disabled", keys...)
+	case err != nil:
+		audit.reject(svc.Annotations, err, keys...)
+	case gateways == nil || rpcs == nil:
+		audit.ignore(svc.Annotations, "expect both annotations", keys...)
+	case !apigateway.HasPort(svc):
+		audit.ignore(svc.Annotations, "no "+apigateway.PortName+" named port", keys...)
+	default:
+		audit.parse(apigateway.NameAnnotation, gateways)
+		audit.parse(apigateway.ServiceAnnotation, rpcs)
+		if _, ok := svc.Annotations[apigateway.PriorityAnnotation]; !ok {
+			break
+		}
+		if priority, err := apigateway.ParsePriority(svc.Annotations); err != nil {
+			audit.reject(svc.Annotations, err, apigateway.PriorityAnnotation)
+		} else {
+			audit.parse(apigateway.PriorityAnnotation, priority)
+		}
+	}
+}
+
+func (s *Snapshotter) auditTCPKeepalive(audit *annotationAudit, svc *corev1.Service) {
+	keys := []string{TCPKeepaliveProbesAnnotation, TCPKeepaliveTimeAnnotation, TCPKeepaliveIntervalAnnotation}
+	var def tcpKeepalive
+	if s.tcpKeepalive != nil {
+		def = *s.tcpKeepalive
+	}
+	k, annotated, err := annotatedTCPKeepalive(svc.Annotations, def)
+	switch {
+	case !annotated:
+	case err != nil:
+		audit.reject(svc.Annotations, err, keys...)
+	default:
+		values := map[string]any{
+			TCPKeepaliveProbesAnnotation:   k.probes,
+			TCPKeepaliveTimeAnnotation:     k.time,
+			TCPKeepaliveIntervalAnnotation: k.interval,
+		}
+		for _, key := range keys {
+			if _, ok := svc.Annotations[key]; ok {
+				audit.parse(key, values[key])
+			}
+		}
+	}
+}
+
+func auditClusterType(audit *annotationAudit, svc *corev1.Service) {
+	if _, ok := svc.Annotations[ClusterTypeAnnotation]; !ok {
+		return
+	}
+	clusterType, err := serviceClusterType(svc)
+	if err != nil {
+		audit.reject(svc.Annotations, err, ClusterTypeAnnotation)
+		return
+	}
+	audit.parse(ClusterTypeAnnotation, clusterType)
+}
+
+func auditMirror(audit *annotationAudit, svc *corev1.Service, clusters map[string]bool) {
+	keys := []string{MirrorClusterAnnotation, MirrorPercentAnnotation}
+	if _, ok := svc.Annotations[MirrorClusterAnnotation]; !ok {
+		audit.ignore(svc.Annotations, "expect "+MirrorClusterAnnotation, MirrorPercentAnnotation)
+		return
+	}
+	mirror, err := serviceMirror(svc, clusters)
+	if err != nil {
+		audit.reject(svc.Annotations, err, keys...)
+		return
+	}
+	audit.parse(MirrorClusterAnnotation, mirror.cluster)
+	if _, ok := svc.Annotations[MirrorPercentAnnotation]; ok {
+		audit.parse(MirrorPercentAnnotation, mirror.percent)
+	}
+}
+
+func auditHeaders(audit *annotationAudit, svc *corev1.Service) {
+	for _, key := range []string{RequestHeadersToAddAnnotation, ResponseHeadersToAddAnnotation} {
+		if _, ok := svc.Annotations[key]; !ok {
+			continue
+		}
+		headers, err := headersToAdd(svc.Annotations, key)
+		if err != nil {
+			audit.reject(svc.Annotations, err, key)
+			continue
+		}
+		pairs := make([]string, 0, len(headers))
+		for _, h := range headers {
+			pairs = append(pairs, h.Header.Key+"="+h.Header.Value)
+		}
+		audit.parse(key, pairs)
+	}
+	for _, key := range []string{RequestHeadersToRemoveAnnotation, ResponseHeadersToRemoveAnnotation} {
+		if _, ok := svc.Annotations[key]; !ok {
+			continue
+		}
+		if names, err := headersToRemove(svc.Annotations, key); err != nil {
+			audit.reject(svc.Annotations, err, key)
+		} else {
+			audit.parse(key, names)
+		}
+	}
+}
+
+// sortedAttrs returns the attrs of values sorted by key.
+func sortedAttrs[V any](values map[string]V) []any {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	attrs := make([]any, 0, len(keys))
+	for _, key := range keys {
+		attrs = append(attrs, slog.Any(key, values[key]))
+	}
+	return attrs
+}
@@ -0,0 +1,108 @@
+package snapshot
+
+import (
+	"fmt"
+	"net"
+
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/nebucloud/pkg/xds/snapshot/annotations"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ClusterTypeAnnotation is the service annotation overriding how its clusters
+// resolve endpoints, one of the ClusterType* values
+const ClusterTypeAnnotation = "xds.nebucloud.com/cluster-type"
+
+// Values of ClusterTypeAnnotation
+const (
+	ClusterTypeEDS        = "eds"
+	ClusterTypeStrictDNS  = "strict_dns"
+	ClusterTypeLogicalDNS = "logical_dns"
+	ClusterTypeStatic     = "static"
+)
+
+// clusterDomain suffixes the FQDN of services resolved by DNS
+const clusterDomain = "svc.cluster.local"
+
+var clusterTypes = map[string]clusterv3.Cluster_DiscoveryType{
+	ClusterTypeEDS:        clusterv3.Cluster_EDS,
+	ClusterTypeStrictDNS:  clusterv3.Cluster_STRICT_DNS,
+	ClusterTypeLogicalDNS: clusterv3.Cluster_LOGICAL_DNS,
+	ClusterTypeStatic:     clusterv3.Cluster_STATIC,
+}
+
+// serviceClusterType returns the cluster type annotated on svc, eds by default.
+func serviceClusterType(svc *corev1.Service) (string, error) {
+	clusterType, err := annotations.GetEnum(svc.Annotations, ClusterTypeAnnotation, ClusterTypeEDS,
+		ClusterTypeEDS, ClusterTypeStrictDNS, ClusterTypeLogicalDNS, ClusterTypeStatic)
+	if err != nil {
+		return clusterType, err
+	}
+	if clusterType == ClusterTypeStatic && net.ParseIP(svc.Spec.ClusterIP) == nil {
+		return ClusterTypeEDS, &annotations.Error{
+			Key: ClusterTypeAnnotation, Value: svc.Annotations[ClusterTypeAnnotation],
+			Reason: "expect a service with a cluster IP",
+		}
+	}
+	return clusterType, nil
+}
+
+// applyClusterType sets the cluster type annotated on svc on its clusters in
+// resources. DNS clusters resolve the service FQDN and static ones its cluster
+// IP. Invalid annotations are logged and the clusters left to EDS.
+func (s *Snapshotter) applyClusterType(svc *corev1.Service, resources []types.Resource) {
+	clusterType, err := serviceClusterType(svc)
+	if err != nil {
+		s.logger.WithObject(svc).Warnf("Invalid cluster type annotation: %s", err)
+	}
+	if clusterType == ClusterTypeEDS {
+		return
+	}
+
+	address := fmt.Sprintf("%s.%s.%s", svc.Name, svc.Namespace, clusterDomain)
+	if clusterType == ClusterTypeStatic {
+		address = svc.Spec.ClusterIP
+	}
+	fullName := fmt.Sprintf("%s.%s", svc.Name, svc.Namespace)
+	ports := map[string]uint32{}
+	for _, port := range svc.Spec.Ports {
+		ports[net.JoinHostPort(fullName, port.Name)] = uint32(port.Port)
+	}
+	for _, r := range resources {
+		c, ok := r.(*clusterv3.Cluster)
+		if !ok {
+			continue
+		}
+		port, ok := ports[c.Name]
+		if !ok {
+			continue
+		}
+		c.ClusterDiscoveryType = &clusterv3.Cluster_Type{Type: clusterTypes[clusterType]}
+		c.EdsClusterConfig = nil
+		c.LoadAssignment = &endpointv3.ClusterLoadAssignment{
+			ClusterName: c.Name,
+			Endpoints: []*endpointv3.LocalityLbEndpoints{{
+				LbEndpoints: []*endpointv3.LbEndpoint{{
+					HostIdentifier: &endpointv3.LbEndpoint_Endpoint{
+						Endpoint: &endpointv3.Endpoint{
+							Address: &corev3.Address{
+								Address: &corev3.Address_SocketAddress{
+									SocketAddress: &corev3.SocketAddress{
+										Protocol: corev3.SocketAddress_TCP,
+										Address:  address,
+										PortSpecifier: &corev3.SocketAddress_PortValue{
+											PortValue: port,
+										},
+									},
+								},
+							},
+						},
+					},
+				}},
+			}},
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package snapshot
+
+import (
+	"testing"
+
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestServicesToResourcesClusterType(t *testing.T) {
+	tests := []struct {
+		name        string
+		clusterType string
+		clusterIP   string
+		want        clusterv3.Cluster_DiscoveryType
+		wantAddress string
+	}{
+		{"default", "", "10.0.0.1", clusterv3.Cluster_EDS, ""},
+		{"eds", "eds", "10.0.0.1", clusterv3.Cluster_EDS, ""},
+		{"strict dns", "strict_dns", "10.0.0.1", clusterv3.Cluster_STRICT_DNS, "web.default.svc.cluster.local"},
+		{"logical dns", "Logical_DNS", "", clusterv3.Cluster_LOGICAL_DNS, "web.default.svc.cluster.local"},
+		{"static", "static", "10.0.0.1", clusterv3.Cluster_STATIC, "10.0.0.1"},
+		{"static headless", "static", corev1.ClusterIPNone, clusterv3.Cluster_EDS, ""},
+		{"invalid", "original_dst", "10.0.0.1", clusterv3.Cluster_EDS, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+				Spec: corev1.ServiceSpec{
+					ClusterIP: tt.clusterIP,
+					Ports:     []corev1.ServicePort{{Name: "http", Port: 80}},
+				},
+			}
+			if tt.clusterType != "" {
+				svc.Annotations = map[string]string{ClusterTypeAnnotation: tt.clusterType}
+			}
+			resources, _ := newTestSnapshotter().servicesToResources([]*corev1.Service{svc})
+			clusters := findClusters(resources)
+			if len(clusters) != 1 {
+				t.Fatalf("expected one cluster, got %d", len(clusters))
+			}
+			c := clusters[0]
+			if got := c.GetType(); got != tt.want {
+				t.Errorf("expected cluster type %s, got %s", tt.want, got)
+			}
+
+			if tt.wantAddress == "" {
+				if c.GetEdsClusterConfig() == nil || c.GetLoadAssignment() != nil {
+					t.Errorf("expected an EDS config without load assignment, got %v", c)
+				}
+				return
+			}
+			if c.GetEdsClusterConfig() != nil {
+				t.Errorf("expected no EDS config, got %v", c.GetEdsClusterConfig())
+			}
+			if name := c.GetLoadAssignment().GetClusterName(); name != c.Name {
+				t.Errorf("expected the load assignment of %s, got %s", c.Name, name)
+			}
+			endpoints := c.GetLoadAssignment().GetEndpoints()
+			if len(endpoints) != 1 || len(endpoints[0].GetLbEndpoints()) != 1 {
+				t.Fatalf("expected a single endpoint, got %v", endpoints)
+			}
+			address := endpoints[0].GetLbEndpoints()[0].GetEndpoint().GetAddress().GetSocketAddress()
+			if address.GetAddress() != tt.wantAddress || address.GetPortValue() != 80 {
+				t.Errorf("expected endpoint %s:80, got %s:%d", tt.wantAddress, address.GetAddress(), address.GetPortValue())
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package snapshot
+
+import (
+	"context"
+	"time"
+)
+
+// WithEmitCoalescing returns an option to rebuild the snapshots on a
+// dedicated goroutine at most once per interval, a burst of changes marking
+// the loop dirty and yielding a single rebuild, instead of synchronously in
+// the reflector on every change. A zero interval keeps the synchronous emits,
+// a negative one is logged and ignored.
+func WithEmitCoalescing(interval time.Duration) Option {
+	return func(s *Snapshotter) {
+		if interval < 0 {
+			s.logger.Errorf("invalid emit coalescing interval %s: expect zero or more", interval)
+			return
+		}
+		s.emitInterval = interval
+	}
+}
+
+// coalescer runs its function for the marks received, at most once per
+// interval, on a single goroutine.
+type coalescer struct {
+	dirty chan struct{}
+}
+
+func newCoalescer() *coalescer {
+	return &coalescer{dirty: make(chan struct{}, 1)}
+}
+
+// mark requests a run of the function, never blocking.
+func (c *coalescer) mark() {
+	select {
+	case c.dirty <- struct{}{}:
+	default:
+	}
+}
+
+// run calls fn once marked, then waits for interval before the next call,
+// until ctx is done.
+func (c *coalescer) run(ctx context.Context, interval time.Duration, fn func()) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.dirty:
+		}
+		fn()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// coalesce returns emit unchanged without emit coalescing, else a function
+// marking a coalescer running emit until ctx is done.
+func (s *Snapshotter) coalesce(ctx context.Context, emit func()) func() {
+	if s.emitInterval == 0 {
+		return emit
+	}
+	c := newCoalescer()
+	go c.run(ctx, s.emitInterval, emit)
+	return c.mark
+}
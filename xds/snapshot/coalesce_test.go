@@ -0,0 +1,46 @@
+package snapshot
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCoalescer(t *testing.T) {
+	const interval = 50 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := newCoalescer()
+	calls := make(chan time.Time, 10)
+	for i := 0; i < 100; i++ {
+		c.mark()
+	}
+	go c.run(ctx, interval, func() { calls <- time.Now() })
+
+	first := <-calls
+	for i := 0; i < 100; i++ {
+		c.mark()
+	}
+	second := <-calls
+	if elapsed := second.Sub(first); elapsed < interval {
+		t.Errorf("expected the rebuilds an interval apart, got %s", elapsed)
+	}
+
+	select {
+	case <-calls:
+		t.Error("expected one rebuild per burst")
+	case <-time.After(3 * interval):
+	}
+}
+
+func TestCoalesceDisabled(t *testing.T) {
+	var calls atomic.Int32
+	emit := newTestSnapshotter(WithEmitCoalescing(-time.Second)).coalesce(context.Background(), func() { calls.Add(1) })
+	emit()
+	emit()
+	if got := calls.Load(); got != 2 {
+		t.Errorf("expected synchronous emits, got %d calls", got)
+	}
+}
@@ -0,0 +1,73 @@
+package snapshot
+
+import (
+	"log/slog"
+	"sort"
+
+	"github.com/nebucloud/pkg/logger"
+)
+
+// logConfig logs the effective configuration of the Snapshotter, once.
+func (s *Snapshotter) logConfig() {
+	s.configLogOnce.Do(func() {
+		s.logger.InfoS("Snapshotter configuration", s.configAttrs()...)
+	})
+}
+
+// configAttrs returns the effective configuration of the Snapshotter as
+// attributes, secrets replaced by logger.RedactedValue.
+func (s *Snapshotter) configAttrs() []any {
+	nodeHash := "none"
+	if s.versionGating {
+		nodeHash = "envoy_version"
+	}
+	attrs := []any{
+		slog.Duration("resync_period", s.ResyncPeriod),
+		// every watch lists all namespaces
+		slog.String("namespaces", "all"),
+		slog.Any("integrations", []string{"memdb", "edgedb", "consul"}),
+		slog.String("node_hash", nodeHash),
+		slog.Bool("api_gateway", s.apiGateway),
+		slog.Bool("standby", s.Standby()),
+		slog.Bool("dependency_readiness", s.dependencyReadiness),
+		slog.Bool("persistence_compression", s.persistenceCompression),
+	}
+	if s.egressListeners {
+		attrs = append(attrs, slog.Any("egress_base_port", s.egressBasePort))
+	}
+	if s.runtimeConfigMap != nil {
+		attrs = append(attrs, slog.String("runtime_config_map", s.runtimeConfigMap.String()))
+	}
+	if len(s.resourceLimits) > 0 {
+		typeURLs := make([]string, 0, len(s.resourceLimits))
+		for typeURL := range s.resourceLimits {
+			typeURLs = append(typeURLs, typeURL)
+		}
+		sort.Strings(typeURLs)
+		limits := make([]any, 0, len(typeURLs))
+		for _, typeURL := range typeURLs {
+			limits = append(limits, slog.Int(typeURL, s.resourceLimits[typeURL]))
+		}
+		attrs = append(attrs, slog.Group("resource_limits", limits...))
+	}
+
+	options := s.edgedbClientOptions()
+	edgedbAttrs := []any{
+		slog.String("host", options.Host),
+		slog.Int("port", options.Port),
+		slog.String("database", options.Database),
+		slog.String("user", options.User),
+		slog.String("tls_security", string(options.TLSOptions.SecurityMode)),
+		slog.String("tls_ca_file", options.TLSOptions.CAFile),
+	}
+	if _, ok := options.Password.Get(); ok {
+		edgedbAttrs = append(edgedbAttrs, slog.String("password", logger.RedactedValue))
+	}
+	if options.SecretKey != "" {
+		edgedbAttrs = append(edgedbAttrs, slog.String("secret_key", logger.RedactedValue))
+	}
+	if len(options.Credentials) > 0 {
+		edgedbAttrs = append(edgedbAttrs, slog.String("credentials", logger.RedactedValue))
+	}
+	return append(attrs, slog.Group("edgedb", edgedbAttrs...))
+}
@@ -0,0 +1,101 @@
+package snapshot
+
+import (
+	"fmt"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"google.golang.org/protobuf/proto"
+)
+
+// WithEndpointDraining returns an option to keep the endpoints removed from a
+// cluster in its assignment as DRAINING for grace, Envoy sending them no new
+// requests while the in-flight ones complete, before removing them. A grace
+// of zero disables draining, a negative one is logged and ignored.
+func WithEndpointDraining(grace time.Duration) Option {
+	return func(s *Snapshotter) {
+		if grace < 0 {
+			s.logger.Errorf("invalid endpoint drain grace period %s: expect zero or more", grace)
+			return
+		}
+		s.drainGrace = grace
+	}
+}
+
+// drainRemovedEndpoints adds to the cluster load assignments of resources the
+// endpoints of their previously published version they no longer have, as
+// DRAINING until the grace period since their removal is over. It returns the
+// earliest time a draining endpoint is due for removal, zero when none is.
+func (s *Snapshotter) drainRemovedEndpoints(resources []types.Resource) ([]types.Resource, time.Time) {
+	if s.drainGrace == 0 {
+		return resources, time.Time{}
+	}
+	previous := map[string]*endpointv3.ClusterLoadAssignment{}
+	for _, r := range s.getEndpointResourcesByType()[resource.EndpointType] {
+		if cla, ok := r.(*endpointv3.ClusterLoadAssignment); ok {
+			previous[cla.GetClusterName()] = cla
+		}
+	}
+
+	now := s.now()
+	since := map[string]time.Time{}
+	var next time.Time
+	out := make([]types.Resource, 0, len(resources))
+	for _, r := range resources {
+		cla, ok := r.(*endpointv3.ClusterLoadAssignment)
+		if !ok || previous[cla.GetClusterName()] == nil {
+			out = append(out, r)
+			continue
+		}
+		current := map[string]bool{}
+		for _, locality := range cla.GetEndpoints() {
+			for _, lb := range locality.GetLbEndpoints() {
+				current[endpointAddress(lb)] = true
+			}
+		}
+		var draining []*endpointv3.LbEndpoint
+		for _, locality := range previous[cla.GetClusterName()].GetEndpoints() {
+			for _, lb := range locality.GetLbEndpoints() {
+				address := endpointAddress(lb)
+				if current[address] {
+					continue
+				}
+				key := cla.GetClusterName() + "/" + address
+				removed, ok := s.drainingSince[key]
+				if !ok {
+					removed = now
+				}
+				due := removed.Add(s.drainGrace)
+				if !now.Before(due) {
+					continue
+				}
+				since[key] = removed
+				if next.IsZero() || due.Before(next) {
+					next = due
+				}
+				lb = proto.Clone(lb).(*endpointv3.LbEndpoint)
+				lb.HealthStatus = corev3.HealthStatus_DRAINING
+				draining = append(draining, lb)
+			}
+		}
+		if len(draining) > 0 {
+			cla = proto.Clone(cla).(*endpointv3.ClusterLoadAssignment)
+			if len(cla.Endpoints) == 0 {
+				cla.Endpoints = []*endpointv3.LocalityLbEndpoints{{}}
+			}
+			cla.Endpoints[0].LbEndpoints = append(cla.Endpoints[0].LbEndpoints, draining...)
+		}
+		out = append(out, cla)
+	}
+	s.drainingSince = since
+	return out, next
+}
+
+// endpointAddress returns the address:port identifying lb in its cluster.
+func endpointAddress(lb *endpointv3.LbEndpoint) string {
+	address := lb.GetEndpoint().GetAddress().GetSocketAddress()
+	return fmt.Sprintf("%s:%d", address.GetAddress(), address.GetPortValue())
+}
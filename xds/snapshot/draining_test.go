@@ -0,0 +1,91 @@
+package snapshot
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+)
+
+// addressAssignment returns the load assignment of web.default:http with an
+// endpoint on port 8080 of each of ips.
+func addressAssignment(ips ...string) *endpointv3.ClusterLoadAssignment {
+	locality := &endpointv3.LocalityLbEndpoints{}
+	for _, ip := range ips {
+		locality.LbEndpoints = append(locality.LbEndpoints, &endpointv3.LbEndpoint{
+			HostIdentifier: &endpointv3.LbEndpoint_Endpoint{Endpoint: &endpointv3.Endpoint{
+				Address: &corev3.Address{Address: &corev3.Address_SocketAddress{SocketAddress: &corev3.SocketAddress{
+					Address:       ip,
+					PortSpecifier: &corev3.SocketAddress_PortValue{PortValue: 8080},
+				}}},
+			}},
+		})
+	}
+	return &endpointv3.ClusterLoadAssignment{ClusterName: "web.default:http", Endpoints: []*endpointv3.LocalityLbEndpoints{locality}}
+}
+
+// endpointStates returns the address:port=health of the endpoints of resources.
+func endpointStates(resources []types.Resource) []string {
+	var out []string
+	for _, r := range resources {
+		for _, locality := range r.(*endpointv3.ClusterLoadAssignment).GetEndpoints() {
+			for _, lb := range locality.GetLbEndpoints() {
+				out = append(out, endpointAddress(lb)+"="+lb.GetHealthStatus().String())
+			}
+		}
+	}
+	return out
+}
+
+func TestDrainRemovedEndpoints(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	s := newTestSnapshotter(WithEndpointDraining(30*time.Second), WithClock(func() time.Time { return now }))
+	publish := func(resources []types.Resource) {
+		s.setEndpointResourcesByType(map[string][]types.Resource{resource.EndpointType: resources})
+	}
+	publish([]types.Resource{addressAssignment("10.0.0.1", "10.0.0.2", "10.0.0.3")})
+
+	steps := []struct {
+		name    string
+		after   time.Duration
+		current []string
+		want    []string
+		wantDue time.Duration
+	}{
+		{"removed drains", 0, []string{"10.0.0.1", "10.0.0.3"}, []string{"10.0.0.1:8080=UNKNOWN", "10.0.0.3:8080=UNKNOWN", "10.0.0.2:8080=DRAINING"}, 30 * time.Second},
+		{"still draining", 10 * time.Second, []string{"10.0.0.1"}, []string{"10.0.0.1:8080=UNKNOWN", "10.0.0.3:8080=DRAINING", "10.0.0.2:8080=DRAINING"}, 30 * time.Second},
+		{"grace over", 30 * time.Second, []string{"10.0.0.1"}, []string{"10.0.0.1:8080=UNKNOWN", "10.0.0.3:8080=DRAINING"}, 40 * time.Second},
+		{"returned", 35 * time.Second, []string{"10.0.0.1", "10.0.0.3"}, []string{"10.0.0.1:8080=UNKNOWN", "10.0.0.3:8080=UNKNOWN"}, 0},
+	}
+	for _, step := range steps {
+		now = start.Add(step.after)
+		out, due := s.drainRemovedEndpoints([]types.Resource{addressAssignment(step.current...)})
+		if got := endpointStates(out); !slices.Equal(got, step.want) {
+			t.Errorf("%s: expected endpoints %v, got %v", step.name, step.want, got)
+		}
+		wantDue := time.Time{}
+		if step.wantDue != 0 {
+			wantDue = start.Add(step.wantDue)
+		}
+		if !due.Equal(wantDue) {
+			t.Errorf("%s: expected the next removal at %s, got %s", step.name, wantDue, due)
+		}
+		publish(out)
+	}
+}
+
+func TestDrainRemovedEndpointsDisabled(t *testing.T) {
+	for _, grace := range []time.Duration{0, -time.Second} {
+		s := newTestSnapshotter(WithEndpointDraining(grace))
+		s.setEndpointResourcesByType(map[string][]types.Resource{resource.EndpointType: {addressAssignment("10.0.0.1", "10.0.0.2")}})
+		out, due := s.drainRemovedEndpoints([]types.Resource{addressAssignment("10.0.0.1")})
+		if got := endpointStates(out); !slices.Equal(got, []string{"10.0.0.1:8080=UNKNOWN"}) || !due.IsZero() {
+			t.Errorf("grace %s: expected the removed endpoint dropped, got %v due %s", grace, got, due)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package snapshot
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/edgedb/edgedb-go"
+)
+
+// EdgeDBTLS is the TLS configuration of the EdgeDB client
+type EdgeDBTLS struct {
+	// CAFile is the path of the PEM-encoded CA certificate verifying the server
+	CAFile string
+	// ServerName overrides the host name verified on the server certificate
+	ServerName string
+	// Security is how strictly the server certificate is verified, inferred
+	// from the other fields when empty. Insecure is only set by WithEdgeDBInsecure.
+	Security edgedb.TLSSecurityMode
+}
+
+func (t EdgeDBTLS) validate() error {
+	switch t.Security {
+	case "", edgedb.TLSModeDefault, edgedb.TLSModeNoHostVerification, edgedb.TLSModeStrict:
+	case edgedb.TLSModeInsecure:
+		return fmt.Errorf("insecure TLS must be enabled by WithEdgeDBInsecure")
+	default:
+		return fmt.Errorf("unknown TLS security mode %q", t.Security)
+	}
+	if t.CAFile != "" {
+		if _, err := os.Stat(t.CAFile); err != nil {
+			return fmt.Errorf("CA file: %w", err)
+		}
+	}
+	return nil
+}
+
+// WithEdgeDBTLS returns an option to verify the EdgeDB server with tls,
+// overriding the TLS options set by WithEdgeDBOptions. An invalid tls is
+// logged and ignored.
+func WithEdgeDBTLS(tls EdgeDBTLS) Option {
+	return func(s *Snapshotter) {
+		if err := tls.validate(); err != nil {
+			s.logger.Errorf("invalid EdgeDB TLS: %s", err)
+			return
+		}
+		security := tls.Security
+		if security == "" {
+			security = edgedb.TLSModeDefault
+		}
+		s.edgedbTLS = &edgedb.TLSOptions{CAFile: tls.CAFile, ServerName: tls.ServerName, SecurityMode: security}
+	}
+}
+
+// WithEdgeDBInsecure returns an option to connect to EdgeDB without verifying
+// its certificate, for development servers with a self-signed one.
+func WithEdgeDBInsecure() Option {
+	return func(s *Snapshotter) {
+		s.edgedbTLS = &edgedb.TLSOptions{SecurityMode: edgedb.TLSModeInsecure}
+	}
+}
+
+// edgedbClientOptions returns the options the EdgeDB client is created with.
+func (s *Snapshotter) edgedbClientOptions() edgedb.Options {
+	options := s.edgedbOptions
+	if s.edgedbTLS != nil {
+		options.TLSOptions = *s.edgedbTLS
+	}
+	return options
+}
@@ -0,0 +1,50 @@
+package snapshot
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/edgedb/edgedb-go"
+)
+
+func TestEdgeDBClientOptionsTLS(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, []byte("-----BEGIN CERTIFICATE-----"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	custom := DefaultEdgeDBOptions()
+	custom.Concurrency = 8
+	custom.TLSOptions = edgedb.TLSOptions{SecurityMode: edgedb.TLSModeStrict}
+
+	tests := []struct {
+		name string
+		opts []Option
+		want edgedb.TLSOptions
+	}{
+		{"default", nil, edgedb.TLSOptions{}},
+		{"from edgedb options", []Option{WithEdgeDBOptions(custom)}, edgedb.TLSOptions{SecurityMode: edgedb.TLSModeStrict}},
+		{"strict with CA", []Option{WithEdgeDBTLS(EdgeDBTLS{CAFile: caFile, ServerName: "edgedb.internal", Security: edgedb.TLSModeStrict})},
+			edgedb.TLSOptions{CAFile: caFile, ServerName: "edgedb.internal", SecurityMode: edgedb.TLSModeStrict}},
+		{"inferred security", []Option{WithEdgeDBTLS(EdgeDBTLS{CAFile: caFile})},
+			edgedb.TLSOptions{CAFile: caFile, SecurityMode: edgedb.TLSModeDefault}},
+		{"overrides edgedb options", []Option{WithEdgeDBTLS(EdgeDBTLS{Security: edgedb.TLSModeNoHostVerification}), WithEdgeDBOptions(custom)},
+			edgedb.TLSOptions{SecurityMode: edgedb.TLSModeNoHostVerification}},
+		{"explicit insecure", []Option{WithEdgeDBInsecure()}, edgedb.TLSOptions{SecurityMode: edgedb.TLSModeInsecure}},
+		{"accidental insecure", []Option{WithEdgeDBTLS(EdgeDBTLS{Security: edgedb.TLSModeInsecure})}, edgedb.TLSOptions{}},
+		{"unknown security", []Option{WithEdgeDBTLS(EdgeDBTLS{Security: "lenient"})}, edgedb.TLSOptions{}},
+		{"missing CA", []Option{WithEdgeDBTLS(EdgeDBTLS{CAFile: filepath.Join(t.TempDir(), "missing.pem")})}, edgedb.TLSOptions{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := newTestSnapshotter(tt.opts...).edgedbClientOptions()
+			if !reflect.DeepEqual(options.TLSOptions, tt.want) {
+				t.Errorf("expected TLS options %+v, got %+v", tt.want, options.TLSOptions)
+			}
+			if options.ConnectTimeout != DefaultEdgeDBOptions().ConnectTimeout {
+				t.Errorf("expected the other options kept, got %+v", options)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package snapshot
+
+import (
+	"sync/atomic"
+
+	k8scache "k8s.io/client-go/tools/cache"
+)
+
+// newEmitStore returns the reflector store calling emit on every change. A
+// reflector delivers its initial list as a single Replace, emitting once.
+func (s *Snapshotter) newEmitStore(emit func()) k8scache.Store {
+	return k8scache.NewUndeltaStore(func([]interface{}) {
+		emit()
+	}, k8scache.DeletionHandlingMetaNamespaceKeyFunc)
+}
+
+// emitter calls its emit function once activated and drops earlier calls,
+// so a push from the store before the reflector is wired is a no-op.
+type emitter struct {
+	fn atomic.Pointer[func()]
+}
+
+func (e *emitter) activate(fn func()) {
+	e.fn.Store(&fn)
+}
+
+func (e *emitter) emit() {
+	if fn := e.fn.Load(); fn != nil {
+		(*fn)()
+	}
+}
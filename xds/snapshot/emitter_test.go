@@ -0,0 +1,70 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+
+	"github.com/nebucloud/pkg/logger"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	k8scache "k8s.io/client-go/tools/cache"
+)
+
+func TestEmitStoreInitialList(t *testing.T) {
+	list := &corev1.ServiceList{ListMeta: metav1.ListMeta{ResourceVersion: "1"}}
+	for i := 0; i < 50; i++ {
+		list.Items = append(list.Items, corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("svc-%d", i), Namespace: "default"}})
+	}
+	watcher := watch.NewFake()
+	var emits atomic.Int32
+	store := newTestSnapshotter().newEmitStore(func() { emits.Add(1) })
+	reflector := k8scache.NewReflector(&k8scache.ListWatch{
+		ListFunc: func(metav1.ListOptions) (runtime.Object, error) { return list, nil },
+		WatchFunc: func(metav1.ListOptions) (watch.Interface, error) {
+			return watcher, nil
+		},
+	}, &corev1.Service{}, store, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go reflector.Run(ctx.Done())
+
+	waitFor(t, "the initial list", func() bool { return reflector.LastSyncResourceVersion() == "1" })
+	if got := emits.Load(); got != 1 {
+		t.Errorf("expected a single emit for the initial list, got %d", got)
+	}
+	if got := len(store.List()); got != len(list.Items) {
+		t.Errorf("expected %d services in the store, got %d", len(list.Items), got)
+	}
+
+	watcher.Add(&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "extra", Namespace: "default", ResourceVersion: "2"}})
+	waitFor(t, "the watched service", func() bool { return emits.Load() == 2 })
+}
+
+func TestEmitterBeforeActivation(t *testing.T) {
+	log, observer := logger.NewObserver()
+	s := newSnapshotter(nil, log)
+
+	emits := &emitter{}
+	store := s.newEmitStore(emits.emit)
+	early := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "early", Namespace: "default"}}
+	if err := store.Add(early); err != nil {
+		t.Fatal(err)
+	}
+
+	emitted := 0
+	emits.activate(func() { emitted++ })
+	if err := store.Replace([]interface{}{early}, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if emitted != 1 {
+		t.Errorf("expected only the push after activation to emit, got %d", emitted)
+	}
+	if warnings := observer.FilterByLevel(slog.LevelWarn); len(warnings) != 0 {
+		t.Errorf("expected no warning for a push before activation, got %v", warnings)
+	}
+}
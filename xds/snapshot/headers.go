@@ -0,0 +1,92 @@
+package snapshot
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	"github.com/nebucloud/pkg/xds/snapshot/annotations"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Service annotations manipulating the headers of its routes. Headers to add
+// are comma separated name=value pairs, e.g. x-team=payments,x-tier=gold,
+// headers to remove comma separated names.
+const (
+	RequestHeadersToAddAnnotation     = "xds.nebucloud.com/request-headers-to-add"
+	RequestHeadersToRemoveAnnotation  = "xds.nebucloud.com/request-headers-to-remove"
+	ResponseHeadersToAddAnnotation    = "xds.nebucloud.com/response-headers-to-add"
+	ResponseHeadersToRemoveAnnotation = "xds.nebucloud.com/response-headers-to-remove"
+)
+
+var (
+	// headerNamePattern matches the token an HTTP header name is made of
+	headerNamePattern = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+	// headerValuePattern matches a header name=value pair
+	headerValuePattern = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+=[^\\x00-\\x08\\x0a-\\x1f\\x7f]*$")
+)
+
+// headerActions is the header manipulation of the routes of a service
+type headerActions struct {
+	requestToAdd     []*corev3.HeaderValueOption
+	requestToRemove  []string
+	responseToAdd    []*corev3.HeaderValueOption
+	responseToRemove []string
+}
+
+// apply sets the header actions on route.
+func (h headerActions) apply(route *routev3.Route) {
+	route.RequestHeadersToAdd = h.requestToAdd
+	route.RequestHeadersToRemove = h.requestToRemove
+	route.ResponseHeadersToAdd = h.responseToAdd
+	route.ResponseHeadersToRemove = h.responseToRemove
+}
+
+// serviceHeaderActions returns the header actions annotated on svc. Invalid
+// annotations are left out and their errors joined.
+func serviceHeaderActions(svc *corev1.Service) (headerActions, error) {
+	var (
+		h    headerActions
+		errs []error
+	)
+	add := func(key string) []*corev3.HeaderValueOption {
+		headers, err := headersToAdd(svc.Annotations, key)
+		errs = append(errs, err)
+		return headers
+	}
+	remove := func(key string) []string {
+		names, err := headersToRemove(svc.Annotations, key)
+		errs = append(errs, err)
+		return names
+	}
+	h.requestToAdd = add(RequestHeadersToAddAnnotation)
+	h.requestToRemove = remove(RequestHeadersToRemoveAnnotation)
+	h.responseToAdd = add(ResponseHeadersToAddAnnotation)
+	h.responseToRemove = remove(ResponseHeadersToRemoveAnnotation)
+	return h, errors.Join(errs...)
+}
+
+// headersToAdd returns the headers the annotation key of values adds, appended
+// to the existing values of the same name.
+func headersToAdd(values map[string]string, key string) ([]*corev3.HeaderValueOption, error) {
+	pairs, err := annotations.GetStringList(values, key, headerValuePattern)
+	if err != nil {
+		return nil, err
+	}
+	var out []*corev3.HeaderValueOption
+	for _, pair := range pairs {
+		name, value, _ := strings.Cut(pair, "=")
+		out = append(out, &corev3.HeaderValueOption{
+			Header:       &corev3.HeaderValue{Key: name, Value: value},
+			AppendAction: corev3.HeaderValueOption_APPEND_IF_EXISTS_OR_ADD,
+		})
+	}
+	return out, nil
+}
+
+// headersToRemove returns the header names the annotation key of values removes.
+func headersToRemove(values map[string]string, key string) ([]string, error) {
+	return annotations.GetStringList(values, key, headerNamePattern)
+}
@@ -0,0 +1,122 @@
+package snapshot
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"slices"
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	managerv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/nebucloud/pkg/logger"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// defaultRoutes returns the default route of the route configuration name,
+// and its copy inlined in the listener.
+func defaultRoutes(t *testing.T, resources []types.Resource, name string) []*routev3.Route {
+	t.Helper()
+	routeConfig, ok := resourcesByName(t, resources)[resource.RouteType+"/"+name].(*routev3.RouteConfiguration)
+	if !ok {
+		t.Fatalf("route configuration %s missing", name)
+	}
+	l := findListener(resources, name)
+	if l == nil {
+		t.Fatalf("listener %s missing", name)
+	}
+	manager := &managerv3.HttpConnectionManager{}
+	if err := l.GetApiListener().GetApiListener().UnmarshalTo(manager); err != nil {
+		t.Fatal(err)
+	}
+	return []*routev3.Route{
+		routeConfig.GetVirtualHosts()[0].GetRoutes()[0],
+		manager.GetRouteConfig().GetVirtualHosts()[0].GetRoutes()[0],
+	}
+}
+
+// headerPairs returns the name=value pairs of headers.
+func headerPairs(headers []*corev3.HeaderValueOption) []string {
+	var out []string
+	for _, h := range headers {
+		if h.AppendAction != corev3.HeaderValueOption_APPEND_IF_EXISTS_OR_ADD {
+			out = append(out, "unexpected append action "+h.AppendAction.String())
+		}
+		out = append(out, h.GetHeader().GetKey()+"="+h.GetHeader().GetValue())
+	}
+	return out
+}
+
+func TestServicesToResourcesHeaders(t *testing.T) {
+	tests := []struct {
+		name               string
+		annotations        map[string]string
+		wantRequestAdd     []string
+		wantRequestRemove  []string
+		wantResponseAdd    []string
+		wantResponseRemove []string
+	}{
+		{"absent", nil, nil, nil, nil, nil},
+		{"all", map[string]string{
+			RequestHeadersToAddAnnotation:     "x-team=payments, x-trace-sampled=1",
+			RequestHeadersToRemoveAnnotation:  "x-internal",
+			ResponseHeadersToAddAnnotation:    "cache-control=no-store, x-frame-options=DENY",
+			ResponseHeadersToRemoveAnnotation: "server,x-powered-by",
+		}, []string{"x-team=payments", "x-trace-sampled=1"}, []string{"x-internal"}, []string{"cache-control=no-store", "x-frame-options=DENY"}, []string{"server", "x-powered-by"}},
+		{"empty value", map[string]string{RequestHeadersToAddAnnotation: "x-flag="}, []string{"x-flag="}, nil, nil, nil},
+		{"invalid ones left out", map[string]string{
+			RequestHeadersToAddAnnotation:     "x-team payments",
+			RequestHeadersToRemoveAnnotation:  ":authority",
+			ResponseHeadersToRemoveAnnotation: "server",
+		}, nil, nil, nil, []string{"server"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			services := []*corev1.Service{{
+				ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Annotations: tt.annotations},
+				Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "http", Port: 80}}},
+			}}
+			resources, _ := newTestSnapshotter().servicesToResources(services)
+			for _, route := range defaultRoutes(t, resources, "web.default:80") {
+				if got := headerPairs(route.RequestHeadersToAdd); !slices.Equal(got, tt.wantRequestAdd) {
+					t.Errorf("expected request headers to add %v, got %v", tt.wantRequestAdd, got)
+				}
+				if !slices.Equal(route.RequestHeadersToRemove, tt.wantRequestRemove) {
+					t.Errorf("expected request headers to remove %v, got %v", tt.wantRequestRemove, route.RequestHeadersToRemove)
+				}
+				if got := headerPairs(route.ResponseHeadersToAdd); !slices.Equal(got, tt.wantResponseAdd) {
+					t.Errorf("expected response headers to add %v, got %v", tt.wantResponseAdd, got)
+				}
+				if !slices.Equal(route.ResponseHeadersToRemove, tt.wantResponseRemove) {
+					t.Errorf("expected response headers to remove %v, got %v", tt.wantResponseRemove, route.ResponseHeadersToRemove)
+				}
+			}
+		})
+	}
+}
+
+func TestAuditHeaders(t *testing.T) {
+	handler := logger.NewMemoryHandler()
+	log := &logger.Klogger{}
+	log.SetLogger(slog.New(handler))
+	svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Annotations: map[string]string{
+		RequestHeadersToAddAnnotation:     "x-team=payments",
+		ResponseHeadersToRemoveAnnotation: "server,",
+	}}}
+	newTestSnapshotter().auditAnnotations(context.Background(), log, []*corev1.Service{svc})
+
+	r, ok := handler.Last()
+	if !ok {
+		t.Fatal("expected an audit entry")
+	}
+	if got := r.Attrs["parsed."+RequestHeadersToAddAnnotation]; !reflect.DeepEqual(got, []string{"x-team=payments"}) {
+		t.Errorf("expected the request headers parsed, got %v", got)
+	}
+	if _, ok := r.Attrs["ignored."+ResponseHeadersToRemoveAnnotation]; !ok {
+		t.Errorf("expected the invalid response headers ignored, got %v", r.Attrs)
+	}
+}
@@ -0,0 +1,133 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	k8scache "k8s.io/client-go/tools/cache"
+)
+
+// healthTimeout bounds the dependency checks of a health request
+const healthTimeout = 5 * time.Second
+
+// Health is the state of the Snapshotter reported by HealthHandler
+type Health struct {
+	// Healthy is set when every reflector synced and every dependency is reachable
+	Healthy      bool                        `json:"healthy"`
+	Reflectors   map[string]ReflectorHealth  `json:"reflectors"`
+	Caches       map[string]CacheHealth      `json:"caches"`
+	Resources    map[string]int              `json:"resources"`
+	Dependencies map[string]DependencyHealth `json:"dependencies"`
+}
+
+// ReflectorHealth is the sync state of a reflector
+type ReflectorHealth struct {
+	Synced          bool   `json:"synced"`
+	ResourceVersion string `json:"resource_version,omitempty"`
+}
+
+// CacheHealth is the last snapshot emitted to a cache
+type CacheHealth struct {
+	Version  string    `json:"version"`
+	LastEmit time.Time `json:"last_emit"`
+}
+
+// DependencyHealth is the reachability of a dependency
+type DependencyHealth struct {
+	Reachable bool   `json:"reachable"`
+	Error     string `json:"error,omitempty"`
+}
+
+// trackReflector reports the sync state of the reflector of loop in Health.
+func (s *Snapshotter) trackReflector(loop string, reflector *k8scache.Reflector) {
+	s.healthLock.Lock()
+	defer s.healthLock.Unlock()
+	if s.reflectors == nil {
+		s.reflectors = make(map[string]*k8scache.Reflector)
+	}
+	s.reflectors[loop] = reflector
+}
+
+// trackEmit records version as the last snapshot emitted to cacheName.
+func (s *Snapshotter) trackEmit(cacheName, version string) {
+	s.healthLock.Lock()
+	defer s.healthLock.Unlock()
+	if s.emits == nil {
+		s.emits = make(map[string]CacheHealth)
+	}
+	s.emits[cacheName] = CacheHealth{Version: version, LastEmit: s.now()}
+}
+
+// Health returns the sync state of the reflectors, the last emit of each
+// cache, the resource count per type URL and the reachability of the
+// dependencies.
+func (s *Snapshotter) Health(ctx context.Context) Health {
+	h := Health{
+		Healthy:      true,
+		Reflectors:   map[string]ReflectorHealth{},
+		Caches:       map[string]CacheHealth{},
+		Resources:    map[string]int{},
+		Dependencies: map[string]DependencyHealth{},
+	}
+
+	s.healthLock.Lock()
+	for loop, reflector := range s.reflectors {
+		version := reflector.LastSyncResourceVersion()
+		h.Reflectors[loop] = ReflectorHealth{Synced: version != "", ResourceVersion: version}
+		h.Healthy = h.Healthy && version != ""
+	}
+	for cacheName, emit := range s.emits {
+		h.Caches[cacheName] = emit
+	}
+	s.healthLock.Unlock()
+	if len(h.Reflectors) == 0 {
+		h.Healthy = false
+	}
+
+	for _, byType := range []map[string][]types.Resource{s.getServiceResourcesByType(), s.getEndpointResourcesByType()} {
+		for typeURL, resources := range byType {
+			h.Resources[typeURL] += len(resources)
+		}
+	}
+
+	s.readinessLock.RLock()
+	checks := s.dependencyChecks
+	pending := s.dependencyReadiness && !s.dependenciesAdded
+	s.readinessLock.RUnlock()
+	if pending {
+		h.Healthy = false
+	}
+	for _, check := range checks {
+		dependency := DependencyHealth{Reachable: true}
+		if err := check.ping(ctx); err != nil {
+			dependency = DependencyHealth{Error: err.Error()}
+			h.Healthy = false
+		}
+		h.Dependencies[check.name] = dependency
+	}
+	return h
+}
+
+// HealthHandler returns an http.Handler reporting Health as JSON, with the
+// status 503 Service Unavailable unless healthy.
+func (s *Snapshotter) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), healthTimeout)
+		defer cancel()
+		h := s.Health(ctx)
+
+		w.Header().Set("Content-Type", "application/json")
+		if !h.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(h)
+	})
+}
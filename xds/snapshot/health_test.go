@@ -0,0 +1,117 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	k8scache "k8s.io/client-go/tools/cache"
+)
+
+// getHealth serves a health request and decodes the response.
+func getHealth(t *testing.T, s *Snapshotter) (int, Health) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.HealthHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	var h Health
+	if err := json.Unmarshal(rec.Body.Bytes(), &h); err != nil {
+		t.Fatalf("expected a JSON body, got %q: %v", rec.Body.String(), err)
+	}
+	return rec.Code, h
+}
+
+// syncedReflector returns a reflector that listed the services at version.
+func syncedReflector(t *testing.T, version string) *k8scache.Reflector {
+	t.Helper()
+	reflector := k8scache.NewReflector(&k8scache.ListWatch{
+		ListFunc: func(metav1.ListOptions) (runtime.Object, error) {
+			return &corev1.ServiceList{ListMeta: metav1.ListMeta{ResourceVersion: version}}, nil
+		},
+		WatchFunc: func(metav1.ListOptions) (watch.Interface, error) {
+			return watch.NewFake(), nil
+		},
+	}, &corev1.Service{}, k8scache.NewStore(k8scache.MetaNamespaceKeyFunc), 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go reflector.Run(ctx.Done())
+	deadline := time.Now().Add(5 * time.Second)
+	for reflector.LastSyncResourceVersion() == "" {
+		if time.Now().After(deadline) {
+			t.Fatal("reflector did not sync")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return reflector
+}
+
+func TestHealthHandlerUnpopulated(t *testing.T) {
+	s := newTestSnapshotter(WithDependencyReadiness())
+	s.trackReflector("endpoints", k8scache.NewReflector(&k8scache.ListWatch{}, &corev1.Endpoints{}, k8scache.NewStore(k8scache.MetaNamespaceKeyFunc), 0))
+
+	code, h := getHealth(t, s)
+	if code != http.StatusServiceUnavailable || h.Healthy {
+		t.Errorf("expected unhealthy before any sync, got %d %+v", code, h)
+	}
+	if r, ok := h.Reflectors["endpoints"]; !ok || r.Synced {
+		t.Errorf("expected the endpoints reflector reported unsynced, got %+v", h.Reflectors)
+	}
+	if len(h.Caches) != 0 || len(h.Resources) != 0 || len(h.Dependencies) != 0 {
+		t.Errorf("expected no emit, resource or dependency yet, got %+v", h)
+	}
+}
+
+func TestHealthHandlerPopulated(t *testing.T) {
+	edgedb, consul := &fakeDependency{}, &fakeDependency{}
+	edgedb.healthy.Store(true)
+	s := newTestSnapshotter(
+		WithDependencyCheck("edgedb", edgedb.ping),
+		WithDependencyCheck("consul", consul.ping),
+	)
+	s.trackReflector("services", syncedReflector(t, "42"))
+
+	services := []*corev1.Service{{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "http", Port: 80}}},
+	}}
+	resources, _ := s.servicesToResources(services)
+	resourcesByType := resourcesToMap(resources)
+	s.setServiceResourcesByType(resourcesByType)
+	before := time.Now()
+	s.publish(context.Background(), "services", "42", resourcesByType)
+
+	code, h := getHealth(t, s)
+	if code != http.StatusServiceUnavailable || h.Healthy {
+		t.Errorf("expected unhealthy while consul is down, got %d", code)
+	}
+	if r := h.Reflectors["services"]; !r.Synced || r.ResourceVersion != "42" {
+		t.Errorf("expected the services reflector synced at 42, got %+v", r)
+	}
+	if c := h.Caches["services"]; c.Version != "42" || c.LastEmit.Before(before) {
+		t.Errorf("expected the services emit of version 42, got %+v", c)
+	}
+	for _, typeURL := range []string{resource.ListenerType, resource.RouteType, resource.ClusterType} {
+		if h.Resources[typeURL] != 1 {
+			t.Errorf("%s: expected 1 resource, got %d", typeURL, h.Resources[typeURL])
+		}
+	}
+	if d := h.Dependencies["edgedb"]; !d.Reachable || d.Error != "" {
+		t.Errorf("expected edgedb reachable, got %+v", d)
+	}
+	if d := h.Dependencies["consul"]; d.Reachable || d.Error != "unreachable" {
+		t.Errorf("expected consul unreachable, got %+v", d)
+	}
+
+	consul.healthy.Store(true)
+	if code, h := getHealth(t, s); code != http.StatusOK || !h.Healthy {
+		t.Errorf("expected healthy once every dependency is reachable, got %d %+v", code, h)
+	}
+}
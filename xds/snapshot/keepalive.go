@@ -0,0 +1,155 @@
+package snapshot
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"time"
+
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/nebucloud/pkg/xds/snapshot/annotations"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Service annotations overriding the TCP keepalive of its clusters
+const (
+	TCPKeepaliveProbesAnnotation   = "xds.nebucloud.com/tcp-keepalive-probes"
+	TCPKeepaliveTimeAnnotation     = "xds.nebucloud.com/tcp-keepalive-time"
+	TCPKeepaliveIntervalAnnotation = "xds.nebucloud.com/tcp-keepalive-interval"
+)
+
+// tcpKeepalive holds the TCP keepalive parameters of upstream connections,
+// zero values leave the system defaults
+type tcpKeepalive struct {
+	probes   int
+	time     time.Duration
+	interval time.Duration
+}
+
+func (k tcpKeepalive) validate() error {
+	if k.probes < 0 || int64(k.probes) > math.MaxUint32 {
+		return fmt.Errorf("keepalive probes %d out of range", k.probes)
+	}
+	for _, d := range []time.Duration{k.time, k.interval} {
+		if d < 0 || d%time.Second != 0 || d/time.Second > math.MaxUint32 {
+			return fmt.Errorf("keepalive duration %s must be a non-negative number of seconds", d)
+		}
+	}
+	return nil
+}
+
+func (k tcpKeepalive) toProto() *corev3.TcpKeepalive {
+	out := &corev3.TcpKeepalive{}
+	if k.probes > 0 {
+		out.KeepaliveProbes = wrapperspb.UInt32(uint32(k.probes))
+	}
+	if k.time > 0 {
+		out.KeepaliveTime = wrapperspb.UInt32(uint32(k.time / time.Second))
+	}
+	if k.interval > 0 {
+		out.KeepaliveInterval = wrapperspb.UInt32(uint32(k.interval / time.Second))
+	}
+	return out
+}
+
+// WithTCPKeepalive returns an option to enable TCP keepalive on the upstream
+// connections of every generated cluster: after idle without traffic, up to
+// probes probes are sent every interval. Zero leaves the system default, durations
+// must be whole seconds. Services override it with the TCPKeepalive*
+// annotations. Invalid parameters are logged and ignored.
+func WithTCPKeepalive(probes int, idle, interval time.Duration) Option {
+	return func(s *Snapshotter) {
+		k := tcpKeepalive{probes: probes, time: idle, interval: interval}
+		if err := k.validate(); err != nil {
+			s.logger.Errorf("invalid TCP keepalive: %s", err)
+			return
+		}
+		s.tcpKeepalive = &k
+	}
+}
+
+// serviceTCPKeepalive returns the TCP keepalive of the clusters of svc, the
+// default overridden by its annotations, or nil if disabled. Invalid
+// annotations are logged and the default is used.
+func (s *Snapshotter) serviceTCPKeepalive(svc *corev1.Service) *corev3.TcpKeepalive {
+	var def tcpKeepalive
+	if s.tcpKeepalive != nil {
+		def = *s.tcpKeepalive
+	}
+	k, annotated, err := annotatedTCPKeepalive(svc.Annotations, def)
+	if err != nil {
+		s.logger.WithObject(svc).Warnf("Invalid TCP keepalive annotations: %s", err)
+	}
+	switch {
+	case annotated && err == nil:
+		return k.toProto()
+	case s.tcpKeepalive != nil:
+		return def.toProto()
+	default:
+		return nil
+	}
+}
+
+// annotatedTCPKeepalive returns def overridden by the TCPKeepalive* annotations
+// of values, and whether any is set.
+func annotatedTCPKeepalive(values map[string]string, def tcpKeepalive) (tcpKeepalive, bool, error) {
+	annotated := false
+	for _, key := range []string{TCPKeepaliveProbesAnnotation, TCPKeepaliveTimeAnnotation, TCPKeepaliveIntervalAnnotation} {
+		if _, ok := values[key]; ok {
+			annotated = true
+		}
+	}
+	if !annotated {
+		return def, false, nil
+	}
+
+	var (
+		k   tcpKeepalive
+		err error
+	)
+	if k.probes, err = annotations.GetInt(values, TCPKeepaliveProbesAnnotation, def.probes); err != nil {
+		return def, true, err
+	}
+	if k.time, err = annotations.GetDuration(values, TCPKeepaliveTimeAnnotation, def.time); err != nil {
+		return def, true, err
+	}
+	if k.interval, err = annotations.GetDuration(values, TCPKeepaliveIntervalAnnotation, def.interval); err != nil {
+		return def, true, err
+	}
+	return k, true, k.validate()
+}
+
+// applyTCPKeepalive sets the TCP keepalive of each service on its clusters in
+// resources, keeping their other upstream connection options.
+func (s *Snapshotter) applyTCPKeepalive(services []*corev1.Service, resources []types.Resource) {
+	keepalives := map[string]*corev3.TcpKeepalive{}
+	for _, svc := range services {
+		k := s.serviceTCPKeepalive(svc)
+		if k == nil {
+			continue
+		}
+		fullName := fmt.Sprintf("%s.%s", svc.Name, svc.Namespace)
+		for _, port := range svc.Spec.Ports {
+			keepalives[net.JoinHostPort(fullName, port.Name)] = k
+		}
+	}
+	if len(keepalives) == 0 {
+		return
+	}
+	for _, r := range resources {
+		c, ok := r.(*clusterv3.Cluster)
+		if !ok {
+			continue
+		}
+		if k, ok := keepalives[c.Name]; ok {
+			if c.UpstreamConnectionOptions == nil {
+				c.UpstreamConnectionOptions = &clusterv3.UpstreamConnectionOptions{}
+			}
+			c.UpstreamConnectionOptions.TcpKeepalive = proto.Clone(k).(*corev3.TcpKeepalive)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package snapshot
+
+import (
+	"testing"
+	"time"
+
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestServicesToResourcesTCPKeepalive(t *testing.T) {
+	service := func(name string, annotations map[string]string) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Annotations: annotations},
+			Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "http", Port: 80}}},
+		}
+	}
+	services := []*corev1.Service{
+		service("plain", nil),
+		service("tuned", map[string]string{
+			TCPKeepaliveProbesAnnotation:   "5",
+			TCPKeepaliveIntervalAnnotation: "2s",
+		}),
+		service("invalid", map[string]string{TCPKeepaliveTimeAnnotation: "1500ms"}),
+	}
+	defaults := &corev3.TcpKeepalive{
+		KeepaliveProbes:   wrapperspb.UInt32(3),
+		KeepaliveTime:     wrapperspb.UInt32(30),
+		KeepaliveInterval: wrapperspb.UInt32(10),
+	}
+
+	tests := []struct {
+		name string
+		opts []Option
+		want map[string]*corev3.TcpKeepalive
+	}{
+		{"annotations only", nil, map[string]*corev3.TcpKeepalive{
+			"plain.default:http": nil,
+			"tuned.default:http": {KeepaliveProbes: wrapperspb.UInt32(5), KeepaliveInterval: wrapperspb.UInt32(2)},
+			// invalid annotations fall back to the disabled default
+			"invalid.default:http": nil,
+		}},
+		{"default", []Option{WithTCPKeepalive(3, 30*time.Second, 10*time.Second)}, map[string]*corev3.TcpKeepalive{
+			"plain.default:http":   defaults,
+			"tuned.default:http":   {KeepaliveProbes: wrapperspb.UInt32(5), KeepaliveTime: wrapperspb.UInt32(30), KeepaliveInterval: wrapperspb.UInt32(2)},
+			"invalid.default:http": defaults,
+		}},
+		{"invalid default", []Option{WithTCPKeepalive(3, 1500*time.Millisecond, 0)}, map[string]*corev3.TcpKeepalive{
+			"plain.default:http": nil,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resources, _ := newTestSnapshotter(tt.opts...).servicesToResources(services)
+			got := map[string]*corev3.TcpKeepalive{}
+			for _, c := range findClusters(resources) {
+				got[c.Name] = c.GetUpstreamConnectionOptions().GetTcpKeepalive()
+			}
+			for name, want := range tt.want {
+				if !proto.Equal(got[name], want) {
+					t.Errorf("cluster %s: expected keepalive %v, got %v", name, want, got[name])
+				}
+			}
+		})
+	}
+}
+
+func TestApplyTCPKeepaliveKeepsConnectionOptions(t *testing.T) {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "http", Port: 80}}},
+	}
+	c := &clusterv3.Cluster{
+		Name:                      "web.default:http",
+		UpstreamConnectionOptions: &clusterv3.UpstreamConnectionOptions{SetLocalInterfaceNameOnUpstreamConnections: true},
+	}
+	newTestSnapshotter(WithTCPKeepalive(3, 0, 0)).applyTCPKeepalive([]*corev1.Service{svc}, []types.Resource{c})
+
+	options := c.GetUpstreamConnectionOptions()
+	if !options.GetSetLocalInterfaceNameOnUpstreamConnections() {
+		t.Errorf("expected the existing upstream connection options kept, got %v", options)
+	}
+	if got := options.GetTcpKeepalive().GetKeepaliveProbes().GetValue(); got != 3 {
+		t.Errorf("expected 3 keepalive probes, got %d", got)
+	}
+}
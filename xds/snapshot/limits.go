@@ -0,0 +1,41 @@
+package snapshot
+
+import (
+	"context"
+
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/nebucloud/pkg/logger"
+	"github.com/nebucloud/pkg/xds/meter"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// WithResourceLimit returns an option to cap the number of resources of
+// typeURL in a snapshot. A snapshot exceeding the cap is not emitted, keeping
+// Envoy on the previous config rather than one that could exhaust its memory.
+// A max of zero or less disables the cap.
+func WithResourceLimit(typeURL string, max int) Option {
+	return func(s *Snapshotter) {
+		if s.resourceLimits == nil {
+			s.resourceLimits = make(map[string]int)
+		}
+		if max <= 0 {
+			delete(s.resourceLimits, typeURL)
+			return
+		}
+		s.resourceLimits[typeURL] = max
+	}
+}
+
+// withinResourceLimits reports whether resourcesByType respects every
+// configured cap, logging and counting each exceeded one.
+func (s *Snapshotter) withinResourceLimits(ctx context.Context, log *logger.Klogger, resourcesByType map[string][]types.Resource) bool {
+	within := true
+	for typeURL, max := range s.resourceLimits {
+		if count := len(resourcesByType[typeURL]); count > max {
+			s.emitErrorf(log, "snapshot skipped: %d resources of type %s exceed the limit of %d", count, typeURL, max)
+			s.limitExceededCounter.Add(ctx, 1, metric.WithAttributes(meter.TypeURLAttrKey.String(typeURL)))
+			within = false
+		}
+	}
+	return within
+}
@@ -0,0 +1,111 @@
+package snapshot
+
+import (
+	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	tlsinspectorv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/listener/tls_inspector/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// Transport protocols detected by Envoy a filter chain can match on
+const (
+	TransportProtocolRawBuffer = "raw_buffer"
+	TransportProtocolTLS       = "tls"
+)
+
+// WithListenerDrainType returns an option to set the drain type of every
+// generated listener. An unknown drain type is logged and ignored.
+func WithListenerDrainType(drainType listenerv3.Listener_DrainType) Option {
+	return func(s *Snapshotter) {
+		if _, ok := listenerv3.Listener_DrainType_name[int32(drainType)]; !ok {
+			s.logger.Errorf("invalid listener drain type: %d", drainType)
+			return
+		}
+		s.listenerDrainType = drainType
+	}
+}
+
+// WithEgressFilterChainMatch returns an option to match the filter chain of
+// the egress socket listeners on the SNI serverNames and transportProtocol,
+// raw_buffer or tls, empty values matching anything. The TLS inspector is
+// added to the listeners when the match needs it. An unknown transport
+// protocol is logged and ignored.
+func WithEgressFilterChainMatch(serverNames []string, transportProtocol string) Option {
+	return func(s *Snapshotter) {
+		switch transportProtocol {
+		case "", TransportProtocolRawBuffer, TransportProtocolTLS:
+		default:
+			s.logger.Errorf("invalid egress filter chain match: unknown transport protocol %q", transportProtocol)
+			return
+		}
+		s.egressFilterChainMatch = &listenerv3.FilterChainMatch{
+			ServerNames:       append([]string(nil), serverNames...),
+			TransportProtocol: transportProtocol,
+		}
+	}
+}
+
+// WithEgressReusePort returns an option to set enable_reuse_port on the
+// egress socket listeners, left unset by default for Envoy to decide.
+func WithEgressReusePort(enabled bool) Option {
+	return func(s *Snapshotter) {
+		s.egressReusePort = &enabled
+	}
+}
+
+// applyEgressReusePort sets the configured enable_reuse_port on the egress listeners.
+func (s *Snapshotter) applyEgressReusePort(listeners []types.Resource) {
+	if s.egressReusePort == nil {
+		return
+	}
+	for _, r := range listeners {
+		if l, ok := r.(*listenerv3.Listener); ok {
+			l.EnableReusePort = wrapperspb.Bool(*s.egressReusePort)
+		}
+	}
+}
+
+// applyListenerDrainType sets the configured drain type on the listeners in resources.
+func (s *Snapshotter) applyListenerDrainType(resources []types.Resource) {
+	if s.listenerDrainType == listenerv3.Listener_DEFAULT {
+		return
+	}
+	for _, r := range resources {
+		if l, ok := r.(*listenerv3.Listener); ok {
+			l.DrainType = s.listenerDrainType
+		}
+	}
+}
+
+// applyEgressFilterChainMatch sets the configured filter chain match on the
+// egress listeners, adding the TLS inspector when matching on SNI or TLS.
+// Every listener gets its own copy, so none aliases another or the option.
+func (s *Snapshotter) applyEgressFilterChainMatch(listeners []types.Resource) {
+	match := s.egressFilterChainMatch
+	if match == nil {
+		return
+	}
+	var inspector *listenerv3.ListenerFilter
+	if len(match.ServerNames) > 0 || match.TransportProtocol == TransportProtocolTLS {
+		config, _ := anypb.New(&tlsinspectorv3.TlsInspector{})
+		inspector = &listenerv3.ListenerFilter{
+			Name:       wellknown.TLSInspector,
+			ConfigType: &listenerv3.ListenerFilter_TypedConfig{TypedConfig: config},
+		}
+	}
+	for _, r := range listeners {
+		l, ok := r.(*listenerv3.Listener)
+		if !ok {
+			continue
+		}
+		for _, chain := range l.FilterChains {
+			chain.FilterChainMatch = proto.Clone(match).(*listenerv3.FilterChainMatch)
+		}
+		if inspector != nil {
+			l.ListenerFilters = append(l.ListenerFilters, proto.Clone(inspector).(*listenerv3.ListenerFilter))
+		}
+	}
+}
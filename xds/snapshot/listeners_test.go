@@ -0,0 +1,150 @@
+package snapshot
+
+import (
+	"slices"
+	"testing"
+
+	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
+	"google.golang.org/protobuf/proto"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestServicesToResourcesListenerDrainType(t *testing.T) {
+	services := []*corev1.Service{gatewayService("api", "default", "public")}
+
+	tests := []struct {
+		name string
+		opts []Option
+		want listenerv3.Listener_DrainType
+	}{
+		{"default", nil, listenerv3.Listener_DEFAULT},
+		{"modify only", []Option{WithListenerDrainType(listenerv3.Listener_MODIFY_ONLY)}, listenerv3.Listener_MODIFY_ONLY},
+		{"invalid", []Option{WithListenerDrainType(42)}, listenerv3.Listener_DEFAULT},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]Option{WithEgressListeners(15000)}, tt.opts...)
+			resources, _ := newTestSnapshotter(opts...).servicesToResources(services)
+			listeners := 0
+			for _, r := range resources {
+				l, ok := r.(*listenerv3.Listener)
+				if !ok {
+					continue
+				}
+				listeners++
+				if l.DrainType != tt.want {
+					t.Errorf("listener %s: expected drain type %s, got %s", l.Name, tt.want, l.DrainType)
+				}
+			}
+			// service, egress and api gateway listeners
+			if listeners != 3 {
+				t.Errorf("expected 3 listeners, got %d", listeners)
+			}
+		})
+	}
+}
+
+func TestServicesToResourcesEgressFilterChainMatch(t *testing.T) {
+	services := []*corev1.Service{{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "https", Port: 443}}},
+	}}
+
+	tests := []struct {
+		name          string
+		opts          []Option
+		serverNames   []string
+		transport     string
+		wantMatch     bool
+		wantInspector bool
+	}{
+		{"none", nil, nil, "", false, false},
+		{"sni", []Option{WithEgressFilterChainMatch([]string{"web.example.com"}, TransportProtocolTLS)},
+			[]string{"web.example.com"}, TransportProtocolTLS, true, true},
+		{"raw buffer", []Option{WithEgressFilterChainMatch(nil, TransportProtocolRawBuffer)},
+			nil, TransportProtocolRawBuffer, true, false},
+		{"invalid transport", []Option{WithEgressFilterChainMatch(nil, "quic")}, nil, "", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]Option{WithEgressListeners(15000), WithApiGateway(false)}, tt.opts...)
+			resources, _ := newTestSnapshotter(opts...).servicesToResources(services)
+			l := findListener(resources, "egress/web.default:443")
+			if l == nil {
+				t.Fatal("egress listener missing")
+			}
+			match := l.GetFilterChains()[0].GetFilterChainMatch()
+			if (match != nil) != tt.wantMatch {
+				t.Fatalf("expected filter chain match %t, got %v", tt.wantMatch, match)
+			}
+			if !slices.Equal(match.GetServerNames(), tt.serverNames) {
+				t.Errorf("expected server names %v, got %v", tt.serverNames, match.GetServerNames())
+			}
+			if match.GetTransportProtocol() != tt.transport {
+				t.Errorf("expected transport protocol %q, got %q", tt.transport, match.GetTransportProtocol())
+			}
+			inspector := len(l.ListenerFilters) == 1 && l.ListenerFilters[0].Name == wellknown.TLSInspector
+			if inspector != tt.wantInspector || (!tt.wantInspector && len(l.ListenerFilters) > 0) {
+				t.Errorf("expected tls inspector %t, got listener filters %v", tt.wantInspector, l.ListenerFilters)
+			}
+		})
+	}
+}
+
+func TestApplyEgressFilterChainMatchCopies(t *testing.T) {
+	services := []*corev1.Service{{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "https", Port: 443}, {Name: "admin", Port: 8443}}},
+	}}
+	s := newTestSnapshotter(WithEgressListeners(15000), WithApiGateway(false), WithEgressFilterChainMatch([]string{"web.example.com"}, TransportProtocolTLS))
+	resources, _ := s.servicesToResources(services)
+	web, admin := findListener(resources, "egress/web.default:443"), findListener(resources, "egress/web.default:8443")
+	if web == nil || admin == nil {
+		t.Fatal("egress listeners missing")
+	}
+
+	// editing the match of one listener must leave the others and the option alone
+	web.GetFilterChains()[0].GetFilterChainMatch().ServerNames[0] = "edited.example.com"
+	web.ListenerFilters[0].Name = "edited"
+	if got := admin.GetFilterChains()[0].GetFilterChainMatch().GetServerNames(); !slices.Equal(got, []string{"web.example.com"}) {
+		t.Errorf("expected the admin listener match untouched, got %v", got)
+	}
+	if got := admin.ListenerFilters[0].Name; got != wellknown.TLSInspector {
+		t.Errorf("expected the admin listener inspector untouched, got %s", got)
+	}
+	if got := s.egressFilterChainMatch.GetServerNames(); !slices.Equal(got, []string{"web.example.com"}) {
+		t.Errorf("expected the configured match untouched, got %v", got)
+	}
+}
+
+func TestServicesToResourcesEgressReusePort(t *testing.T) {
+	services := []*corev1.Service{{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "http", Port: 80}}},
+	}}
+	tests := []struct {
+		name string
+		opts []Option
+		want *bool
+	}{
+		{"unset", nil, nil},
+		{"enabled", []Option{WithEgressReusePort(true)}, proto.Bool(true)},
+		{"disabled", []Option{WithEgressReusePort(false)}, proto.Bool(false)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]Option{WithEgressListeners(15000), WithApiGateway(false)}, tt.opts...)
+			resources, _ := newTestSnapshotter(opts...).servicesToResources(services)
+			l := findListener(resources, "egress/web.default:80")
+			if l == nil {
+				t.Fatal("egress listener missing")
+			}
+			got := l.GetEnableReusePort()
+			if (got == nil) != (tt.want == nil) || (got != nil && got.GetValue() != *tt.want) {
+				t.Errorf("expected enable_reuse_port %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
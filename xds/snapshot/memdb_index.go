@@ -0,0 +1,46 @@
+package snapshot
+
+import (
+	"fmt"
+
+	k8scache "k8s.io/client-go/tools/cache"
+)
+
+// objectKeyIndex indexes Kubernetes objects in MemDB by their namespace/name key.
+type objectKeyIndex struct{}
+
+func (objectKeyIndex) FromObject(obj interface{}) (bool, []byte, error) {
+	key, err := k8scache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return false, nil, err
+	}
+	// null terminated, as the memdb string indexes
+	return true, []byte(key + "\x00"), nil
+}
+
+func (objectKeyIndex) FromArgs(args ...interface{}) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("must provide only a single argument")
+	}
+	key, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("argument must be a string: %#v", args[0])
+	}
+	return []byte(key + "\x00"), nil
+}
+
+// endpointCacheItemIndex indexes the endpoint resources cached in MemDB by
+// the namespace/name key of their Endpoints.
+type endpointCacheItemIndex struct{}
+
+func (endpointCacheItemIndex) FromObject(obj interface{}) (bool, []byte, error) {
+	item, ok := obj.(*endpointCacheItem)
+	if !ok {
+		return false, nil, fmt.Errorf("object must be an endpoint cache item: %#v", obj)
+	}
+	return true, []byte(item.key + "\x00"), nil
+}
+
+func (endpointCacheItemIndex) FromArgs(args ...interface{}) ([]byte, error) {
+	return objectKeyIndex{}.FromArgs(args...)
+}
@@ -0,0 +1,64 @@
+package snapshot
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMemDBObjectKeyIndex(t *testing.T) {
+	db, err := newTestSnapshotter().createMemDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	txn := db.Txn(true)
+	for _, obj := range []interface{}{
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", ResourceVersion: "1"}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "staging", ResourceVersion: "2"}},
+	} {
+		if err := txn.Insert("services", obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, obj := range []interface{}{
+		&corev1.Endpoints{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", ResourceVersion: "3"}},
+		&corev1.Endpoints{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "staging", ResourceVersion: "4"}},
+	} {
+		if err := txn.Insert("endpoints", obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+	txn.Commit()
+
+	tests := []struct {
+		table   string
+		key     string
+		version string
+	}{
+		{"services", "default/web", "1"},
+		{"services", "staging/web", "2"},
+		{"services", "web", ""},
+		{"endpoints", "default/web", "3"},
+		{"endpoints", "staging/web", "4"},
+	}
+	read := db.Txn(false)
+	defer read.Abort()
+	for _, tt := range tests {
+		obj, err := read.First(tt.table, "id", tt.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var version string
+		if m, ok := obj.(metav1.Object); ok {
+			version = m.GetResourceVersion()
+		}
+		if version != tt.version {
+			t.Errorf("%s %s: expected version %q, got %q", tt.table, tt.key, tt.version, version)
+		}
+	}
+
+	if _, err := read.First("services", "id", 42); err == nil {
+		t.Error("expected an error for a key that is not a string")
+	}
+}
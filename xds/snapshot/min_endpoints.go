@@ -0,0 +1,75 @@
+package snapshot
+
+import (
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/nebucloud/pkg/logger"
+)
+
+// WithMinEndpoints returns an option to hold back the assignment of a cluster
+// whose ready endpoints drop below min, keeping Envoy on the previously
+// published one rather than overloading the few endpoints left during a
+// rollout. A cluster never published above min is served as is. A min of
+// zero disables the threshold, a negative one is logged and ignored.
+func WithMinEndpoints(min int) Option {
+	return func(s *Snapshotter) {
+		if min < 0 {
+			s.logger.Errorf("invalid minimum endpoints %d: expect zero or more", min)
+			return
+		}
+		s.minEndpoints = min
+	}
+}
+
+// holdBackEndpoints replaces in resources the cluster load assignments with
+// fewer ready endpoints than the threshold by their previous version, logging
+// a warning for each cluster below it.
+func (s *Snapshotter) holdBackEndpoints(log *logger.Klogger, resources []types.Resource) []types.Resource {
+	if s.minEndpoints == 0 {
+		return resources
+	}
+	previous := map[string]*endpointv3.ClusterLoadAssignment{}
+	for _, r := range s.getEndpointResourcesByType()[resource.EndpointType] {
+		if cla, ok := r.(*endpointv3.ClusterLoadAssignment); ok {
+			previous[cla.GetClusterName()] = cla
+		}
+	}
+
+	out := make([]types.Resource, 0, len(resources))
+	for _, r := range resources {
+		cla, ok := r.(*endpointv3.ClusterLoadAssignment)
+		if !ok {
+			out = append(out, r)
+			continue
+		}
+		ready := readyEndpoints(cla)
+		if ready >= s.minEndpoints {
+			out = append(out, cla)
+			continue
+		}
+		if held, ok := previous[cla.GetClusterName()]; ok {
+			log.Warnf("Cluster %s has %d ready endpoints, below the minimum of %d, keeping its %d previous endpoints", cla.GetClusterName(), ready, s.minEndpoints, readyEndpoints(held))
+			out = append(out, held)
+			continue
+		}
+		log.Warnf("Cluster %s has %d ready endpoints, below the minimum of %d", cla.GetClusterName(), ready, s.minEndpoints)
+		out = append(out, cla)
+	}
+	return out
+}
+
+// readyEndpoints returns the number of endpoints of cla not draining, only
+// the ready addresses of an Endpoints object being assigned.
+func readyEndpoints(cla *endpointv3.ClusterLoadAssignment) int {
+	var n int
+	for _, locality := range cla.GetEndpoints() {
+		for _, lb := range locality.GetLbEndpoints() {
+			if lb.GetHealthStatus() != corev3.HealthStatus_DRAINING {
+				n++
+			}
+		}
+	}
+	return n
+}
@@ -0,0 +1,65 @@
+package snapshot
+
+import (
+	"log/slog"
+	"testing"
+
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/nebucloud/pkg/logger"
+)
+
+// assignment returns the load assignment of cluster with n endpoints.
+func assignment(cluster string, n int) *endpointv3.ClusterLoadAssignment {
+	locality := &endpointv3.LocalityLbEndpoints{}
+	for i := 0; i < n; i++ {
+		locality.LbEndpoints = append(locality.LbEndpoints, &endpointv3.LbEndpoint{})
+	}
+	return &endpointv3.ClusterLoadAssignment{ClusterName: cluster, Endpoints: []*endpointv3.LocalityLbEndpoints{locality}}
+}
+
+func TestHoldBackEndpoints(t *testing.T) {
+	tests := []struct {
+		name     string
+		min      int
+		previous int
+		current  int
+		want     int
+		warned   bool
+	}{
+		{"disabled", 0, 10, 1, 1, false},
+		{"above", 3, 10, 5, 5, false},
+		{"at threshold", 3, 10, 3, 3, false},
+		{"below", 3, 10, 2, 10, true},
+		{"below when scaling up", 3, 1, 2, 1, true},
+		{"below never published", 3, 0, 2, 2, true},
+		{"negative ignored", -1, 10, 1, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSnapshotter(WithMinEndpoints(tt.min))
+			if tt.previous > 0 {
+				s.setEndpointResourcesByType(map[string][]types.Resource{resource.EndpointType: {assignment("web.default:http", tt.previous)}})
+			}
+			handler := logger.NewMemoryHandler()
+			log := &logger.Klogger{}
+			log.SetLogger(slog.New(handler))
+
+			out := s.holdBackEndpoints(log, []types.Resource{assignment("web.default:http", tt.current), assignment("api.default:grpc", 5)})
+			if len(out) != 2 {
+				t.Fatalf("expected both assignments, got %v", out)
+			}
+			if got := readyEndpoints(out[0].(*endpointv3.ClusterLoadAssignment)); got != tt.want {
+				t.Errorf("expected %d endpoints served, got %d", tt.want, got)
+			}
+			if got := readyEndpoints(out[1].(*endpointv3.ClusterLoadAssignment)); got != 5 {
+				t.Errorf("expected the cluster above the threshold served as is, got %d endpoints", got)
+			}
+			r, ok := handler.Last()
+			if warned := ok && r.Level == slog.LevelWarn; warned != tt.warned {
+				t.Errorf("expected warned %v, got %v", tt.warned, handler.Records())
+			}
+		})
+	}
+}
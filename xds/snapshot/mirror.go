@@ -0,0 +1,73 @@
+package snapshot
+
+import (
+	"fmt"
+	"net"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/nebucloud/pkg/xds/snapshot/annotations"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Service annotations shadowing its traffic to another generated cluster,
+// e.g. web-v2.default:http, MirrorPercentAnnotation percent of it, 100 by default
+const (
+	MirrorClusterAnnotation = "xds.nebucloud.com/mirror-cluster"
+	MirrorPercentAnnotation = "xds.nebucloud.com/mirror-percent"
+)
+
+// mirrorPolicy is the traffic mirroring of the routes of a service, the zero
+// value mirroring nothing
+type mirrorPolicy struct {
+	cluster string
+	percent int
+}
+
+func (m mirrorPolicy) toProto() []*routev3.RouteAction_RequestMirrorPolicy {
+	if m.cluster == "" {
+		return nil
+	}
+	return []*routev3.RouteAction_RequestMirrorPolicy{{
+		Cluster: m.cluster,
+		RuntimeFraction: &corev3.RuntimeFractionalPercent{
+			DefaultValue: &typev3.FractionalPercent{
+				Numerator:   uint32(m.percent),
+				Denominator: typev3.FractionalPercent_HUNDRED,
+			},
+		},
+	}}
+}
+
+// serviceClusterNames returns the names of the clusters generated for services.
+func serviceClusterNames(services []*corev1.Service) map[string]bool {
+	clusters := map[string]bool{}
+	for _, svc := range services {
+		fullName := fmt.Sprintf("%s.%s", svc.Name, svc.Namespace)
+		for _, port := range svc.Spec.Ports {
+			clusters[net.JoinHostPort(fullName, port.Name)] = true
+		}
+	}
+	return clusters
+}
+
+// serviceMirror returns the traffic mirroring annotated on svc, which must
+// target one of clusters.
+func serviceMirror(svc *corev1.Service, clusters map[string]bool) (mirrorPolicy, error) {
+	cluster, ok := svc.Annotations[MirrorClusterAnnotation]
+	if !ok {
+		return mirrorPolicy{}, nil
+	}
+	if !clusters[cluster] {
+		return mirrorPolicy{}, &annotations.Error{Key: MirrorClusterAnnotation, Value: cluster, Reason: "expect a generated cluster"}
+	}
+	percent, err := annotations.GetInt(svc.Annotations, MirrorPercentAnnotation, 100)
+	if err != nil {
+		return mirrorPolicy{}, err
+	}
+	if percent < 0 || percent > 100 {
+		return mirrorPolicy{}, &annotations.Error{Key: MirrorPercentAnnotation, Value: svc.Annotations[MirrorPercentAnnotation], Reason: "expect a percentage in [0, 100]"}
+	}
+	return mirrorPolicy{cluster: cluster, percent: percent}, nil
+}
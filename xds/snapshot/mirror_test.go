@@ -0,0 +1,138 @@
+package snapshot
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+
+	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	managerv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/nebucloud/pkg/logger"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// routeMirrorPolicies returns the mirror policies of the default route of the
+// route configuration name, and of its copy inlined in the listener.
+func routeMirrorPolicies(t *testing.T, resources []types.Resource, name string) (route, listener []*routev3.RouteAction_RequestMirrorPolicy) {
+	t.Helper()
+	byName := resourcesByName(t, resources)
+	routeConfig, ok := byName[resource.RouteType+"/"+name].(*routev3.RouteConfiguration)
+	if !ok {
+		t.Fatalf("route configuration %s missing", name)
+	}
+	l := findListener(resources, name)
+	if l == nil {
+		t.Fatalf("listener %s missing", name)
+	}
+	manager := &managerv3.HttpConnectionManager{}
+	if err := l.GetApiListener().GetApiListener().UnmarshalTo(manager); err != nil {
+		t.Fatal(err)
+	}
+	return routeConfig.GetVirtualHosts()[0].GetRoutes()[0].GetRoute().GetRequestMirrorPolicies(),
+		manager.GetRouteConfig().GetVirtualHosts()[0].GetRoutes()[0].GetRoute().GetRequestMirrorPolicies()
+}
+
+func TestServicesToResourcesMirror(t *testing.T) {
+	service := func(name string, annotations map[string]string) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", ResourceVersion: "1", Annotations: annotations},
+			Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "http", Port: 80}}},
+		}
+	}
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		wantPercent uint32
+		wantMirror  bool
+	}{
+		{"absent", nil, 0, false},
+		{"percent", map[string]string{MirrorClusterAnnotation: "web-v2.default:http", MirrorPercentAnnotation: "25"}, 25, true},
+		{"default percent", map[string]string{MirrorClusterAnnotation: "web-v2.default:http"}, 100, true},
+		{"unknown cluster", map[string]string{MirrorClusterAnnotation: "api.default:http"}, 0, false},
+		{"percent out of range", map[string]string{MirrorClusterAnnotation: "web-v2.default:http", MirrorPercentAnnotation: "150"}, 0, false},
+		{"malformed percent", map[string]string{MirrorClusterAnnotation: "web-v2.default:http", MirrorPercentAnnotation: "half"}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			services := []*corev1.Service{service("web", tt.annotations), service("web-v2", nil)}
+			resources, _ := newTestSnapshotter().servicesToResources(services)
+
+			route, listener := routeMirrorPolicies(t, resources, "web.default:80")
+			for _, policies := range [][]*routev3.RouteAction_RequestMirrorPolicy{route, listener} {
+				if !tt.wantMirror {
+					if len(policies) != 0 {
+						t.Errorf("expected no mirror policy, got %v", policies)
+					}
+					continue
+				}
+				if len(policies) != 1 {
+					t.Fatalf("expected one mirror policy, got %v", policies)
+				}
+				fraction := policies[0].GetRuntimeFraction().GetDefaultValue()
+				if policies[0].GetCluster() != "web-v2.default:http" || fraction.GetNumerator() != tt.wantPercent || fraction.GetDenominator() != typev3.FractionalPercent_HUNDRED {
+					t.Errorf("expected %d%% mirrored to web-v2.default:http, got %v", tt.wantPercent, policies[0])
+				}
+			}
+		})
+	}
+}
+
+func TestServicesToResourcesMirrorClusterRemoved(t *testing.T) {
+	web := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", ResourceVersion: "1",
+			Annotations: map[string]string{MirrorClusterAnnotation: "web-v2.default:p80"}},
+		Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "http", Port: 80}}},
+	}
+	s := newTestSnapshotter()
+	resources, _ := s.servicesToResources([]*corev1.Service{web, versionedService("web-v2", "default", "1", 80)})
+	if route, _ := routeMirrorPolicies(t, resources, "web.default:80"); len(route) != 1 {
+		t.Fatalf("expected the mirror policy while web-v2 exists, got %v", route)
+	}
+
+	// web is unchanged, its cached resources must not keep the stale mirror
+	resources, _ = s.servicesToResources([]*corev1.Service{web})
+	if route, listener := routeMirrorPolicies(t, resources, "web.default:80"); len(route) != 0 || len(listener) != 0 {
+		t.Errorf("expected the mirror policy dropped with its cluster, got %v %v", route, listener)
+	}
+}
+
+func TestServicesToResourcesMirrorWarnsOnChange(t *testing.T) {
+	s := newTestSnapshotter()
+	handler := logger.NewMemoryHandler()
+	s.logger = &logger.Klogger{}
+	s.logger.SetLogger(slog.New(handler))
+	web := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", ResourceVersion: "1",
+			Annotations: map[string]string{MirrorClusterAnnotation: "web-v2.default:p80"}},
+		Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "http", Port: 80}}},
+	}
+	warnings := func() int {
+		var n int
+		for _, r := range handler.Records() {
+			if strings.HasPrefix(r.Message, "Invalid mirror annotations") {
+				n++
+			}
+		}
+		return n
+	}
+
+	for i := 0; i < 3; i++ {
+		s.servicesToResources([]*corev1.Service{web})
+	}
+	if got := warnings(); got != 1 {
+		t.Errorf("expected the unchanged service warned once, got %d", got)
+	}
+
+	// the mirror cluster appearing converts web again
+	resources, _ := s.servicesToResources([]*corev1.Service{web, versionedService("web-v2", "default", "1", 80)})
+	if route, _ := routeMirrorPolicies(t, resources, "web.default:80"); len(route) != 1 {
+		t.Errorf("expected the mirror policy once web-v2 exists, got %v", route)
+	}
+	if got := warnings(); got != 1 {
+		t.Errorf("expected no warning for the valid mirror, got %d", got)
+	}
+}
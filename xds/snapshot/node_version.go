@@ -0,0 +1,94 @@
+package snapshot
+
+import (
+	"strconv"
+	"strings"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// envoyUserAgent is the user_agent_name reported by Envoy nodes
+	envoyUserAgent = "envoy"
+	// legacyNodeGroup is the snapshot key of Envoy nodes lacking newer fields
+	legacyNodeGroup = "envoy-legacy"
+)
+
+// reusePortVersion is the first Envoy version supporting Listener.enable_reuse_port
+var reusePortVersion = envoyVersion{major: 1, minor: 21}
+
+type envoyVersion struct {
+	major, minor uint32
+}
+
+func (v envoyVersion) less(o envoyVersion) bool {
+	if v.major != o.major {
+		return v.major < o.major
+	}
+	return v.minor < o.minor
+}
+
+// nodeEnvoyVersion returns the Envoy version reported by node, preferring the
+// structured build version over the user agent version string.
+func nodeEnvoyVersion(node *corev3.Node) (envoyVersion, bool) {
+	if node.GetUserAgentName() != envoyUserAgent {
+		return envoyVersion{}, false
+	}
+	if v := node.GetUserAgentBuildVersion().GetVersion(); v != nil {
+		return envoyVersion{major: v.GetMajorNumber(), minor: v.GetMinorNumber()}, true
+	}
+	parts := strings.SplitN(strings.TrimPrefix(node.GetUserAgentVersion(), "v"), ".", 3)
+	if len(parts) < 2 {
+		return envoyVersion{}, false
+	}
+	major, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return envoyVersion{}, false
+	}
+	minor, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return envoyVersion{}, false
+	}
+	return envoyVersion{major: uint32(major), minor: uint32(minor)}, true
+}
+
+// EnvoyVersionNodeID satisfies cachev3.NodeHash and groups nodes by the
+// features their Envoy version supports. Non Envoy nodes and nodes with an
+// unknown version share the default group with up-to-date Envoys.
+type EnvoyVersionNodeID struct{}
+
+func (e EnvoyVersionNodeID) ID(node *corev3.Node) string {
+	if v, ok := nodeEnvoyVersion(node); ok && v.less(reusePortVersion) {
+		return legacyNodeGroup
+	}
+	return ""
+}
+
+// legacyResources returns a copy of resourcesByType without the fields legacy
+// Envoy nodes would NACK. Unchanged resources are shared with the input.
+func legacyResources(resourcesByType map[string][]types.Resource) map[string][]types.Resource {
+	out := make(map[string][]types.Resource, len(resourcesByType))
+	for typeURL, resources := range resourcesByType {
+		if typeURL != resource.ListenerType {
+			out[typeURL] = resources
+			continue
+		}
+		listeners := make([]types.Resource, len(resources))
+		for i, r := range resources {
+			l, ok := r.(*listenerv3.Listener)
+			if !ok || l.EnableReusePort == nil {
+				listeners[i] = r
+				continue
+			}
+			l = proto.Clone(l).(*listenerv3.Listener)
+			l.EnableReusePort = nil
+			listeners[i] = l
+		}
+		out[typeURL] = listeners
+	}
+	return out
+}
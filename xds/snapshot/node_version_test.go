@@ -0,0 +1,80 @@
+package snapshot
+
+import (
+	"context"
+	"testing"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func envoyNode(version string) *corev3.Node {
+	return &corev3.Node{
+		UserAgentName:        envoyUserAgent,
+		UserAgentVersionType: &corev3.Node_UserAgentVersion{UserAgentVersion: version},
+	}
+}
+
+func TestEnvoyVersionNodeID(t *testing.T) {
+	tests := []struct {
+		name string
+		node *corev3.Node
+		want string
+	}{
+		{"nil node", nil, ""},
+		{"grpc client", &corev3.Node{UserAgentName: "gRPC Go"}, ""},
+		{"legacy string version", envoyNode("1.20.3"), legacyNodeGroup},
+		{"modern string version", envoyNode("v1.30.1"), ""},
+		{"legacy build version", &corev3.Node{
+			UserAgentName: envoyUserAgent,
+			UserAgentVersionType: &corev3.Node_UserAgentBuildVersion{UserAgentBuildVersion: &corev3.BuildVersion{
+				Version: &typev3.SemanticVersion{MajorNumber: 1, MinorNumber: 19},
+			}},
+		}, legacyNodeGroup},
+		{"unparsable version", envoyNode("dev"), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (EnvoyVersionNodeID{}).ID(tt.node); got != tt.want {
+				t.Errorf("expected group %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSetServicesSnapshotVersionGating(t *testing.T) {
+	s := newTestSnapshotter(WithEnvoyVersionGating(), WithEgressListeners(15000), WithEgressReusePort(true))
+
+	services := []*corev1.Service{{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "http", Port: 80}}},
+	}}
+	resources, _ := s.servicesToResources(services)
+	s.setServicesSnapshot(context.Background(), "1", resourcesToMap(resources))
+
+	modern := envoyNode("1.30.0")
+	legacy := envoyNode("1.20.0")
+
+	egressListener := func(node *corev3.Node) *listenerv3.Listener {
+		snapshot, err := s.servicesCache.GetSnapshot(EnvoyVersionNodeID{}.ID(node))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, ok := snapshot.GetResources(resource.ListenerType)["egress/web.default:80"]
+		if !ok {
+			t.Fatalf("egress listener missing for node %s", node.GetUserAgentVersion())
+		}
+		return r.(*listenerv3.Listener)
+	}
+
+	if !egressListener(modern).GetEnableReusePort().GetValue() {
+		t.Errorf("expected enable_reuse_port for modern Envoy")
+	}
+	if egressListener(legacy).GetEnableReusePort() != nil {
+		t.Errorf("expected enable_reuse_port to be omitted for legacy Envoy")
+	}
+}
@@ -0,0 +1,135 @@
+package snapshot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// gzipMagic prefixes every gzip stream, and never starts a JSON array
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// encodeResources serializes resources to a protojson array, gzipped when compress is set.
+func encodeResources(resources []types.Resource, compress bool) ([]byte, error) {
+	items := make([]json.RawMessage, 0, len(resources))
+	for _, r := range resources {
+		a, err := anypb.New(r)
+		if err != nil {
+			return nil, err
+		}
+		item, err := protojson.Marshal(a)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	data, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+	if !compress {
+		return data, nil
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeResources reverses encodeResources, decompressing gzipped data transparently.
+func decodeResources(data []byte) ([]types.Resource, error) {
+	if bytes.HasPrefix(data, gzipMagic) {
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		if data, err = io.ReadAll(r); err != nil {
+			return nil, err
+		}
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, err
+	}
+	out := make([]types.Resource, 0, len(items))
+	for _, item := range items {
+		a := &anypb.Any{}
+		if err := protojson.Unmarshal(item, a); err != nil {
+			return nil, err
+		}
+		r, err := a.UnmarshalNew()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// persistSnapshot stores the serialized resources of a cache snapshot in EdgeDB.
+func (s *Snapshotter) persistSnapshot(ctx context.Context, client EdgeDBQuerier, cacheName, version string, resources []types.Resource) error {
+	data, err := encodeResources(resources, s.persistenceCompression)
+	if err != nil {
+		return err
+	}
+	return client.Execute(ctx, `
+		INSERT Snapshot {
+			cache := <str>$cache,
+			version := <str>$version,
+			resources := <bytes>$resources,
+			created := datetime_current(),
+		}
+	`, map[string]interface{}{
+		"cache":     cacheName,
+		"version":   version,
+		"resources": data,
+	})
+}
+
+// persistedSnapshot is a snapshot row read back from EdgeDB
+type persistedSnapshot struct {
+	Version   string `edgedb:"version"`
+	Resources []byte `edgedb:"resources"`
+}
+
+// restoreSnapshot returns the version and resources of the snapshot of
+// cacheName last persisted in EdgeDB, an empty version when there is none.
+func (s *Snapshotter) restoreSnapshot(ctx context.Context, client EdgeDBQuerier, cacheName string) (string, []types.Resource, error) {
+	var row persistedSnapshot
+	err := client.QuerySingle(ctx, `
+		SELECT Snapshot { version, resources }
+		FILTER .cache = <str>$cache
+		ORDER BY .created DESC
+		LIMIT 1
+	`, &row, map[string]interface{}{
+		"cache": cacheName,
+	})
+	var edgedbErr edgedb.Error
+	if errors.As(err, &edgedbErr) && edgedbErr.Category(edgedb.NoDataError) {
+		return "", nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	resources, err := decodeResources(row.Resources)
+	if err != nil {
+		return "", nil, err
+	}
+	return row.Version, resources, nil
+}
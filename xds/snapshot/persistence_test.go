@@ -0,0 +1,93 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/nebucloud/pkg/logger"
+	"google.golang.org/protobuf/proto"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func representativeResources() []types.Resource {
+	var services []*corev1.Service
+	for i := 0; i < 50; i++ {
+		services = append(services, &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("svc-%d", i), Namespace: "default"},
+			Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{
+				{Name: "http", Port: 80},
+				{Name: "grpc", Port: 9090},
+			}},
+		})
+	}
+	return kubeServicesToResources(services, logger.Singleton())
+}
+
+func TestEncodeResourcesRoundTrip(t *testing.T) {
+	resources := representativeResources()
+
+	for _, compress := range []bool{false, true} {
+		data, err := encodeResources(resources, compress)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := bytes.HasPrefix(data, gzipMagic); got != compress {
+			t.Errorf("compress=%t: expected gzip data %t, got %t", compress, compress, got)
+		}
+		decoded, err := decodeResources(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(decoded) != len(resources) {
+			t.Fatalf("compress=%t: expected %d resources, got %d", compress, len(resources), len(decoded))
+		}
+		for i := range resources {
+			if !proto.Equal(resources[i], decoded[i]) {
+				t.Errorf("compress=%t: resource %d differs after round trip", compress, i)
+			}
+		}
+	}
+}
+
+func TestEncodeResourcesCompressionReducesSize(t *testing.T) {
+	resources := representativeResources()
+
+	plain, err := encodeResources(resources, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed, err := encodeResources(resources, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(compressed)*2 > len(plain) {
+		t.Errorf("expected compression to at least halve %d bytes, got %d", len(plain), len(compressed))
+	}
+}
+
+func TestPersistSnapshotArguments(t *testing.T) {
+	resources := representativeResources()
+	edgedb := &fakeEdgeDB{}
+	if err := newTestSnapshotter().persistSnapshot(context.Background(), edgedb, "services", "1", resources); err != nil {
+		t.Fatalf("expected the snapshot persisted, got %s", err)
+	}
+	if len(edgedb.executed) != 1 {
+		t.Fatalf("expected one insert, got %v", edgedb.executed)
+	}
+	args := edgedb.executed[0]
+	if args["cache"] != "services" || args["version"] != "1" {
+		t.Errorf("unexpected arguments %v", args)
+	}
+	data, _ := args["resources"].([]byte)
+	decoded, err := decodeResources(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != len(resources) {
+		t.Errorf("expected %d resources persisted, got %d", len(resources), len(decoded))
+	}
+}
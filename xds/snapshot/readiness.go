@@ -0,0 +1,82 @@
+package snapshot
+
+import (
+	"context"
+
+	"github.com/edgedb/edgedb-go"
+	consulApi "github.com/hashicorp/consul/api"
+)
+
+// PingFunc checks that a dependency is reachable
+type PingFunc func(ctx context.Context) error
+
+// dependencyCheck is a named PingFunc gating readiness
+type dependencyCheck struct {
+	name string
+	ping PingFunc
+}
+
+// WithDependencyReadiness returns an option to gate Ready on EdgeDB and
+// Consul being reachable, once the snapshotter connects to them.
+func WithDependencyReadiness() Option {
+	return func(s *Snapshotter) {
+		s.dependencyReadiness = true
+	}
+}
+
+// WithDependencyCheck returns an option to gate Ready on ping succeeding.
+func WithDependencyCheck(name string, ping PingFunc) Option {
+	return func(s *Snapshotter) {
+		s.dependencyChecks = append(s.dependencyChecks, dependencyCheck{name: name, ping: ping})
+	}
+}
+
+// EdgeDBPing returns a PingFunc checking the connection of client.
+func EdgeDBPing(client *edgedb.Client) PingFunc {
+	return client.EnsureConnected
+}
+
+// ConsulPing returns a PingFunc checking that client reaches a Consul leader.
+func ConsulPing(client *consulApi.Client) PingFunc {
+	return func(ctx context.Context) error {
+		_, err := client.Status().LeaderWithQueryOptions((&consulApi.QueryOptions{}).WithContext(ctx))
+		return err
+	}
+}
+
+// addDependencyChecks registers the integration dependencies when
+// dependency readiness is enabled.
+func (s *Snapshotter) addDependencyChecks(edgedbClient *edgedb.Client, consulClient *consulApi.Client) {
+	if !s.dependencyReadiness {
+		return
+	}
+	s.readinessLock.Lock()
+	defer s.readinessLock.Unlock()
+	s.dependenciesAdded = true
+	if edgedbClient != nil {
+		s.dependencyChecks = append(s.dependencyChecks, dependencyCheck{name: "edgedb", ping: EdgeDBPing(edgedbClient)})
+	}
+	if consulClient != nil {
+		s.dependencyChecks = append(s.dependencyChecks, dependencyCheck{name: "consul", ping: ConsulPing(consulClient)})
+	}
+}
+
+// Ready reports whether every dependency check succeeds. With dependency
+// readiness enabled, it is false until the snapshotter has connected.
+func (s *Snapshotter) Ready(ctx context.Context) bool {
+	s.readinessLock.RLock()
+	checks := s.dependencyChecks
+	pending := s.dependencyReadiness && !s.dependenciesAdded
+	s.readinessLock.RUnlock()
+
+	if pending {
+		return false
+	}
+	for _, check := range checks {
+		if err := check.ping(ctx); err != nil {
+			s.logger.V(2).Infof("Dependency %s is not ready: %v", check.name, err)
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,60 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeDependency is a dependency whose health is toggled by the test.
+type fakeDependency struct {
+	healthy atomic.Bool
+}
+
+func (f *fakeDependency) ping(context.Context) error {
+	if !f.healthy.Load() {
+		return errors.New("unreachable")
+	}
+	return nil
+}
+
+func TestReadyDependencies(t *testing.T) {
+	edgedb, consul := &fakeDependency{}, &fakeDependency{}
+	s := newTestSnapshotter(
+		WithDependencyCheck("edgedb", edgedb.ping),
+		WithDependencyCheck("consul", consul.ping),
+	)
+	ctx := context.Background()
+
+	if s.Ready(ctx) {
+		t.Errorf("expected not ready while both dependencies are down")
+	}
+	edgedb.healthy.Store(true)
+	if s.Ready(ctx) {
+		t.Errorf("expected not ready while consul is down")
+	}
+	consul.healthy.Store(true)
+	if !s.Ready(ctx) {
+		t.Errorf("expected ready once both dependencies are healthy")
+	}
+	edgedb.healthy.Store(false)
+	if s.Ready(ctx) {
+		t.Errorf("expected not ready once edgedb goes down again")
+	}
+}
+
+func TestReadyDependencyReadiness(t *testing.T) {
+	if !newTestSnapshotter().Ready(context.Background()) {
+		t.Errorf("expected ready without dependency checks")
+	}
+
+	s := newTestSnapshotter(WithDependencyReadiness())
+	if s.Ready(context.Background()) {
+		t.Errorf("expected not ready before connecting to the dependencies")
+	}
+	s.addDependencyChecks(nil, nil)
+	if !s.Ready(context.Background()) {
+		t.Errorf("expected ready once connected without enabled integrations")
+	}
+}
@@ -0,0 +1,99 @@
+package snapshot
+
+import (
+	"context"
+	"strconv"
+
+	runtimev3 "github.com/envoyproxy/go-control-plane/envoy/service/runtime/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"google.golang.org/protobuf/types/known/structpb"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	k8scache "k8s.io/client-go/tools/cache"
+)
+
+// WithRuntimeConfigMap returns an option to serve the data of the ConfigMap
+// namespace/name over RTDS, as a runtime layer named after the ConfigMap.
+func WithRuntimeConfigMap(namespace, name string) Option {
+	return func(s *Snapshotter) {
+		s.runtimeConfigMap = &k8scache.ObjectName{Namespace: namespace, Name: name}
+	}
+}
+
+func (s *Snapshotter) startRuntime(ctx context.Context) error {
+	emits := &emitter{}
+	store := s.newEmitStore(s.coalesce(ctx, emits.emit))
+
+	selector := fields.OneTermEqualSelector("metadata.name", s.runtimeConfigMap.Name).String()
+	reflector := k8scache.NewReflector(&k8scache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = selector
+			return s.client.CoreV1().ConfigMaps(s.runtimeConfigMap.Namespace).List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = selector
+			return s.client.CoreV1().ConfigMaps(s.runtimeConfigMap.Namespace).Watch(ctx, options)
+		},
+	}, &corev1.ConfigMap{}, store, s.ResyncPeriod)
+
+	emits.activate(func() {
+		s.emitRuntime(ctx, reflector.LastSyncResourceVersion(), sliceToConfigMaps(store.List()))
+	})
+
+	s.trackReflector("runtime", reflector)
+	reflector.Run(ctx.Done())
+	return nil
+}
+
+// emitRuntime publishes the runtime layers of configMaps to the runtime cache.
+func (s *Snapshotter) emitRuntime(ctx context.Context, version string, configMaps []*corev1.ConfigMap) {
+	log := s.logger.With("emit_id", newEmitID())
+	s.emitCount.Add(1)
+
+	resources := make([]types.Resource, 0, len(configMaps))
+	for _, cm := range configMaps {
+		resources = append(resources, configMapToRuntime(cm))
+	}
+	resourcesByType := resourcesToMap(resources)
+	if !s.withinResourceLimits(ctx, log, resourcesByType) {
+		return
+	}
+
+	version = s.nextVersion("runtime", version)
+	s.publish(ctx, "runtime", version, resourcesByType)
+	log.Debugf("set runtime snapshot version %s", version)
+}
+
+// configMapToRuntime converts the data of cm to a runtime layer named after it.
+// Values are typed as Envoy expects them, numbers and booleans are parsed.
+func configMapToRuntime(cm *corev1.ConfigMap) *runtimev3.Runtime {
+	layer := &structpb.Struct{Fields: make(map[string]*structpb.Value, len(cm.Data))}
+	for key, value := range cm.Data {
+		layer.Fields[key] = runtimeValue(value)
+	}
+	return &runtimev3.Runtime{Name: cm.Name, Layer: layer}
+}
+
+func runtimeValue(value string) *structpb.Value {
+	if n, err := strconv.ParseFloat(value, 64); err == nil {
+		return structpb.NewNumberValue(n)
+	}
+	switch value {
+	case "true":
+		return structpb.NewBoolValue(true)
+	case "false":
+		return structpb.NewBoolValue(false)
+	}
+	return structpb.NewStringValue(value)
+}
+
+func sliceToConfigMaps(s []interface{}) []*corev1.ConfigMap {
+	out := make([]*corev1.ConfigMap, len(s))
+	for i, v := range s {
+		out[i] = v.(*corev1.ConfigMap)
+	}
+	return out
+}
@@ -0,0 +1,62 @@
+package snapshot
+
+import (
+	"context"
+	"testing"
+
+	runtimev3 "github.com/envoyproxy/go-control-plane/envoy/service/runtime/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func runtimeConfigMap() *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "envoy-runtime", Namespace: "xds"},
+		Data: map[string]string{
+			"upstream.healthy_panic_threshold":     "25.5",
+			"envoy.reloadable_features.new_codec":  "false",
+			"re2.max_program_size.error_level":     "200",
+			"overload.global_downstream_max_label": "edge",
+		},
+	}
+}
+
+func TestConfigMapToRuntime(t *testing.T) {
+	want := &runtimev3.Runtime{
+		Name: "envoy-runtime",
+		Layer: &structpb.Struct{Fields: map[string]*structpb.Value{
+			"upstream.healthy_panic_threshold":     structpb.NewNumberValue(25.5),
+			"envoy.reloadable_features.new_codec":  structpb.NewBoolValue(false),
+			"re2.max_program_size.error_level":     structpb.NewNumberValue(200),
+			"overload.global_downstream_max_label": structpb.NewStringValue("edge"),
+		}},
+	}
+	if got := configMapToRuntime(runtimeConfigMap()); !proto.Equal(got, want) {
+		t.Errorf("expected runtime %v, got %v", want, got)
+	}
+}
+
+func TestEmitRuntime(t *testing.T) {
+	if got := mapTypeURL(resource.RuntimeType); got != "runtime" {
+		t.Fatalf("expected runtime requests classified as runtime, got %q", got)
+	}
+	s := newTestSnapshotter(WithRuntimeConfigMap("xds", "envoy-runtime"))
+	if _, ok := s.muxCache.Caches["runtime"]; !ok {
+		t.Fatal("expected a runtime cache in the mux cache")
+	}
+
+	s.emitRuntime(context.Background(), "7", []*corev1.ConfigMap{runtimeConfigMap()})
+	snapshot, err := s.runtimeCache.GetSnapshot("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := snapshot.GetVersion(resource.RuntimeType); got != "00000000000000000001-7" {
+		t.Errorf("expected version 00000000000000000001-7, got %q", got)
+	}
+	if _, ok := snapshot.GetResources(resource.RuntimeType)["envoy-runtime"]; !ok {
+		t.Errorf("expected the envoy-runtime layer, got %v", snapshot.GetResources(resource.RuntimeType))
+	}
+}
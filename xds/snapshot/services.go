@@ -14,10 +14,10 @@ import (
 	routerv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/router/v3"
 	managerv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
-	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
 	consulApi "github.com/hashicorp/consul/api"
 	memdb "github.com/hashicorp/go-memdb"
+	"github.com/nebucloud/pkg/logger"
 	"github.com/nebucloud/pkg/xds/meter"
 	"github.com/nebucloud/pkg/xds/snapshot/apigateway"
 	"go.opentelemetry.io/otel/metric"
@@ -30,119 +30,278 @@ import (
 )
 
 func (s *Snapshotter) startServices(ctx context.Context, memdb *memdb.MemDB, edgedb *edgedb.Client, consulClient *consulApi.Client) error {
-	emit := func() {
-		s.logger.Warnf("emit before ready")
-	}
+	return s.runServices(ctx, &servicesLoop{
+		memdb:  memdb,
+		edgedb: edgedb,
+		consul: consulClient.Agent(),
+	})
+}
 
-	store := k8scache.NewUndeltaStore(func(v []interface{}) {
-		emit()
-	}, k8scache.DeletionHandlingMetaNamespaceKeyFunc)
+// runServices watches the services and emits them until ctx is done.
+func (s *Snapshotter) runServices(ctx context.Context, loop *servicesLoop) error {
+	emits := &emitter{}
+	store := s.newEmitStore(s.coalesce(ctx, emits.emit))
 
 	reflector := k8scache.NewReflector(&k8scache.ListWatch{
 		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
-			// Check if services are cached in MemDB
-			txn := memdb.Txn(false)
-			defer txn.Abort()
-			iter, err := txn.Get("services", "id")
-			if err != nil {
-				return nil, err
+			list, err := s.client.CoreV1().Services("").List(ctx, options)
+			if err == nil {
+				return list, nil
 			}
-			var services []corev1.Service
-			for obj := iter.Next(); obj != nil; obj = iter.Next() {
-				service := obj.(*corev1.Service)
-				services = append(services, *service)
-			}
-			if len(services) > 0 {
-				return &corev1.ServiceList{Items: services}, nil
+			// Fall back to the services cached in MemDB while the API fails
+			cached, cacheErr := cachedServices(loop.memdb)
+			if cacheErr != nil || len(cached.Items) == 0 {
+				return nil, err
 			}
-
-			// If services are not cached, fetch them from Kubernetes
-			return s.client.CoreV1().Services("").List(ctx, options)
+			s.logger.Warnf("Failed to list services, serving the %d cached in MemDB: %v", len(cached.Items), err)
+			return cached, nil
 		},
 		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
 			return s.client.CoreV1().Services("").Watch(ctx, options)
 		},
 	}, &corev1.Service{}, store, s.ResyncPeriod)
 
-	var lastSnapshotHash uint64
-
-	emit = func() {
-		version := reflector.LastSyncResourceVersion()
-		s.kubeEventCounter.Add(ctx, 1, metric.WithAttributes(meter.ResourceAttrKey.String("services")))
-
-		services := sliceToService(store.List())
-
-		// Persist services in EdgeDB
-		for _, svc := range services {
-			err := edgedb.QuerySingle(ctx, `
-				INSERT Service {
-					name := <str>$name,
-					namespace := <str>$namespace,
-					// Add other service fields as needed
-				}
-			`, map[string]interface{}{
-				"name":      svc.Name,
-				"namespace": svc.Namespace,
-			})
-			if err != nil {
-				s.logger.Errorf("Failed to persist service in EdgeDB: %v", err)
+	emits.activate(func() {
+		s.emitServices(ctx, loop, reflector.LastSyncResourceVersion(), sliceToService(store.List()))
+	})
+
+	s.trackReflector("services", reflector)
+	reflector.Run(ctx.Done())
+	return nil
+}
+
+// servicesLoop holds the dependencies and state of the services reconciliation loop.
+type servicesLoop struct {
+	memdb  *memdb.MemDB
+	edgedb EdgeDBQuerier
+	consul ConsulRegistrar
+
+	lastSnapshotHash uint64
+}
+
+// emitServices mirrors services to the integrations and publishes their
+// resources to the services cache. Every line it logs carries the same emit ID.
+func (s *Snapshotter) emitServices(ctx context.Context, loop *servicesLoop, version string, services []*corev1.Service) {
+	log := s.logger.With("emit_id", newEmitID())
+	s.emitCount.Add(1)
+	s.kubeEventCounter.Add(ctx, 1, metric.WithAttributes(meter.ResourceAttrKey.String("services")))
+
+	// Persist services in EdgeDB
+	for _, svc := range services {
+		err := loop.edgedb.Execute(ctx, `
+			INSERT Service {
+				name := <str>$name,
+				namespace := <str>$namespace,
+				// Add other service fields as needed
 			}
+		`, map[string]interface{}{
+			"name":      svc.Name,
+			"namespace": svc.Namespace,
+		})
+		s.recordOperation(ctx, "services", operationEdgeDBPersist, err)
+		if err != nil {
+			s.emitErrorf(log.WithObject(svc), "Failed to persist service in EdgeDB: %v", err)
 		}
+	}
 
-		// Register services with Consul
-		for _, svc := range services {
-			registration := &consulApi.AgentServiceRegistration{
-				ID:      fmt.Sprintf("%s-%s", svc.Name, svc.Namespace),
-				Name:    svc.Name,
-				Address: svc.Spec.ClusterIP,
-				// Add other service metadata as needed
-			}
-			err := consulClient.Agent().ServiceRegister(registration)
-			if err != nil {
-				s.logger.Errorf("Failed to register service with Consul: %v", err)
-			}
+	// Register services with Consul
+	for _, svc := range services {
+		registration := &consulApi.AgentServiceRegistration{
+			ID:      fmt.Sprintf("%s-%s", svc.Name, svc.Namespace),
+			Name:    svc.Name,
+			Address: svc.Spec.ClusterIP,
+			// Add other service metadata as needed
 		}
+		err := loop.consul.ServiceRegister(registration)
+		s.recordOperation(ctx, "services", operationConsulRegister, err)
+		if err != nil {
+			s.emitErrorf(log.WithObject(svc), "Failed to register service with Consul: %v", err)
+		}
+	}
 
-		resources := kubeServicesToResources(services)
-		apiGatewayResources, apiGatewayStats := apigateway.FromKubeServices(services, s.logger)
-		merged := append(resources, apiGatewayResources...)
+	s.cacheServices(log, loop.memdb, services)
 
-		resourcesByType := resourcesToMap(merged)
-		s.setServiceResourcesByType(resourcesByType)
-		s.setAPIGatewayStats(apiGatewayStats)
+	s.auditAnnotations(ctx, log, services)
+	merged, apiGatewayStats := s.servicesToResources(services)
 
-		hash, err := resourcesHash(merged)
-		if err == nil {
-			if hash == lastSnapshotHash {
-				s.logger.Debugf("new snapshot is equivalent to the previous one")
-				return
-			}
-			lastSnapshotHash = hash
-		} else {
-			s.logger.Errorf("fail to hash snapshot: %s", err)
+	resourcesByType := resourcesToMap(merged)
+	if !s.withinResourceLimits(ctx, log, resourcesByType) {
+		return
+	}
+	s.setServiceResourcesByType(resourcesByType)
+	s.setAPIGatewayStats(apiGatewayStats)
+
+	hash, err := resourcesHash(merged)
+	if err == nil {
+		if hash == loop.lastSnapshotHash {
+			log.Debugf("new snapshot is equivalent to the previous one")
+			return
 		}
+		loop.lastSnapshotHash = hash
+	} else {
+		s.emitErrorf(log, "fail to hash snapshot: %s", err)
+	}
 
-		snapshot, err := cache.NewSnapshot(version, resourcesByType)
-		if err != nil {
-			panic(err)
+	version = s.nextVersion("services", version)
+	s.publish(ctx, "services", version, resourcesByType)
+	log.Debugf("set services snapshot version %s hash %x", version, hash)
+
+	err = s.persistSnapshot(ctx, loop.edgedb, "services", version, merged)
+	s.recordOperation(ctx, "services", operationEdgeDBPersist, err)
+	if err != nil {
+		s.emitErrorf(log, "Failed to persist services snapshot in EdgeDB: %v", err)
+	}
+}
+
+// cachedServices returns the services cached in db.
+func cachedServices(db *memdb.MemDB) (*corev1.ServiceList, error) {
+	txn := db.Txn(false)
+	defer txn.Abort()
+	iter, err := txn.Get("services", "id")
+	if err != nil {
+		return nil, err
+	}
+	list := &corev1.ServiceList{}
+	for obj := iter.Next(); obj != nil; obj = iter.Next() {
+		list.Items = append(list.Items, *obj.(*corev1.Service))
+	}
+	return list, nil
+}
+
+// cacheServices replaces the services cached in db with services, so a
+// deleted service is not listed again from the cache.
+func (s *Snapshotter) cacheServices(log *logger.Klogger, db *memdb.MemDB, services []*corev1.Service) {
+	txn := db.Txn(true)
+	if _, err := txn.DeleteAll("services", "id"); err != nil {
+		txn.Abort()
+		s.emitErrorf(log, "Failed to clear services cached in MemDB: %v", err)
+		return
+	}
+	for _, svc := range services {
+		if err := txn.Insert("services", svc); err != nil {
+			txn.Abort()
+			s.emitErrorf(log.WithObject(svc), "Failed to cache service in MemDB: %v", err)
+			return
 		}
+	}
+	txn.Commit()
+}
 
-		s.servicesCache.SetSnapshot(ctx, "", snapshot)
+// serviceCacheItem holds the resources generated for a version of a service
+type serviceCacheItem struct {
+	version  string
+	bareName bool
+	// mirrored is set when the mirror cluster annotated exists
+	mirrored  bool
+	resources []types.Resource
+	routes    []apigateway.Route
+}
 
-		// Cache services in MemDB
-		txn := memdb.Txn(true)
-		for _, svc := range services {
-			if err := txn.Insert("services", svc); err != nil {
-				txn.Abort()
-				s.logger.Errorf("Failed to cache service in MemDB: %v", err)
-				return
-			}
+// servicesToResources converts services to every resource the snapshotter is
+// configured to serve, along with the api gateway stats. Only the services
+// changed since the previous call are converted again, the resources of the
+// others are reused. Egress listeners, whose ports depend on every service,
+// and the api gateways, merging the routes of many services, are assembled
+// on every call.
+func (s *Snapshotter) servicesToResources(services []*corev1.Service) ([]types.Resource, map[string]int) {
+	s.serviceResourceLock.Lock()
+	defer s.serviceResourceLock.Unlock()
+
+	router, _ := anypb.New(&routerv3.Router{})
+	namespacesByName := countNamespacesByName(services, s.logger)
+	clusters := serviceClusterNames(services)
+	cache := make(map[string]serviceCacheItem, len(services))
+	var (
+		resources []types.Resource
+		routes    []apigateway.Route
+	)
+	for _, svc := range services {
+		key := svc.Namespace + "/" + svc.Name
+		bareName := namespacesByName[svc.Name] == 1
+		mirrored := clusters[svc.Annotations[MirrorClusterAnnotation]]
+		item, ok := s.serviceResourceCache[key]
+		if !ok || svc.ResourceVersion == "" || item.version != svc.ResourceVersion || item.bareName != bareName || item.mirrored != mirrored {
+			item = s.serviceToResources(svc, bareName, clusters, router)
 		}
-		txn.Commit()
+		cache[key] = item
+		resources = append(resources, item.resources...)
+		routes = append(routes, item.routes...)
 	}
+	s.serviceResourceCache = cache
 
-	reflector.Run(ctx.Done())
-	return nil
+	if s.egressListeners {
+		egress := kubeServicesToEgressListeners(services, s.egressPorts, s.logger)
+		s.applyEgressFilterChainMatch(egress)
+		s.applyEgressReusePort(egress)
+		s.applyListenerDrainType(egress)
+		resources = append(resources, egress...)
+	}
+	if !s.apiGateway {
+		return resources, map[string]int{}
+	}
+	apiGatewayResources, apiGatewayStats := apigateway.FromRoutes(routes, s.gatewayCatchAll)
+	s.applyListenerDrainType(apiGatewayResources)
+	return append(resources, apiGatewayResources...), apiGatewayStats
+}
+
+// serviceToResources converts svc to its resources with the snapshotter wide
+// cluster settings applied, and to its api gateway routes if enabled,
+// clusters holding the cluster names of the converted services.
+func (s *Snapshotter) serviceToResources(svc *corev1.Service, bareName bool, clusters map[string]bool, router *anypb.Any) serviceCacheItem {
+	mirror, err := serviceMirror(svc, clusters)
+	if err != nil {
+		s.logger.WithObject(svc).Warnf("Invalid mirror annotations: %s", err)
+	}
+	headers, err := serviceHeaderActions(svc)
+	if err != nil {
+		s.logger.WithObject(svc).Warnf("Invalid header annotations: %s", err)
+	}
+	resources := kubeServiceToResources(svc, bareName, mirror, headers, router)
+	s.applyClusterDefaults(resources)
+	s.applyClusterType(svc, resources)
+	s.applyTCPKeepalive([]*corev1.Service{svc}, resources)
+	s.applyListenerDrainType(resources)
+	item := serviceCacheItem{version: svc.ResourceVersion, bareName: bareName, mirrored: clusters[svc.Annotations[MirrorClusterAnnotation]], resources: resources}
+	if s.apiGateway {
+		item.routes = apigateway.RoutesFromKubeService(svc, s.logger)
+	}
+	return item
+}
+
+// setServicesSnapshot publishes resourcesByType to the services cache, along
+// with the adjusted resources for legacy Envoy nodes when version gating is on.
+func (s *Snapshotter) setServicesSnapshot(ctx context.Context, version string, resourcesByType map[string][]types.Resource) error {
+	snapshot, err := s.newSnapshot(version, resourcesByType)
+	if err != nil {
+		panic(err)
+	}
+
+	if err := s.servicesCache.SetSnapshot(ctx, "", snapshot); err != nil {
+		return err
+	}
+
+	if !s.versionGating {
+		return nil
+	}
+	legacySnapshot, err := s.newSnapshot(version, legacyResources(resourcesByType))
+	if err != nil {
+		panic(err)
+	}
+
+	return s.servicesCache.SetSnapshot(ctx, legacyNodeGroup, legacySnapshot)
+}
+
+// applyClusterDefaults sets the snapshotter wide cluster settings on every cluster of resources.
+func (s *Snapshotter) applyClusterDefaults(resources []types.Resource) {
+	for _, r := range resources {
+		c, ok := r.(*clusterv3.Cluster)
+		if !ok {
+			continue
+		}
+		if s.upstreamBindConfig != nil {
+			c.UpstreamBindConfig = s.upstreamBindConfig
+		}
+	}
 }
 
 func sliceToService(s []interface{}) []*corev1.Service {
@@ -157,75 +316,111 @@ func sliceToService(s []interface{}) []*corev1.Service {
 // - Listener for each ports
 // - RouteConfiguration for those listeners
 // - Cluster
-func kubeServicesToResources(services []*corev1.Service) []types.Resource {
+// The bare service name is only used as a domain when it is unique across namespaces.
+func kubeServicesToResources(services []*corev1.Service, logger *logger.Klogger) []types.Resource {
 	var out []types.Resource
 
 	router, _ := anypb.New(&routerv3.Router{})
 
+	namespacesByName := countNamespacesByName(services, logger)
 	for _, svc := range services {
-		fullName := fmt.Sprintf("%s.%s", svc.Name, svc.Namespace)
-		for _, port := range svc.Spec.Ports {
-			targetHostPort := net.JoinHostPort(fullName, port.Name)
-			targetHostPortNumber := net.JoinHostPort(fullName, strconv.Itoa(int(port.Port)))
-			routeConfig := &routev3.RouteConfiguration{
-				Name: targetHostPortNumber,
-				VirtualHosts: []*routev3.VirtualHost{
-					{
-						Name:    targetHostPort,
-						Domains: []string{fullName, targetHostPort, targetHostPortNumber, svc.Name},
-						Routes: []*routev3.Route{{
-							Name: "default",
-							Match: &routev3.RouteMatch{
-								PathSpecifier: &routev3.RouteMatch_Prefix{},
-							},
-							Action: &routev3.Route_Route{
-								Route: &routev3.RouteAction{
-									ClusterSpecifier: &routev3.RouteAction_Cluster{
-										Cluster: targetHostPort,
-									},
-								},
-							},
-						}},
-					},
-				},
-			}
+		out = append(out, kubeServiceToResources(svc, namespacesByName[svc.Name] == 1, mirrorPolicy{}, headerActions{}, router)...)
+	}
+
+	return out
+}
+
+// countNamespacesByName returns the number of namespaces each service name
+// exists in, warning about the ambiguous names.
+func countNamespacesByName(services []*corev1.Service, logger *logger.Klogger) map[string]int {
+	namespacesByName := map[string]int{}
+	for _, svc := range services {
+		namespacesByName[svc.Name]++
+	}
+	for name, count := range namespacesByName {
+		if count > 1 {
+			logger.Warnf("Service name %s exists in %d namespaces, omitting the ambiguous bare name domain", name, count)
+		}
+	}
+	return namespacesByName
+}
 
-			manager, _ := anypb.New(&managerv3.HttpConnectionManager{
-				HttpFilters: []*managerv3.HttpFilter{
-					{
-						Name: wellknown.Router,
-						ConfigType: &managerv3.HttpFilter_TypedConfig{
-							TypedConfig: router,
-						},
+// kubeServiceToResources converts svc to its listeners, route configurations
+// and clusters, using the bare service name as a domain if bareName is set
+// and mirroring its routes and manipulating their headers as set by mirror
+// and headers.
+func kubeServiceToResources(svc *corev1.Service, bareName bool, mirror mirrorPolicy, headers headerActions, router *anypb.Any) []types.Resource {
+	var out []types.Resource
+
+	fullName := fmt.Sprintf("%s.%s", svc.Name, svc.Namespace)
+	for _, port := range svc.Spec.Ports {
+		targetHostPort := net.JoinHostPort(fullName, port.Name)
+		targetHostPortNumber := net.JoinHostPort(fullName, strconv.Itoa(int(port.Port)))
+		domains := []string{fullName, targetHostPort, targetHostPortNumber}
+		if bareName {
+			domains = append(domains, svc.Name)
+		}
+		route := &routev3.Route{
+			Name: "default",
+			Match: &routev3.RouteMatch{
+				PathSpecifier: &routev3.RouteMatch_Prefix{},
+			},
+			Action: &routev3.Route_Route{
+				Route: &routev3.RouteAction{
+					ClusterSpecifier: &routev3.RouteAction_Cluster{
+						Cluster: targetHostPort,
 					},
+					RequestMirrorPolicies: mirror.toProto(),
 				},
-				RouteSpecifier: &managerv3.HttpConnectionManager_RouteConfig{
-					RouteConfig: routeConfig,
+			},
+		}
+		headers.apply(route)
+		routeConfig := &routev3.RouteConfiguration{
+			Name: targetHostPortNumber,
+			VirtualHosts: []*routev3.VirtualHost{
+				{
+					Name:    targetHostPort,
+					Domains: domains,
+					Routes:  []*routev3.Route{route},
 				},
-			})
+			},
+		}
 
-			svcListener := &listenerv3.Listener{
-				Name: targetHostPortNumber,
-				ApiListener: &listenerv3.ApiListener{
-					ApiListener: manager,
+		manager, _ := anypb.New(&managerv3.HttpConnectionManager{
+			HttpFilters: []*managerv3.HttpFilter{
+				{
+					Name: wellknown.Router,
+					ConfigType: &managerv3.HttpFilter_TypedConfig{
+						TypedConfig: router,
+					},
 				},
-			}
+			},
+			RouteSpecifier: &managerv3.HttpConnectionManager_RouteConfig{
+				RouteConfig: routeConfig,
+			},
+		})
 
-			svcCluster := &clusterv3.Cluster{
-				Name:                 targetHostPort,
-				ClusterDiscoveryType: &clusterv3.Cluster_Type{Type: clusterv3.Cluster_EDS},
-				LbPolicy:             clusterv3.Cluster_ROUND_ROBIN,
-				EdsClusterConfig: &clusterv3.Cluster_EdsClusterConfig{
-					EdsConfig: &corev3.ConfigSource{
-						ConfigSourceSpecifier: &corev3.ConfigSource_Ads{
-							Ads: &corev3.AggregatedConfigSource{},
-						},
+		svcListener := &listenerv3.Listener{
+			Name: targetHostPortNumber,
+			ApiListener: &listenerv3.ApiListener{
+				ApiListener: manager,
+			},
+		}
+
+		svcCluster := &clusterv3.Cluster{
+			Name:                 targetHostPort,
+			ClusterDiscoveryType: &clusterv3.Cluster_Type{Type: clusterv3.Cluster_EDS},
+			LbPolicy:             clusterv3.Cluster_ROUND_ROBIN,
+			EdsClusterConfig: &clusterv3.Cluster_EdsClusterConfig{
+				EdsConfig: &corev3.ConfigSource{
+					ConfigSourceSpecifier: &corev3.ConfigSource_Ads{
+						Ads: &corev3.AggregatedConfigSource{},
 					},
 				},
-			}
-
-			out = append(out, svcListener, routeConfig, svcCluster)
+			},
 		}
+
+		out = append(out, svcListener, routeConfig, svcCluster)
 	}
 
 	return out
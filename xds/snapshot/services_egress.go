@@ -0,0 +1,142 @@
+package snapshot
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	tcpproxyv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
+	"github.com/nebucloud/pkg/logger"
+	"google.golang.org/protobuf/types/known/anypb"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// EgressListenerPrefix prefixes the name of every egress socket listener
+	EgressListenerPrefix = "egress/"
+	// EgressBindAddress is the address egress socket listeners bind to
+	EgressBindAddress = "127.0.0.1"
+
+	maxPort = 65535
+)
+
+// egressPorts assigns the listen port of every egress listener, by name,
+// keeping it for as long as the listener exists so that a local client
+// dialing the port keeps reaching the same upstream.
+type egressPorts struct {
+	base  uint32
+	ports map[string]uint32
+}
+
+func newEgressPorts(base uint32) *egressPorts {
+	return &egressPorts{base: base, ports: map[string]uint32{}}
+}
+
+// assign returns the ports of names, released for the listeners not named
+// anymore. A new name gets the lowest free port from base, in names order,
+// and none once the port range is exhausted.
+func (p *egressPorts) assign(names []string) map[string]uint32 {
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+	used := make(map[uint32]bool, len(p.ports))
+	for name, port := range p.ports {
+		if !wanted[name] {
+			delete(p.ports, name)
+			continue
+		}
+		used[port] = true
+	}
+	next := p.base
+	for _, name := range names {
+		if _, ok := p.ports[name]; ok {
+			continue
+		}
+		for next <= maxPort && used[next] {
+			next++
+		}
+		if next > maxPort {
+			continue
+		}
+		p.ports[name] = next
+		used[next] = true
+	}
+	return p.ports
+}
+
+// kubeServicesToEgressListeners convert list of Kubernetes services to
+// socket listeners, one per service port, proxying TCP to the service cluster.
+// Their listen ports are assigned by ports, in namespace/name/port order for
+// the new ones.
+func kubeServicesToEgressListeners(services []*corev1.Service, ports *egressPorts, logger *logger.Klogger) []types.Resource {
+	sorted := make([]*corev1.Service, len(services))
+	copy(sorted, services)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Namespace != sorted[j].Namespace {
+			return sorted[i].Namespace < sorted[j].Namespace
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	var names []string
+	for _, svc := range sorted {
+		fullName := fmt.Sprintf("%s.%s", svc.Name, svc.Namespace)
+		for _, port := range svc.Spec.Ports {
+			names = append(names, EgressListenerPrefix+net.JoinHostPort(fullName, strconv.Itoa(int(port.Port))))
+		}
+	}
+	assigned := ports.assign(names)
+
+	var out []types.Resource
+
+	for _, svc := range sorted {
+		fullName := fmt.Sprintf("%s.%s", svc.Name, svc.Namespace)
+		for _, port := range svc.Spec.Ports {
+			targetHostPort := net.JoinHostPort(fullName, port.Name)
+			targetHostPortNumber := net.JoinHostPort(fullName, strconv.Itoa(int(port.Port)))
+			listenPort, ok := assigned[EgressListenerPrefix+targetHostPortNumber]
+			if !ok {
+				logger.Warnf("Service %s/%s egress listener skipped: port range exhausted from %d", svc.Namespace, svc.Name, ports.base)
+				continue
+			}
+
+			tcpProxy, _ := anypb.New(&tcpproxyv3.TcpProxy{
+				StatPrefix: targetHostPort,
+				ClusterSpecifier: &tcpproxyv3.TcpProxy_Cluster{
+					Cluster: targetHostPort,
+				},
+			})
+
+			out = append(out, &listenerv3.Listener{
+				Name: EgressListenerPrefix + targetHostPortNumber,
+				Address: &corev3.Address{
+					Address: &corev3.Address_SocketAddress{
+						SocketAddress: &corev3.SocketAddress{
+							Protocol: corev3.SocketAddress_TCP,
+							Address:  EgressBindAddress,
+							PortSpecifier: &corev3.SocketAddress_PortValue{
+								PortValue: listenPort,
+							},
+						},
+					},
+				},
+				FilterChains: []*listenerv3.FilterChain{{
+					Filters: []*listenerv3.Filter{{
+						Name: wellknown.TCPProxy,
+						ConfigType: &listenerv3.Filter_TypedConfig{
+							TypedConfig: tcpProxy,
+						},
+					}},
+				}},
+			})
+		}
+	}
+
+	return out
+}
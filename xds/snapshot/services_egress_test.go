@@ -0,0 +1,128 @@
+package snapshot
+
+import (
+	"fmt"
+	"maps"
+	"testing"
+
+	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	tcpproxyv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
+	"github.com/nebucloud/pkg/logger"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestKubeServicesToEgressListeners(t *testing.T) {
+	services := []*corev1.Service{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "b"},
+			Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{
+				{Name: "http", Port: 80},
+				{Name: "grpc", Port: 9090},
+			}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "a"},
+			Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "pg", Port: 5432}}},
+		},
+	}
+
+	resources := kubeServicesToEgressListeners(services, newEgressPorts(15000), logger.Singleton())
+	want := []struct {
+		name    string
+		port    uint32
+		cluster string
+	}{
+		{"egress/db.a:5432", 15000, "db.a:pg"},
+		{"egress/web.b:80", 15001, "web.b:http"},
+		{"egress/web.b:9090", 15002, "web.b:grpc"},
+	}
+	if len(resources) != len(want) {
+		t.Fatalf("expected %d listeners, got %d", len(want), len(resources))
+	}
+	for i, w := range want {
+		l := resources[i].(*listenerv3.Listener)
+		if l.Name != w.name {
+			t.Errorf("listener %d: expected name %s, got %s", i, w.name, l.Name)
+		}
+		addr := l.GetAddress().GetSocketAddress()
+		if addr.GetAddress() != EgressBindAddress || addr.GetPortValue() != w.port {
+			t.Errorf("listener %s: expected %s:%d, got %s:%d", l.Name, EgressBindAddress, w.port, addr.GetAddress(), addr.GetPortValue())
+		}
+		if l.ApiListener != nil {
+			t.Errorf("listener %s: unexpected api listener", l.Name)
+		}
+		filter := l.GetFilterChains()[0].GetFilters()[0]
+		if filter.Name != wellknown.TCPProxy {
+			t.Errorf("listener %s: expected filter %s, got %s", l.Name, wellknown.TCPProxy, filter.Name)
+		}
+		tcpProxy := &tcpproxyv3.TcpProxy{}
+		if err := filter.GetTypedConfig().UnmarshalTo(tcpProxy); err != nil {
+			t.Fatal(err)
+		}
+		if tcpProxy.GetCluster() != w.cluster {
+			t.Errorf("listener %s: expected cluster %s, got %s", l.Name, w.cluster, tcpProxy.GetCluster())
+		}
+	}
+}
+
+func TestKubeServicesToEgressListenersPortExhausted(t *testing.T) {
+	services := []*corev1.Service{{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{
+			{Name: "http", Port: 80},
+			{Name: "https", Port: 443},
+		}},
+	}}
+
+	resources := kubeServicesToEgressListeners(services, newEgressPorts(maxPort), logger.Singleton())
+	if len(resources) != 1 {
+		t.Fatalf("expected 1 listener, got %d", len(resources))
+	}
+}
+
+// listenPorts returns the listen port of each egress listener by name
+func listenPorts(resources []types.Resource) map[string]uint32 {
+	out := map[string]uint32{}
+	for _, r := range resources {
+		l := r.(*listenerv3.Listener)
+		out[l.Name] = l.GetAddress().GetSocketAddress().GetPortValue()
+	}
+	return out
+}
+
+func TestKubeServicesToEgressListenersStablePorts(t *testing.T) {
+	service := func(namespace, name string, ports ...int32) *corev1.Service {
+		svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+		for _, port := range ports {
+			svc.Spec.Ports = append(svc.Spec.Ports, corev1.ServicePort{Name: fmt.Sprintf("p%d", port), Port: port})
+		}
+		return svc
+	}
+	ports := newEgressPorts(15000)
+	web, api := service("b", "web", 80, 443), service("c", "api", 8080)
+
+	before := listenPorts(kubeServicesToEgressListeners([]*corev1.Service{web, api}, ports, logger.Singleton()))
+
+	// a service sorting first is added, the existing ports are kept
+	db := service("a", "db", 5432)
+	after := listenPorts(kubeServicesToEgressListeners([]*corev1.Service{web, api, db}, ports, logger.Singleton()))
+	for name, port := range before {
+		if after[name] != port {
+			t.Errorf("listener %s: expected port %d kept, got %d", name, port, after[name])
+		}
+	}
+	if got := after["egress/db.a:5432"]; got != 15003 {
+		t.Errorf("expected the new service on the next free port 15003, got %d", got)
+	}
+
+	// web is deleted, the others keep their port and a new one reuses the freed ones
+	cache := service("d", "cache", 6379)
+	last := listenPorts(kubeServicesToEgressListeners([]*corev1.Service{api, db, cache}, ports, logger.Singleton()))
+	want := map[string]uint32{"egress/api.c:8080": before["egress/api.c:8080"], "egress/db.a:5432": 15003, "egress/cache.d:6379": 15000}
+	if !maps.Equal(last, want) {
+		t.Errorf("expected %v, got %v", want, last)
+	}
+}
@@ -0,0 +1,931 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/edgedb/edgedb-go"
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	consulApi "github.com/hashicorp/consul/api"
+	"github.com/nebucloud/pkg/logger"
+	"github.com/nebucloud/pkg/xds/meter"
+	"github.com/nebucloud/pkg/xds/snapshot/apigateway"
+	"google.golang.org/protobuf/proto"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newTestSnapshotter returns a Snapshotter without any client or background loop.
+func newTestSnapshotter(opts ...Option) *Snapshotter {
+	return newSnapshotter(nil, logger.Singleton(), opts...)
+}
+
+func gatewayService(name, namespace, gateway string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Annotations: map[string]string{
+				apigateway.NameAnnotation:    gateway,
+				apigateway.ServiceAnnotation: "pkg.Service",
+			},
+		},
+		Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: apigateway.PortName, Port: 9090}}},
+	}
+}
+
+func findListener(resources []types.Resource, name string) *listenerv3.Listener {
+	for _, r := range resources {
+		if l, ok := r.(*listenerv3.Listener); ok && l.Name == name {
+			return l
+		}
+	}
+	return nil
+}
+
+func TestServicesToResourcesApiGateway(t *testing.T) {
+	services := []*corev1.Service{gatewayService("api", "default", "public")}
+
+	resources, stats := newTestSnapshotter().servicesToResources(services)
+	if findListener(resources, "public") == nil {
+		t.Errorf("expected gateway listener when enabled")
+	}
+	if stats["public"] != 1 {
+		t.Errorf("expected 1 gateway route, got %d", stats["public"])
+	}
+
+	resources, stats = newTestSnapshotter(WithApiGateway(false)).servicesToResources(services)
+	if findListener(resources, "public") != nil {
+		t.Errorf("unexpected gateway listener when disabled")
+	}
+	if len(stats) != 0 {
+		t.Errorf("expected no gateway stats when disabled, got %v", stats)
+	}
+	if findListener(resources, "api.default:9090") == nil {
+		t.Errorf("expected service listener regardless of gateway")
+	}
+}
+
+// fakeEdgeDB records the arguments of the commands it executes, failing
+// like EdgeDB those not given as a single map naming every $parameter.
+type fakeEdgeDB struct {
+	err      error
+	calls    int
+	executed []map[string]interface{}
+}
+
+var edgeqlParameter = regexp.MustCompile(`\$(\w+)`)
+
+func (f *fakeEdgeDB) arguments(cmd string, args []interface{}) (map[string]interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("expected the named arguments as a single map, got %d arguments", len(args))
+	}
+	named, ok := args[0].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected the named arguments as a map, got %T", args[0])
+	}
+	for _, m := range edgeqlParameter.FindAllStringSubmatch(cmd, -1) {
+		if _, ok := named[m[1]]; !ok {
+			return nil, fmt.Errorf("missing argument $%s", m[1])
+		}
+	}
+	return named, nil
+}
+
+func (f *fakeEdgeDB) Execute(ctx context.Context, cmd string, args ...interface{}) error {
+	f.calls++
+	if f.err != nil {
+		return f.err
+	}
+	named, err := f.arguments(cmd, args)
+	if err != nil {
+		return err
+	}
+	f.executed = append(f.executed, named)
+	return nil
+}
+
+// QuerySingle decodes into a persistedSnapshot the last snapshot executed
+// with the same cache argument.
+func (f *fakeEdgeDB) QuerySingle(ctx context.Context, cmd string, out interface{}, args ...interface{}) error {
+	f.calls++
+	if f.err != nil {
+		return f.err
+	}
+	named, err := f.arguments(cmd, args)
+	if err != nil {
+		return err
+	}
+	row, ok := out.(*persistedSnapshot)
+	if !ok {
+		return fmt.Errorf("unexpected decode target %T", out)
+	}
+	for i := len(f.executed) - 1; i >= 0; i-- {
+		if data, ok := f.executed[i]["resources"].([]byte); ok && f.executed[i]["cache"] == named["cache"] {
+			row.Version, _ = f.executed[i]["version"].(string)
+			row.Resources = data
+			return nil
+		}
+	}
+	return fakeNoDataError{}
+}
+
+// fakeNoDataError is the error of EdgeDB for a QuerySingle without result.
+type fakeNoDataError struct{}
+
+func (fakeNoDataError) Error() string                        { return "zero results" }
+func (fakeNoDataError) Unwrap() error                        { return nil }
+func (fakeNoDataError) HasTag(edgedb.ErrorTag) bool          { return false }
+func (fakeNoDataError) Category(c edgedb.ErrorCategory) bool { return c == edgedb.NoDataError }
+
+type fakeConsul struct {
+	err   error
+	calls int
+}
+
+func (f *fakeConsul) ServiceRegister(service *consulApi.AgentServiceRegistration) error {
+	f.calls++
+	return f.err
+}
+
+func TestEmitServicesCorrelationID(t *testing.T) {
+	s := newTestSnapshotter()
+	handler := logger.NewMemoryHandler()
+	s.logger = &logger.Klogger{}
+	s.logger.SetLogger(slog.New(handler))
+
+	memdb, err := s.createMemDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loop := &servicesLoop{
+		memdb:  memdb,
+		edgedb: &fakeEdgeDB{err: errors.New("edgedb unavailable")},
+		consul: &fakeConsul{err: errors.New("consul unavailable")},
+	}
+	services := []*corev1.Service{gatewayService("api", "default", "public")}
+
+	s.emitServices(context.Background(), loop, "1", services)
+	first := handler.Records()
+	if len(first) < 3 {
+		t.Fatalf("expected persistence, registration and snapshot lines, got %v", first)
+	}
+	emitID, _ := first[0].Attrs["emit_id"].(string)
+	if emitID == "" {
+		t.Fatalf("expected emit_id on %v", first[0])
+	}
+	if first[0].Attrs["namespace"] != "default" || first[0].Attrs["name"] != "api" {
+		t.Errorf("expected the service reference on %q, got %v", first[0].Message, first[0].Attrs)
+	}
+	for _, r := range first {
+		if r.Attrs["emit_id"] != emitID {
+			t.Errorf("expected emit_id %s, got %v on %q", emitID, r.Attrs["emit_id"], r.Message)
+		}
+	}
+
+	s.emitServices(context.Background(), loop, "2", services)
+	second := handler.Records()[len(first):]
+	if len(second) == 0 {
+		t.Fatal("expected lines from the second emit")
+	}
+	if second[0].Attrs["emit_id"] == emitID {
+		t.Errorf("expected a new emit_id for the second emit")
+	}
+}
+
+func TestEmitServicesIntegrationMetrics(t *testing.T) {
+	reader := installTestMeterReader(t)
+	s := newTestSnapshotter()
+
+	memdb, err := s.createMemDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	edgedb := &fakeEdgeDB{}
+	consul := &fakeConsul{err: errors.New("consul unavailable")}
+	loop := &servicesLoop{memdb: memdb, edgedb: edgedb, consul: consul}
+	services := []*corev1.Service{
+		gatewayService("api", "default", "public"),
+		gatewayService("web", "default", "public"),
+	}
+
+	s.emitServices(context.Background(), loop, "1", services)
+
+	tests := []struct {
+		operation string
+		outcome   string
+		want      int64
+	}{
+		{operationEdgeDBPersist, meter.OutcomeSuccess, 3},
+		{operationEdgeDBPersist, meter.OutcomeError, 0},
+		{operationConsulRegister, meter.OutcomeSuccess, 0},
+		{operationConsulRegister, meter.OutcomeError, 2},
+	}
+	for _, tt := range tests {
+		got := counterValue(t, reader, "xds_integration_operations",
+			meter.ResourceAttrKey.String("services"),
+			meter.OperationAttrKey.String(tt.operation),
+			meter.OutcomeAttrKey.String(tt.outcome),
+		)
+		if got != tt.want {
+			t.Errorf("%s/%s: expected %d, got %d", tt.operation, tt.outcome, tt.want, got)
+		}
+	}
+}
+
+func findClusters(resources []types.Resource) []*clusterv3.Cluster {
+	var out []*clusterv3.Cluster
+	for _, r := range resources {
+		if c, ok := r.(*clusterv3.Cluster); ok {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func TestServicesToResourcesUpstreamBindConfig(t *testing.T) {
+	services := []*corev1.Service{{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{
+			{Name: "http", Port: 80},
+			{Name: "https", Port: 443},
+		}},
+	}}
+
+	resources, _ := newTestSnapshotter(WithUpstreamBindAddress("10.0.0.7")).servicesToResources(services)
+	clusters := findClusters(resources)
+	if len(clusters) != 2 {
+		t.Fatalf("expected 2 clusters, got %d", len(clusters))
+	}
+	for _, c := range clusters {
+		if got := c.GetUpstreamBindConfig().GetSourceAddress().GetAddress(); got != "10.0.0.7" {
+			t.Errorf("cluster %s: expected bind address 10.0.0.7, got %q", c.Name, got)
+		}
+	}
+
+	for _, opts := range [][]Option{nil, {WithUpstreamBindAddress("not-an-ip")}} {
+		resources, _ = newTestSnapshotter(opts...).servicesToResources(services)
+		for _, c := range findClusters(resources) {
+			if c.UpstreamBindConfig != nil {
+				t.Errorf("cluster %s: unexpected bind config %v", c.Name, c.UpstreamBindConfig)
+			}
+		}
+	}
+}
+
+func TestKubeServicesToResourcesAmbiguousNames(t *testing.T) {
+	service := func(name, namespace string) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "http", Port: 80}}},
+		}
+	}
+	services := []*corev1.Service{service("web", "a"), service("web", "b"), service("db", "a")}
+
+	domains := map[string][]string{}
+	for _, r := range kubeServicesToResources(services, logger.Singleton()) {
+		if rc, ok := r.(*routev3.RouteConfiguration); ok {
+			domains[rc.Name] = rc.VirtualHosts[0].Domains
+		}
+	}
+
+	want := map[string][]string{
+		"web.a:80": {"web.a", "web.a:http", "web.a:80"},
+		"web.b:80": {"web.b", "web.b:http", "web.b:80"},
+		"db.a:80":  {"db.a", "db.a:http", "db.a:80", "db"},
+	}
+	if !reflect.DeepEqual(domains, want) {
+		t.Errorf("expected domains %v, got %v", want, domains)
+	}
+}
+
+func TestEmitServicesSnapshotNotifier(t *testing.T) {
+	notified := 0
+	s := newTestSnapshotter(WithApiGateway(false), WithSnapshotNotifier(func() { notified++ }))
+
+	memdb, err := s.createMemDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loop := &servicesLoop{memdb: memdb, edgedb: &fakeEdgeDB{}, consul: &fakeConsul{}}
+	services := []*corev1.Service{{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{
+			{Name: "https", Port: 443},
+			{Name: "http", Port: 80},
+		}},
+	}}
+
+	s.emitServices(context.Background(), loop, "1", services)
+	s.emitServices(context.Background(), loop, "2", services)
+	if notified != 1 {
+		t.Errorf("expected one notification for an unchanged snapshot, got %d", notified)
+	}
+	if got, want := s.ClusterNames(), []string{"web.default:http", "web.default:https"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected clusters %v, got %v", want, got)
+	}
+}
+
+func TestEmitServicesStandby(t *testing.T) {
+	notified := 0
+	s := newTestSnapshotter(WithApiGateway(false), WithStandby(), WithSnapshotNotifier(func() { notified++ }))
+
+	memdb, err := s.createMemDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	edgedb := &fakeEdgeDB{}
+	loop := &servicesLoop{memdb: memdb, edgedb: edgedb, consul: &fakeConsul{}}
+	services := []*corev1.Service{{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "http", Port: 80}}},
+	}}
+
+	s.emitServices(context.Background(), loop, "1", services)
+	if edgedb.calls == 0 {
+		t.Errorf("expected a standby to persist its snapshot")
+	}
+	if _, err := s.servicesCache.GetSnapshot(""); err == nil {
+		t.Errorf("expected no services snapshot served while standby")
+	}
+	if notified != 0 {
+		t.Errorf("expected no notification while standby, got %d", notified)
+	}
+	if c, ok := s.Health(context.Background()).Caches["services"]; ok {
+		t.Errorf("expected no emit recorded while standby, got %+v", c)
+	}
+
+	s.Promote(context.Background())
+	if s.Standby() {
+		t.Errorf("expected the snapshotter to serve once promoted")
+	}
+	snapshot, err := s.servicesCache.GetSnapshot("")
+	if err != nil {
+		t.Fatalf("expected the pending snapshot served once promoted: %s", err)
+	}
+	if got := snapshot.GetVersion(resource.ClusterType); got != "00000000000000000001-1" {
+		t.Errorf("expected version 00000000000000000001-1, got %q", got)
+	}
+	if notified != 1 {
+		t.Errorf("expected one notification on promotion, got %d", notified)
+	}
+	if c := s.Health(context.Background()).Caches["services"]; c.Version != "00000000000000000001-1" {
+		t.Errorf("expected the emit of version 00000000000000000001-1 recorded once promoted, got %+v", c)
+	}
+}
+
+func TestEmitServicesResourceLimit(t *testing.T) {
+	reader := installTestMeterReader(t)
+	services := []*corev1.Service{{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{
+			{Name: "http", Port: 80},
+			{Name: "https", Port: 443},
+		}},
+	}}
+
+	tests := []struct {
+		name  string
+		limit int
+		set   bool
+	}{
+		{"within limit", 2, true},
+		{"exceeded limit", 1, false},
+		{"disabled limit", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSnapshotter(WithApiGateway(false), WithResourceLimit(resource.ClusterType, tt.limit))
+			memdb, err := s.createMemDB()
+			if err != nil {
+				t.Fatal(err)
+			}
+			loop := &servicesLoop{memdb: memdb, edgedb: &fakeEdgeDB{}, consul: &fakeConsul{}}
+
+			before := counterValue(t, reader, "xds_resource_limit_exceeded", meter.TypeURLAttrKey.String(resource.ClusterType))
+			s.emitServices(context.Background(), loop, "1", services)
+			after := counterValue(t, reader, "xds_resource_limit_exceeded", meter.TypeURLAttrKey.String(resource.ClusterType))
+
+			_, err = s.servicesCache.GetSnapshot("")
+			if set := err == nil; set != tt.set {
+				t.Errorf("expected snapshot set %v, got %v", tt.set, set)
+			}
+			if counted := after > before; counted == tt.set {
+				t.Errorf("expected limit exceeded counted %v, got %v", !tt.set, counted)
+			}
+		})
+	}
+}
+
+func TestAuditAnnotations(t *testing.T) {
+	s := newTestSnapshotter()
+	handler := logger.NewMemoryHandler()
+	log := &logger.Klogger{}
+	log.SetLogger(slog.New(handler))
+
+	svc := gatewayService("api", "default", "public")
+	svc.Annotations[TCPKeepaliveProbesAnnotation] = "4"
+	svc.Annotations[TCPKeepaliveIntervalAnnotation] = "soon"
+	svc.Annotations["xds.nebucloud.com/lb-policy"] = "ring-hash"
+	svc.Annotations["example.com/owner"] = "team"
+	svc.Annotations[ClusterTypeAnnotation] = "STRICT_DNS"
+	svc.Annotations[apigateway.PriorityAnnotation] = "5"
+	s.auditAnnotations(context.Background(), log, []*corev1.Service{svc, {ObjectMeta: metav1.ObjectMeta{Name: "plain", Namespace: "default"}}})
+
+	records := handler.Records()
+	if len(records) != 1 {
+		t.Fatalf("expected one audit entry for the annotated service, got %v", records)
+	}
+	r := records[0]
+	if r.Level != slog.LevelDebug || r.Attrs["name"] != "api" {
+		t.Errorf("expected a debug entry for api, got %v", r)
+	}
+	want := map[string]any{
+		"found": []string{
+			"xds.nebucloud.com/api-gateway",
+			"xds.nebucloud.com/cluster-type",
+			"xds.nebucloud.com/grpc-service",
+			"xds.nebucloud.com/lb-policy",
+			"xds.nebucloud.com/route-priority",
+			"xds.nebucloud.com/tcp-keepalive-interval",
+			"xds.nebucloud.com/tcp-keepalive-probes",
+		},
+		"parsed.xds.nebucloud.com/api-gateway":           []string{"public"},
+		"parsed.xds.nebucloud.com/grpc-service":          []string{"pkg.Service"},
+		"parsed.xds.nebucloud.com/cluster-type":          "strict_dns",
+		"parsed.xds.nebucloud.com/route-priority":        int64(5),
+		"ignored.xds.nebucloud.com/lb-policy":            "unknown annotation",
+		"ignored.xds.nebucloud.com/tcp-keepalive-probes": `annotation xds.nebucloud.com/tcp-keepalive-interval="soon": expect a duration such as 500ms or 5s`,
+	}
+	for key, value := range want {
+		if !reflect.DeepEqual(r.Attrs[key], value) {
+			t.Errorf("%s: expected %v, got %v", key, value, r.Attrs[key])
+		}
+	}
+	if _, ok := r.Attrs["ignored.xds.nebucloud.com/tcp-keepalive-interval"]; !ok {
+		t.Errorf("expected the invalid keepalive interval ignored, got %v", r.Attrs)
+	}
+
+	handler.Reset()
+	newTestSnapshotter(WithApiGateway(false)).auditAnnotations(context.Background(), log, []*corev1.Service{gatewayService("api", "default", "public")})
+	if r, _ := handler.Last(); r.Attrs["ignored.xds.nebucloud.com/api-gateway"] != "api gateway disabled" {
+		t.Errorf("expected the gateway annotations ignored when disabled, got %v", r.Attrs)
+	}
+}
+
+func TestAuditAnnotationsErrorCounter(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        string
+	}{
+		{"keepalive", map[string]string{TCPKeepaliveProbesAnnotation: "4", TCPKeepaliveIntervalAnnotation: "soon"}, TCPKeepaliveIntervalAnnotation},
+		{"cluster type", map[string]string{ClusterTypeAnnotation: "MAGLEV"}, ClusterTypeAnnotation},
+		{"mirror cluster", map[string]string{MirrorClusterAnnotation: "elsewhere"}, MirrorClusterAnnotation},
+		{"mirror percent", map[string]string{MirrorClusterAnnotation: "api.default:grpc", MirrorPercentAnnotation: "150"}, MirrorPercentAnnotation},
+		{"request headers", map[string]string{RequestHeadersToAddAnnotation: "x-team payments"}, RequestHeadersToAddAnnotation},
+		{"response headers", map[string]string{ResponseHeadersToRemoveAnnotation: ":status"}, ResponseHeadersToRemoveAnnotation},
+		{"route priority", map[string]string{apigateway.PriorityAnnotation: "high"}, apigateway.PriorityAnnotation},
+		{"gateway", map[string]string{apigateway.NameAnnotation: "public,"}, apigateway.NameAnnotation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := installTestMeterReader(t)
+			s := newTestSnapshotter()
+			svc := gatewayService("api", "default", "public")
+			for key, value := range tt.annotations {
+				svc.Annotations[key] = value
+			}
+			svc.ResourceVersion = "1"
+			// counted without the debug audit entries
+			log := &logger.Klogger{}
+			log.SetLogger(slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelInfo})))
+			s.auditAnnotations(context.Background(), log, []*corev1.Service{svc})
+			s.auditAnnotations(context.Background(), log, []*corev1.Service{svc})
+			if got := counterValue(t, reader, "xds_annotation_errors", meter.AnnotationAttrKey.String(tt.want)); got != 1 {
+				t.Errorf("expected %s errors counted once per resource version, got %d", tt.want, got)
+			}
+
+			svc.ResourceVersion = "2"
+			s.auditAnnotations(context.Background(), log, []*corev1.Service{svc})
+			if got := counterValue(t, reader, "xds_annotation_errors", meter.AnnotationAttrKey.String(tt.want)); got != 2 {
+				t.Errorf("expected %s errors counted again on update, got %d", tt.want, got)
+			}
+			if got := counterValue(t, reader, "xds_annotation_errors"); got != 2 {
+				t.Errorf("expected only %s errors counted, got %d", tt.want, got)
+			}
+		})
+	}
+
+	reader := installTestMeterReader(t)
+	s := newTestSnapshotter()
+	log := &logger.Klogger{}
+	log.SetLogger(slog.New(logger.NewMemoryHandler()))
+	svc := gatewayService("api", "default", "public")
+	svc.Annotations["xds.nebucloud.com/lb-policy"] = "ring-hash"
+	s.auditAnnotations(context.Background(), log, []*corev1.Service{svc})
+	if got := counterValue(t, reader, "xds_annotation_errors"); got != 0 {
+		t.Errorf("expected valid and unknown annotations not counted, got %d", got)
+	}
+}
+
+func TestStandbyRestore(t *testing.T) {
+	edgedb := &fakeEdgeDB{}
+	services := []*corev1.Service{{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "http", Port: 80}}},
+	}}
+
+	// a serving snapshotter persists its snapshot
+	serving := newTestSnapshotter(WithApiGateway(false))
+	memdb, err := serving.createMemDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serving.emitServices(context.Background(), &servicesLoop{memdb: memdb, edgedb: edgedb, consul: &fakeConsul{}}, "7", services)
+	served, err := serving.servicesCache.GetSnapshot("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a standby restores it, serving it once promoted
+	standby := newTestSnapshotter(WithStandby())
+	standby.restoreSnapshots(context.Background(), edgedb, "services", "endpoints")
+	if _, err := standby.servicesCache.GetSnapshot(""); err == nil {
+		t.Errorf("expected no services snapshot served while standby")
+	}
+	standby.Promote(context.Background())
+	restored, err := standby.servicesCache.GetSnapshot("")
+	if err != nil {
+		t.Fatalf("expected the restored snapshot served once promoted: %s", err)
+	}
+	for _, typeURL := range []string{resource.ClusterType, resource.ListenerType, resource.RouteType} {
+		if got, want := restored.GetVersion(typeURL), served.GetVersion(typeURL); got != want {
+			t.Errorf("%s: expected version %s, got %s", typeURL, want, got)
+		}
+		if got, want := len(restored.GetResources(typeURL)), len(served.GetResources(typeURL)); got != want || got == 0 {
+			t.Errorf("%s: expected %d resources, got %d", typeURL, want, got)
+		}
+	}
+	if _, err := standby.endpointsCache.GetSnapshot(""); err == nil {
+		t.Errorf("expected no endpoints snapshot without one persisted")
+	}
+}
+
+func TestServicesToResourcesGatewayCatchAll(t *testing.T) {
+	services := []*corev1.Service{
+		gatewayService("api", "default", "public"),
+		gatewayService("admin", "default", "internal"),
+	}
+	tests := []struct {
+		name     string
+		opts     []Option
+		wantBody string
+		want     uint32
+	}{
+		{"disabled", nil, "", 0},
+		{"not found", []Option{WithGatewayCatchAll(404, `{"error":"not found"}`)}, `{"error":"not found"}`, 404},
+		{"without body", []Option{WithGatewayCatchAll(503, "")}, "", 503},
+		{"invalid status", []Option{WithGatewayCatchAll(99, "")}, "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resources, stats := newTestSnapshotter(tt.opts...).servicesToResources(services)
+			for _, gateway := range []string{"public", "internal"} {
+				if stats[gateway] != 1 {
+					t.Errorf("%s: expected the catch-all not counted, got %d routes", gateway, stats[gateway])
+				}
+				routeConfig, ok := resourcesByName(t, resources)[resource.RouteType+"/"+gateway].(*routev3.RouteConfiguration)
+				if !ok {
+					t.Fatalf("%s: route configuration missing", gateway)
+				}
+				routes := routeConfig.GetVirtualHosts()[0].GetRoutes()
+				last := routes[len(routes)-1]
+				if tt.want == 0 {
+					if len(routes) != 1 || last.GetName() == apigateway.CatchAllRouteName {
+						t.Errorf("%s: expected no catch-all route, got %v", gateway, routes)
+					}
+					continue
+				}
+				if len(routes) != 2 || last.GetName() != apigateway.CatchAllRouteName {
+					t.Fatalf("%s: expected the catch-all route last, got %v", gateway, routes)
+				}
+				if prefix := last.GetMatch().GetPrefix(); prefix != "/" {
+					t.Errorf("%s: expected the catch-all to match every path, got prefix %q", gateway, prefix)
+				}
+				direct := last.GetDirectResponse()
+				if direct.GetStatus() != tt.want || direct.GetBody().GetInlineString() != tt.wantBody {
+					t.Errorf("%s: expected direct response %d %q, got %v", gateway, tt.want, tt.wantBody, direct)
+				}
+			}
+		})
+	}
+}
+
+func TestServicesToResourcesGatewayCatchAllCopies(t *testing.T) {
+	services := []*corev1.Service{
+		gatewayService("api", "default", "public"),
+		gatewayService("admin", "default", "internal"),
+	}
+	s := newTestSnapshotter(WithGatewayCatchAll(404, ""))
+	resources, _ := s.servicesToResources(services)
+	byName := resourcesByName(t, resources)
+	catchAll := func(gateway string) *routev3.Route {
+		routes := byName[resource.RouteType+"/"+gateway].(*routev3.RouteConfiguration).GetVirtualHosts()[0].GetRoutes()
+		return routes[len(routes)-1]
+	}
+
+	// editing the catch-all of one gateway must leave the others and the option alone
+	catchAll("public").GetDirectResponse().Status = 500
+	if got := catchAll("internal").GetDirectResponse().GetStatus(); got != 404 {
+		t.Errorf("expected the internal catch-all untouched, got status %d", got)
+	}
+	if got := s.gatewayCatchAll.GetDirectResponse().GetStatus(); got != 404 {
+		t.Errorf("expected the configured catch-all untouched, got status %d", got)
+	}
+}
+
+func TestServicesToResourcesRoutePriority(t *testing.T) {
+	service := func(name, rpc, priority string) *corev1.Service {
+		svc := gatewayService(name, "default", "public")
+		svc.Annotations[apigateway.ServiceAnnotation] = rpc
+		if priority != "" {
+			svc.Annotations[apigateway.PriorityAnnotation] = priority
+		}
+		return svc
+	}
+	services := []*corev1.Service{
+		service("fallback", "pkg.Fallback", "-10"),
+		service("users", "pkg.Users", ""),
+		service("accounts", "pkg.Accounts", ""),
+		service("admin", "pkg.Admin,pkg.Audit", "10"),
+		service("invalid", "pkg.Invalid", "high"),
+	}
+
+	resources, _ := newTestSnapshotter(WithGatewayCatchAll(404, "")).servicesToResources(services)
+	routeConfig := resourcesByName(t, resources)[resource.RouteType+"/public"].(*routev3.RouteConfiguration)
+	var got []string
+	for _, r := range routeConfig.GetVirtualHosts()[0].GetRoutes() {
+		got = append(got, r.GetName())
+	}
+	want := []string{"pkg.Admin", "pkg.Audit", "pkg.Accounts", "pkg.Invalid", "pkg.Users", "pkg.Fallback", apigateway.CatchAllRouteName}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected routes %v, got %v", want, got)
+	}
+}
+
+func versionedService(name, namespace, version string, ports ...int32) *corev1.Service {
+	svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, ResourceVersion: version}}
+	for _, port := range ports {
+		svc.Spec.Ports = append(svc.Spec.Ports, corev1.ServicePort{Name: fmt.Sprintf("p%d", port), Port: port})
+	}
+	return svc
+}
+
+// resourcesByName indexes resources by type and name, failing on duplicates.
+func resourcesByName(t *testing.T, resources []types.Resource) map[string]types.Resource {
+	t.Helper()
+	out := make(map[string]types.Resource, len(resources))
+	for _, r := range resources {
+		key := resourceType(r) + "/" + cache.GetResourceName(r)
+		if _, ok := out[key]; ok {
+			t.Fatalf("duplicate resource %s", key)
+		}
+		out[key] = r
+	}
+	return out
+}
+
+func TestServicesToResourcesScoped(t *testing.T) {
+	gateway := gatewayService("api", "default", "public")
+	gateway.ResourceVersion = "1"
+	initial := []*corev1.Service{
+		gateway,
+		versionedService("web", "a", "1", 80),
+		versionedService("db", "a", "1", 5432),
+		versionedService("cache", "a", "1", 6379),
+	}
+	updatedGateway := gatewayService("api", "default", "internal")
+	updatedGateway.ResourceVersion = "2"
+	changed := []*corev1.Service{
+		updatedGateway,
+		versionedService("web", "a", "2", 80, 443),
+		versionedService("db", "a", "1", 5432),
+		// a second web makes the bare name of the unchanged web ambiguous
+		versionedService("web", "b", "1", 80),
+	}
+
+	opts := []Option{WithEgressListeners(15000), WithTCPKeepalive(3, 0, 0)}
+	s := newTestSnapshotter(opts...)
+	s.servicesToResources(initial)
+	scoped, scopedStats := s.servicesToResources(changed)
+	fresh := newTestSnapshotter(opts...)
+	// the egress ports are kept from the initial services, not reassigned
+	fresh.egressPorts = s.egressPorts
+	full, fullStats := fresh.servicesToResources(changed)
+
+	got, want := resourcesByName(t, scoped), resourcesByName(t, full)
+	for key, r := range want {
+		if !proto.Equal(got[key], r) {
+			t.Errorf("%s: expected %v, got %v", key, r, got[key])
+		}
+	}
+	for key := range got {
+		if _, ok := want[key]; !ok {
+			t.Errorf("unexpected resource %s", key)
+		}
+	}
+	if fmt.Sprint(scopedStats) != fmt.Sprint(fullStats) {
+		t.Errorf("expected api gateway stats %v, got %v", fullStats, scopedStats)
+	}
+	if len(s.serviceResourceCache) != len(changed) {
+		t.Errorf("expected the removed service evicted, got %d cached services", len(s.serviceResourceCache))
+	}
+}
+
+func TestServicesToResourcesReuse(t *testing.T) {
+	s := newTestSnapshotter()
+	services := []*corev1.Service{versionedService("web", "a", "1", 80), versionedService("db", "a", "1", 5432)}
+	first, _ := s.servicesToResources(services)
+
+	services[1] = versionedService("db", "a", "2", 5432)
+	second, _ := s.servicesToResources(services)
+	before, after := resourcesByName(t, first), resourcesByName(t, second)
+	if before["type.googleapis.com/envoy.config.cluster.v3.Cluster/web.a:p80"] != after["type.googleapis.com/envoy.config.cluster.v3.Cluster/web.a:p80"] {
+		t.Errorf("expected the unchanged service resources reused")
+	}
+	if before["type.googleapis.com/envoy.config.cluster.v3.Cluster/db.a:p5432"] == after["type.googleapis.com/envoy.config.cluster.v3.Cluster/db.a:p5432"] {
+		t.Errorf("expected the changed service resources regenerated")
+	}
+}
+
+func benchmarkServices(n int) []*corev1.Service {
+	services := make([]*corev1.Service, n)
+	for i := range services {
+		services[i] = versionedService(fmt.Sprintf("svc-%d", i), "default", "1", 80, 443)
+	}
+	return services
+}
+
+func BenchmarkServicesToResourcesFull(b *testing.B) {
+	services := benchmarkServices(1000)
+	s := newTestSnapshotter()
+	for i := 0; i < b.N; i++ {
+		s.serviceResourceCache = nil
+		s.servicesToResources(services)
+	}
+}
+
+func BenchmarkServicesToResourcesScoped(b *testing.B) {
+	services := benchmarkServices(1000)
+	s := newTestSnapshotter()
+	s.servicesToResources(services)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		services[i%len(services)] = versionedService(fmt.Sprintf("svc-%d", i%len(services)), "default", fmt.Sprint(i+2), 80, 443)
+		s.servicesToResources(services)
+	}
+}
+
+// testService returns the service name of the default namespace with an http port.
+func testService(name string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "http", Port: 80}}},
+	}
+}
+
+// servesService reports whether the services snapshot of s has resources of
+// the service name of the default namespace.
+func servesService(s *Snapshotter, name string) bool {
+	snapshot, err := s.servicesCache.GetSnapshot("")
+	if err != nil {
+		return false
+	}
+	for _, typeURL := range []string{resource.ClusterType, resource.ListenerType, resource.RouteType} {
+		for key := range snapshot.GetResources(typeURL) {
+			if strings.HasPrefix(key, name+".default") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// waitFor polls cond until it holds, failing after 5s.
+func waitFor(t *testing.T, reason string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", reason)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRunServicesDeletion(t *testing.T) {
+	client := fake.NewSimpleClientset(testService("api"), testService("web"))
+	watcher := watch.NewFake()
+	client.PrependWatchReactor("services", k8stesting.DefaultWatchReactor(watcher, nil))
+	s := newSnapshotter(client, logger.Singleton(), WithResyncPeriod(10*time.Millisecond))
+	memdb, err := s.createMemDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loop := &servicesLoop{memdb: memdb, edgedb: &fakeEdgeDB{}, consul: &fakeConsul{}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go s.runServices(ctx, loop)
+	served := func(name string) bool { return servesService(s, name) }
+
+	waitFor(t, "both services", func() bool { return served("api") && served("web") })
+	if err := client.CoreV1().Services("default").Delete(ctx, "web", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	watcher.Delete(testService("web"))
+	waitFor(t, "web removal", func() bool { return !served("web") })
+
+	// resyncs must not bring the deleted service back
+	time.Sleep(100 * time.Millisecond)
+	if served("web") || !served("api") {
+		t.Errorf("expected only api served after resyncs, got api %t web %t", served("api"), served("web"))
+	}
+	txn := memdb.Txn(false)
+	defer txn.Abort()
+	if obj, err := txn.First("services", "id", "default/web"); err != nil || obj != nil {
+		t.Errorf("expected web evicted from MemDB, got %v %v", obj, err)
+	}
+	if obj, err := txn.First("services", "id", "default/api"); err != nil || obj == nil {
+		t.Errorf("expected api cached in MemDB, got %v %v", obj, err)
+	}
+}
+
+func TestRunServicesRelist(t *testing.T) {
+	client := fake.NewSimpleClientset(testService("api"), testService("web"))
+	watchers := make(chan *watch.FakeWatcher, 10)
+	client.PrependWatchReactor("services", func(k8stesting.Action) (bool, watch.Interface, error) {
+		watcher := watch.NewFake()
+		watchers <- watcher
+		return true, watcher, nil
+	})
+	s := newSnapshotter(client, logger.Singleton())
+	memdb, err := s.createMemDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loop := &servicesLoop{memdb: memdb, edgedb: &fakeEdgeDB{}, consul: &fakeConsul{}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go s.runServices(ctx, loop)
+	waitFor(t, "both services", func() bool { return servesService(s, "api") && servesService(s, "web") })
+
+	// web is deleted during a watch gap, the expired watch forcing a relist
+	if err := client.CoreV1().Services("default").Delete(ctx, "web", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	(<-watchers).Error(&metav1.Status{Status: metav1.StatusFailure, Code: 410, Reason: metav1.StatusReasonExpired})
+	waitFor(t, "web removal", func() bool { return !servesService(s, "web") })
+	if !servesService(s, "api") {
+		t.Errorf("expected api still served after the relist")
+	}
+}
+
+func TestRunServicesListFallback(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("list", "services", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("apiserver unavailable")
+	})
+	client.PrependWatchReactor("services", k8stesting.DefaultWatchReactor(watch.NewFake(), nil))
+	s := newSnapshotter(client, logger.Singleton())
+	memdb, err := s.createMemDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.cacheServices(s.logger, memdb, []*corev1.Service{testService("web")})
+	loop := &servicesLoop{memdb: memdb, edgedb: &fakeEdgeDB{}, consul: &fakeConsul{}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go s.runServices(ctx, loop)
+	waitFor(t, "the cached service", func() bool { return servesService(s, "web") })
+}
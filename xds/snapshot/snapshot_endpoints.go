@@ -4,12 +4,13 @@ import (
 	"context"
 	"fmt"
 	"sort"
+	"sync"
+	"time"
 
 	"github.com/edgedb/edgedb-go"
 	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
-	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
 	consulApi "github.com/hashicorp/consul/api"
 	memdb "github.com/hashicorp/go-memdb"
 	"github.com/nebucloud/pkg/logger"
@@ -21,115 +22,222 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/watch"
 	k8scache "k8s.io/client-go/tools/cache"
-	"k8s.io/klog/v2"
 )
 
+// endpointCacheItem holds the resources of the Endpoints key at version,
+// cached in the endpoint_resources MemDB table.
 type endpointCacheItem struct {
+	key       string
 	version   string
 	resources []types.Resource
 }
 
 func (s *Snapshotter) startEndpoints(ctx context.Context, memdb *memdb.MemDB, edgedbClient *edgedb.Client, consulClient *consulApi.Client, logger *logger.Klogger) error {
-	emit := func() {}
+	return s.runEndpoints(ctx, &endpointsLoop{
+		memdb:  memdb,
+		edgedb: edgedbClient,
+		consul: consulClient.Agent(),
+		logger: logger,
+	})
+}
 
-	store := k8scache.NewUndeltaStore(func(v []interface{}) {
-		emit()
-	}, k8scache.DeletionHandlingMetaNamespaceKeyFunc)
+// runEndpoints watches the endpoints and emits them until ctx is done.
+func (s *Snapshotter) runEndpoints(ctx context.Context, loop *endpointsLoop) error {
+	emits := &emitter{}
+	emit := s.coalesce(ctx, emits.emit)
+	store := s.newEmitStore(emit)
 
 	reflector := k8scache.NewReflector(&k8scache.ListWatch{
 		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
-			// Check if endpoints are cached in MemDB
-			txn := memdb.Txn(false)
-			defer txn.Abort()
-			iter, err := txn.Get("endpoints", "id")
-			if err != nil {
-				return nil, err
+			list, err := s.client.CoreV1().Endpoints("").List(ctx, options)
+			if err == nil {
+				return list, nil
 			}
-			var endpoints []corev1.Endpoints
-			for obj := iter.Next(); obj != nil; obj = iter.Next() {
-				endpoint := obj.(*corev1.Endpoints)
-				endpoints = append(endpoints, *endpoint)
-			}
-			if len(endpoints) > 0 {
-				return &corev1.EndpointsList{Items: endpoints}, nil
+			// Fall back to the endpoints cached in MemDB while the API fails
+			cached, cacheErr := cachedEndpoints(loop.memdb)
+			if cacheErr != nil || len(cached.Items) == 0 {
+				return nil, err
 			}
-
-			// If endpoints are not cached, fetch them from Kubernetes
-			return s.client.CoreV1().Endpoints("").List(ctx, options)
+			loop.logger.Warnf("Failed to list endpoints, serving the %d cached in MemDB: %v", len(cached.Items), err)
+			return cached, nil
 		},
 		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
 			return s.client.CoreV1().Endpoints("").Watch(ctx, options)
 		},
 	}, &corev1.Endpoints{}, store, s.ResyncPeriod)
 
-	var lastSnapshotHash uint64
+	// the drain timer emits concurrently with the reflector
+	var emitLock sync.Mutex
+	var drainTimer *time.Timer
+	loop.emitAfter = func(after time.Duration) {
+		if drainTimer != nil {
+			drainTimer.Stop()
+		}
+		drainTimer = time.AfterFunc(after, func() {
+			if ctx.Err() == nil {
+				emit()
+			}
+		})
+	}
+	emits.activate(func() {
+		emitLock.Lock()
+		defer emitLock.Unlock()
+		s.emitEndpoints(ctx, loop, reflector.LastSyncResourceVersion(), sliceToEndpoints(store.List()))
+	})
 
-	emit = func() {
-		version := reflector.LastSyncResourceVersion()
-		s.kubeEventCounter.Add(ctx, 1, metric.WithAttributes(meter.ResourceAttrKey.String("endpoints")))
+	s.trackReflector("endpoints", reflector)
+	reflector.Run(ctx.Done())
+	return nil
+}
 
-		endpoints := sliceToEndpoints(store.List())
+// endpointsLoop holds the dependencies and state of the endpoints reconciliation loop.
+type endpointsLoop struct {
+	memdb  *memdb.MemDB
+	edgedb EdgeDBQuerier
+	consul ConsulRegistrar
+	logger *logger.Klogger
+	// emitAfter schedules an emit once a draining endpoint is due for removal
+	emitAfter func(after time.Duration)
 
-		// Persist endpoints in EdgeDB
-		for _, ep := range endpoints {
-			err := s.persistEndpointInEdgeDB(ctx, edgedbClient, ep)
-			if err != nil {
-				klog.Errorf("Failed to persist endpoint in EdgeDB: %v", err)
-			}
-		}
+	lastSnapshotHash uint64
+}
 
-		// Register endpoints with Consul
-		for _, ep := range endpoints {
-			err := s.registerEndpointWithConsul(consulClient, ep)
-			if err != nil {
-				klog.Errorf("Failed to register endpoint with Consul: %v", err)
-			}
-		}
+// emitEndpoints mirrors endpoints to the integrations and publishes their
+// resources to the endpoints cache. Every line it logs carries the same emit ID.
+func (s *Snapshotter) emitEndpoints(ctx context.Context, loop *endpointsLoop, version string, endpoints []*corev1.Endpoints) {
+	log := loop.logger.With("emit_id", newEmitID())
+	s.emitCount.Add(1)
+	s.kubeEventCounter.Add(ctx, 1, metric.WithAttributes(meter.ResourceAttrKey.String("endpoints")))
 
-		endpointsResources, err := s.kubeEndpointsToResources(endpoints, memdb, logger)
+	// Persist endpoints in EdgeDB
+	for _, ep := range endpoints {
+		err := s.persistEndpointInEdgeDB(ctx, loop.edgedb, ep)
+		s.recordOperation(ctx, "endpoints", operationEdgeDBPersist, err)
 		if err != nil {
-			klog.Errorf("Failed to convert endpoints to resources: %v", err)
-			return
+			s.emitErrorf(log.WithObject(ep), "Failed to persist endpoint in EdgeDB: %v", err)
 		}
+	}
 
-		hash, err := resourcesHash(endpointsResources)
-		if err == nil {
-			if hash == lastSnapshotHash {
-				klog.V(4).Info("new snapshot is equivalent to the previous one")
-				return
-			}
-			lastSnapshotHash = hash
-		} else {
-			klog.Errorf("fail to hash snapshot: %s", err)
+	// Register endpoints with Consul
+	for _, ep := range endpoints {
+		err := s.registerEndpointWithConsul(loop.consul, ep)
+		s.recordOperation(ctx, "endpoints", operationConsulRegister, err)
+		if err != nil {
+			s.emitErrorf(log.WithObject(ep), "Failed to register endpoint with Consul: %v", err)
 		}
+	}
 
-		resourcesByType := resourcesToMap(endpointsResources)
-		s.setEndpointResourcesByType(resourcesByType)
+	s.cacheEndpoints(log, loop.memdb, endpoints)
 
-		snapshot, err := cache.NewSnapshot(version, resourcesByType)
-		if err != nil {
-			panic(err)
+	endpointsResources, err := s.kubeEndpointsToResources(endpoints, loop.memdb, log)
+	if err != nil {
+		s.emitErrorf(log, "Failed to convert endpoints to resources: %v", err)
+		return
+	}
+	endpointsResources = s.holdBackEndpoints(log, endpointsResources)
+	endpointsResources, drainDue := s.drainRemovedEndpoints(endpointsResources)
+	if !drainDue.IsZero() && loop.emitAfter != nil {
+		loop.emitAfter(drainDue.Sub(s.now()))
+	}
+
+	hash, err := resourcesHash(endpointsResources)
+	if err == nil {
+		if hash == loop.lastSnapshotHash {
+			log.Debugf("new snapshot is equivalent to the previous one")
+			return
 		}
+		loop.lastSnapshotHash = hash
+	} else {
+		s.emitErrorf(log, "fail to hash snapshot: %s", err)
+	}
 
-		s.endpointsCache.SetSnapshot(ctx, "", snapshot)
+	resourcesByType := resourcesToMap(endpointsResources)
+	if !s.withinResourceLimits(ctx, log, resourcesByType) {
+		return
+	}
+	s.setEndpointResourcesByType(resourcesByType)
 
-		// Cache endpoints in MemDB
-		txn := memdb.Txn(true)
-		for _, ep := range endpoints {
-			if err := txn.Insert("endpoints", ep); err != nil {
-				txn.Abort()
-				klog.Errorf("Failed to cache endpoint in MemDB: %v", err)
-				return
-			}
+	version = s.nextVersion("endpoints", version)
+	s.publish(ctx, "endpoints", version, resourcesByType)
+	log.Debugf("set endpoints snapshot version %s hash %x", version, hash)
+
+	err = s.persistSnapshot(ctx, loop.edgedb, "endpoints", version, endpointsResources)
+	s.recordOperation(ctx, "endpoints", operationEdgeDBPersist, err)
+	if err != nil {
+		s.emitErrorf(log, "Failed to persist endpoints snapshot in EdgeDB: %v", err)
+	}
+}
+
+// cachedEndpoints returns the endpoints cached in db.
+func cachedEndpoints(db *memdb.MemDB) (*corev1.EndpointsList, error) {
+	txn := db.Txn(false)
+	defer txn.Abort()
+	iter, err := txn.Get("endpoints", "id")
+	if err != nil {
+		return nil, err
+	}
+	list := &corev1.EndpointsList{}
+	for obj := iter.Next(); obj != nil; obj = iter.Next() {
+		list.Items = append(list.Items, *obj.(*corev1.Endpoints))
+	}
+	return list, nil
+}
+
+// cacheEndpoints replaces the endpoints cached in db with endpoints, so
+// deleted endpoints are not listed again from the cache, and evicts the
+// resources cached for the deleted ones.
+func (s *Snapshotter) cacheEndpoints(log *logger.Klogger, db *memdb.MemDB, endpoints []*corev1.Endpoints) {
+	txn := db.Txn(true)
+	if _, err := txn.DeleteAll("endpoints", "id"); err != nil {
+		txn.Abort()
+		s.emitErrorf(log, "Failed to clear endpoints cached in MemDB: %v", err)
+		return
+	}
+	for _, ep := range endpoints {
+		if err := txn.Insert("endpoints", ep); err != nil {
+			txn.Abort()
+			s.emitErrorf(log.WithObject(ep), "Failed to cache endpoint in MemDB: %v", err)
+			return
 		}
-		txn.Commit()
 	}
+	if err := evictEndpointResources(txn, endpoints); err != nil {
+		txn.Abort()
+		s.emitErrorf(log, "Failed to evict endpoint resources cached in MemDB: %v", err)
+		return
+	}
+	txn.Commit()
+}
 
-	reflector.Run(ctx.Done())
+// evictEndpointResources deletes in txn the endpoint_resources rows of the
+// Endpoints no longer in endpoints.
+func evictEndpointResources(txn *memdb.Txn, endpoints []*corev1.Endpoints) error {
+	keep := make(map[string]bool, len(endpoints))
+	for _, ep := range endpoints {
+		key, err := k8scache.MetaNamespaceKeyFunc(ep)
+		if err != nil {
+			return err
+		}
+		keep[key] = true
+	}
+	iter, err := txn.Get("endpoint_resources", "id")
+	if err != nil {
+		return err
+	}
+	var stale []interface{}
+	for obj := iter.Next(); obj != nil; obj = iter.Next() {
+		if !keep[obj.(*endpointCacheItem).key] {
+			stale = append(stale, obj)
+		}
+	}
+	for _, obj := range stale {
+		if err := txn.Delete("endpoint_resources", obj); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (s *Snapshotter) persistEndpointInEdgeDB(ctx context.Context, client *edgedb.Client, ep *corev1.Endpoints) error {
+func (s *Snapshotter) persistEndpointInEdgeDB(ctx context.Context, client EdgeDBQuerier, ep *corev1.Endpoints) error {
 	// Implement the logic to persist the endpoint data in EdgeDB using the provided client
 	// You can use EdgeDB's query language to store the endpoint data in the appropriate tables/collections
 	// Example:
@@ -148,7 +256,7 @@ func (s *Snapshotter) persistEndpointInEdgeDB(ctx context.Context, client *edged
 	return nil // Replace with your actual implementation
 }
 
-func (s *Snapshotter) registerEndpointWithConsul(client *consulApi.Client, ep *corev1.Endpoints) error {
+func (s *Snapshotter) registerEndpointWithConsul(client ConsulRegistrar, ep *corev1.Endpoints) error {
 	// Implement the logic to register the endpoint with Consul using the provided client
 	// You can use Consul's API to register the endpoint as a service with the appropriate metadata
 	// Example:
@@ -158,7 +266,7 @@ func (s *Snapshotter) registerEndpointWithConsul(client *consulApi.Client, ep *c
 	//   Address: ep.Subsets[0].Addresses[0].IP,
 	//   // Add other endpoint metadata as needed
 	// }
-	// err := client.Agent().ServiceRegister(registration)
+	// err := client.ServiceRegister(registration)
 	// return err
 
 	return nil // Replace with your actual implementation
@@ -199,12 +307,12 @@ func (s *Snapshotter) kubeEndpointToResources(ep *corev1.Endpoints, memdb *memdb
 	txn := memdb.Txn(false)
 	defer txn.Abort()
 
-	cached, err := txn.First("endpoints", "id", name)
+	cached, err := txn.First("endpoint_resources", "id", name)
 	if err != nil {
 		return nil, err
 	}
 	if cached != nil {
-		item := cached.(endpointCacheItem)
+		item := cached.(*endpointCacheItem)
 		if item.version == ep.ResourceVersion {
 			return item.resources, nil
 		}
@@ -273,7 +381,8 @@ func (s *Snapshotter) kubeEndpointToResources(ep *corev1.Endpoints, memdb *memdb
 
 	// Cache the endpoint resources in MemDB
 	txn = memdb.Txn(true)
-	if err := txn.Insert("endpoints", endpointCacheItem{
+	if err := txn.Insert("endpoint_resources", &endpointCacheItem{
+		key:       name,
 		version:   ep.ResourceVersion,
 		resources: out,
 	}); err != nil {
@@ -0,0 +1,177 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/nebucloud/pkg/logger"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func testEndpoints(name, version string, ips ...string) *corev1.Endpoints {
+	subset := corev1.EndpointSubset{Ports: []corev1.EndpointPort{{Name: "http", Port: 8080}}}
+	for _, ip := range ips {
+		subset.Addresses = append(subset.Addresses, corev1.EndpointAddress{IP: ip})
+	}
+	return &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", ResourceVersion: version},
+		Subsets:    []corev1.EndpointSubset{subset},
+	}
+}
+
+func TestCacheEndpoints(t *testing.T) {
+	s := newTestSnapshotter()
+	db, err := s.createMemDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.cacheEndpoints(s.logger, db, []*corev1.Endpoints{testEndpoints("web", "1", "10.0.0.1"), testEndpoints("api", "1", "10.0.0.2")})
+	for _, name := range []string{"web", "api"} {
+		if _, err := s.kubeEndpointToResources(testEndpoints(name, "1", "10.0.0.1"), db, s.logger); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.cacheEndpoints(s.logger, db, []*corev1.Endpoints{testEndpoints("web", "2", "10.0.0.1", "10.0.0.3")})
+
+	txn := db.Txn(false)
+	defer txn.Abort()
+	iter, err := txn.Get("endpoints", "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for obj := iter.Next(); obj != nil; obj = iter.Next() {
+		names = append(names, obj.(*corev1.Endpoints).Name)
+	}
+	if len(names) != 1 || names[0] != "web" {
+		t.Errorf("expected only the web endpoints cached, got %v", names)
+	}
+	cached, err := txn.First("endpoints", "id", "default/web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ep, ok := cached.(*corev1.Endpoints); !ok || ep.ResourceVersion != "2" || len(ep.Subsets[0].Addresses) != 2 {
+		t.Errorf("expected the latest web endpoints, got %v", cached)
+	}
+	if obj, err := txn.First("endpoint_resources", "id", "default/api"); err != nil || obj != nil {
+		t.Errorf("expected the api resources evicted, got %v %v", obj, err)
+	}
+	if obj, err := txn.First("endpoint_resources", "id", "default/web"); err != nil || obj == nil {
+		t.Errorf("expected the web resources kept, got %v %v", obj, err)
+	}
+}
+
+func TestKubeEndpointToResourcesCache(t *testing.T) {
+	s := newTestSnapshotter()
+	db, err := s.createMemDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := logger.Singleton()
+
+	first, err := s.kubeEndpointToResources(testEndpoints("web", "1", "10.0.0.1"), db, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 1 || readyEndpoints(first[0].(*endpointv3.ClusterLoadAssignment)) != 1 {
+		t.Fatalf("expected one assignment with one endpoint, got %v", first)
+	}
+
+	txn := db.Txn(false)
+	cached, err := txn.First("endpoint_resources", "id", "default/web")
+	txn.Abort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item, ok := cached.(*endpointCacheItem); !ok || item.version != "1" {
+		t.Errorf("expected the resources cached at version 1, got %v", cached)
+	}
+
+	// the cached resources are served for the same version, even if stale
+	again, err := s.kubeEndpointToResources(testEndpoints("web", "1", "10.0.0.1", "10.0.0.2"), db, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again) != 1 || again[0] != first[0] {
+		t.Errorf("expected the cached resources, got %v", again)
+	}
+
+	updated, err := s.kubeEndpointToResources(testEndpoints("web", "2", "10.0.0.1", "10.0.0.2"), db, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updated) != 1 || readyEndpoints(updated[0].(*endpointv3.ClusterLoadAssignment)) != 2 {
+		t.Errorf("expected the resources of version 2, got %v", updated)
+	}
+}
+
+// servesEndpoints reports whether the endpoints snapshot of s assigns the
+// http port of the endpoints name of the default namespace.
+func servesEndpoints(s *Snapshotter, name string) bool {
+	snapshot, err := s.endpointsCache.GetSnapshot("")
+	if err != nil {
+		return false
+	}
+	_, ok := snapshot.GetResources(resource.EndpointType)[name+".default:http"]
+	return ok
+}
+
+func TestRunEndpointsRelist(t *testing.T) {
+	client := fake.NewSimpleClientset(testEndpoints("api", "1", "10.0.0.1"), testEndpoints("web", "1", "10.0.0.2"))
+	watchers := make(chan *watch.FakeWatcher, 10)
+	client.PrependWatchReactor("endpoints", func(k8stesting.Action) (bool, watch.Interface, error) {
+		watcher := watch.NewFake()
+		watchers <- watcher
+		return true, watcher, nil
+	})
+	s := newSnapshotter(client, logger.Singleton())
+	memdb, err := s.createMemDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loop := &endpointsLoop{memdb: memdb, edgedb: &fakeEdgeDB{}, consul: &fakeConsul{}, logger: s.logger}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go s.runEndpoints(ctx, loop)
+	waitFor(t, "both endpoints", func() bool { return servesEndpoints(s, "api") && servesEndpoints(s, "web") })
+
+	// web is deleted during a watch gap, the expired watch forcing a relist
+	if err := client.CoreV1().Endpoints("default").Delete(ctx, "web", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	(<-watchers).Error(&metav1.Status{Status: metav1.StatusFailure, Code: 410, Reason: metav1.StatusReasonExpired})
+	waitFor(t, "web removal", func() bool { return !servesEndpoints(s, "web") })
+	if !servesEndpoints(s, "api") {
+		t.Errorf("expected api still served after the relist")
+	}
+}
+
+func TestRunEndpointsListFallback(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("list", "endpoints", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("apiserver unavailable")
+	})
+	client.PrependWatchReactor("endpoints", k8stesting.DefaultWatchReactor(watch.NewFake(), nil))
+	s := newSnapshotter(client, logger.Singleton())
+	memdb, err := s.createMemDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.cacheEndpoints(s.logger, memdb, []*corev1.Endpoints{testEndpoints("web", "1", "10.0.0.1")})
+	loop := &endpointsLoop{memdb: memdb, edgedb: &fakeEdgeDB{}, consul: &fakeConsul{}, logger: s.logger}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go s.runEndpoints(ctx, loop)
+	waitFor(t, "the cached endpoints", func() bool { return servesEndpoints(s, "web") })
+}
@@ -2,11 +2,21 @@ package snapshot
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dgraph-io/ristretto"
 	"github.com/edgedb/edgedb-go"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
 	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
@@ -14,9 +24,11 @@ import (
 	memdb "github.com/hashicorp/go-memdb"
 	"github.com/nebucloud/pkg/logger"
 	"github.com/nebucloud/pkg/xds/meter"
+	"github.com/nebucloud/pkg/xds/snapshot/apigateway"
 	"go.opentelemetry.io/otel/metric"
 	"golang.org/x/sync/errgroup"
 	"k8s.io/client-go/kubernetes"
+	k8scache "k8s.io/client-go/tools/cache"
 )
 
 func mapTypeURL(typeURL string) string {
@@ -25,6 +37,8 @@ func mapTypeURL(typeURL string) string {
 		return "services"
 	case resource.EndpointType:
 		return "endpoints"
+	case resource.RuntimeType:
+		return "runtime"
 	default:
 		return ""
 	}
@@ -36,31 +50,229 @@ type Snapshotter struct {
 	client         kubernetes.Interface
 	servicesCache  cache.SnapshotCache
 	endpointsCache cache.SnapshotCache
+	runtimeCache   cache.SnapshotCache
 	muxCache       cache.MuxCache
 
 	endpointResourceCache   map[string]endpointCacheItem
+	serviceResourceLock     sync.Mutex
+	serviceResourceCache    map[string]serviceCacheItem
 	resourcesByTypeLock     sync.RWMutex
 	serviceResourcesByType  map[string][]types.Resource
 	endpointResourcesByType map[string][]types.Resource
 	apiGatewayStats         map[string]int
 	kubeEventCounter        metric.Int64Counter
+	integrationCounter      metric.Int64Counter
+	limitExceededCounter    metric.Int64Counter
+	annotationErrorCounter  metric.Int64Counter
 
+	apiGateway      bool
+	egressListeners bool
+	egressBasePort  uint32
+	egressPorts     *egressPorts
+	versionGating   bool
+	emitInterval    time.Duration
+
+	persistenceCompression bool
+	upstreamBindConfig     *corev3.BindConfig
+	tcpKeepalive           *tcpKeepalive
+	gatewayCatchAll        *routev3.Route
+	listenerDrainType      listenerv3.Listener_DrainType
+	egressFilterChainMatch *listenerv3.FilterChainMatch
+	egressReusePort        *bool
+	snapshotNotifiers      []func()
+	edgedbOptions          edgedb.Options
+	edgedbTLS              *edgedb.TLSOptions
+	resourceLimits         map[string]int
+	resourceTTLs           map[string]time.Duration
+	minEndpoints           int
+	drainGrace             time.Duration
+	runtimeConfigMap       *k8scache.ObjectName
+
+	standbyLock      sync.Mutex
+	standby          bool
+	pendingSnapshots map[string]pendingSnapshot
+
+	versionLock     sync.Mutex
+	versionCounters map[string]uint64
+
+	// drainingSince holds the removal time of the draining endpoints by
+	// cluster/address, only touched by the serialized endpoints emits
+	drainingSince map[string]time.Time
+
+	// annotationErrorVersions holds the resource version of the services by
+	// key whose annotation errors were counted, only touched by the
+	// serialized services emits
+	annotationErrorVersions map[string]string
+
+	healthLock sync.Mutex
+	reflectors map[string]*k8scache.Reflector
+	emits      map[string]CacheHealth
+
+	readinessLock       sync.RWMutex
+	dependencyReadiness bool
+	dependenciesAdded   bool
+	dependencyChecks    []dependencyCheck
+
+	emitCount      atomic.Int64
+	emitErrorCount atomic.Int64
+	lastEmitError  atomic.Value
+	closeOnce      sync.Once
+	configLogOnce  sync.Once
+
+	now       func() time.Time
 	logger    *logger.Klogger
 	dbContext context.Context
 	dbCancel  context.CancelFunc
 }
 
+// Option is a function type used to configure the Snapshotter.
+type Option func(s *Snapshotter)
+
+// WithEgressListeners returns an option to additionally emit socket listeners
+// for sidecar egress, listening on ports assigned from basePort. A service
+// port keeps its listen port for as long as it exists.
+func WithEgressListeners(basePort uint32) Option {
+	return func(s *Snapshotter) {
+		s.egressListeners = true
+		s.egressBasePort = basePort
+		s.egressPorts = newEgressPorts(basePort)
+	}
+}
+
+// WithApiGateway returns an option to enable or disable the api gateway
+// resources generated from service annotations. Enabled by default.
+func WithApiGateway(enabled bool) Option {
+	return func(s *Snapshotter) {
+		s.apiGateway = enabled
+	}
+}
+
+// WithGatewayCatchAll returns an option to end every api gateway virtual host
+// with a route answering the unmatched requests a direct response of status
+// and body, instead of the Envoy 404. An invalid status is logged and ignored.
+func WithGatewayCatchAll(status uint32, body string) Option {
+	return func(s *Snapshotter) {
+		route, err := apigateway.CatchAllRoute(status, body)
+		if err != nil {
+			s.logger.Errorf("invalid gateway catch-all: %s", err)
+			return
+		}
+		s.gatewayCatchAll = route
+	}
+}
+
+// WithEnvoyVersionGating returns an option to serve Envoy nodes older than a
+// field's introduction a snapshot without that field, avoiding NACKs.
+func WithEnvoyVersionGating() Option {
+	return func(s *Snapshotter) {
+		s.versionGating = true
+	}
+}
+
+// WithPersistenceCompression returns an option to gzip the serialized
+// snapshot resources before storing them in EdgeDB.
+func WithPersistenceCompression(enabled bool) Option {
+	return func(s *Snapshotter) {
+		s.persistenceCompression = enabled
+	}
+}
+
+// WithSnapshotNotifier returns an option to call notify after every new
+// services snapshot, e.g. to let the LRS server refresh its cluster lists.
+func WithSnapshotNotifier(notify func()) Option {
+	return func(s *Snapshotter) {
+		s.snapshotNotifiers = append(s.snapshotNotifiers, notify)
+	}
+}
+
+// WithResyncPeriod returns an option to set the ResyncPeriod of the reflectors.
+func WithResyncPeriod(period time.Duration) Option {
+	return func(s *Snapshotter) {
+		s.ResyncPeriod = period
+	}
+}
+
+// WithClock returns an option to set the clock of the emit times and the
+// endpoint drain periods.
+func WithClock(now func() time.Time) Option {
+	return func(s *Snapshotter) {
+		s.now = now
+	}
+}
+
+// WithEdgeDBOptions returns an option to set the options of the EdgeDB
+// client, DefaultEdgeDBOptions by default.
+func WithEdgeDBOptions(options edgedb.Options) Option {
+	return func(s *Snapshotter) {
+		s.edgedbOptions = options
+	}
+}
+
+// DefaultEdgeDBOptions returns the default options of the EdgeDB client.
+func DefaultEdgeDBOptions() edgedb.Options {
+	return edgedb.Options{
+		ConnectTimeout:     10 * time.Second,
+		WaitUntilAvailable: 30 * time.Second,
+		Concurrency:        4,
+	}
+}
+
+// WithUpstreamBindAddress returns an option to originate upstream connections
+// of every generated cluster from address. Invalid addresses are logged and ignored.
+func WithUpstreamBindAddress(address string) Option {
+	return func(s *Snapshotter) {
+		if net.ParseIP(address) == nil {
+			s.logger.Errorf("invalid upstream bind address %q: expect an IP address", address)
+			return
+		}
+		s.upstreamBindConfig = &corev3.BindConfig{
+			SourceAddress: &corev3.SocketAddress{
+				Protocol: corev3.SocketAddress_TCP,
+				Address:  address,
+				PortSpecifier: &corev3.SocketAddress_PortValue{
+					PortValue: 0,
+				},
+			},
+		}
+	}
+}
+
 // NewSnapshotter creates a new Snapshotter instance.
-func NewSnapshotter(client kubernetes.Interface, logger *logger.Klogger, dbProvider DatabaseProvider, rcache *ristretto.Cache, consulClient *consulApi.Client) *Snapshotter {
+func NewSnapshotter(client kubernetes.Interface, logger *logger.Klogger, dbProvider DatabaseProvider, rcache *ristretto.Cache, consulClient *consulApi.Client, opts ...Option) *Snapshotter {
+	ss := newSnapshotter(client, logger, opts...)
+
+	go ss.startWithDatabase(dbProvider, rcache, consulClient)
+
+	return ss
+}
+
+// newSnapshotter creates a Snapshotter with its caches and metrics, without
+// starting the reconciliation loops.
+func newSnapshotter(client kubernetes.Interface, logger *logger.Klogger, opts ...Option) *Snapshotter {
 	dbContext, dbCancel := context.WithCancel(context.Background())
 
 	ss := &Snapshotter{
-		ResyncPeriod: 10 * time.Minute,
-		client:       client,
+		ResyncPeriod:  10 * time.Minute,
+		client:        client,
+		apiGateway:    true,
+		edgedbOptions: DefaultEdgeDBOptions(),
+		logger:        logger,
+		now:           time.Now,
 	}
 
-	ss.servicesCache = cache.NewSnapshotCache(false, EmptyNodeID{}, logger)
-	ss.endpointsCache = cache.NewSnapshotCache(false, EmptyNodeID{}, logger)
+	for _, o := range opts {
+		o(ss)
+	}
+
+	var servicesNodeHash cache.NodeHash = EmptyNodeID{}
+	if ss.versionGating {
+		servicesNodeHash = EnvoyVersionNodeID{}
+	}
+	ss.dbContext = dbContext
+	ss.dbCancel = dbCancel
+	ss.servicesCache = ss.newSnapshotCache(servicesNodeHash, logger)
+	ss.endpointsCache = ss.newSnapshotCache(EmptyNodeID{}, logger)
+	ss.runtimeCache = ss.newSnapshotCache(EmptyNodeID{}, logger)
 	ss.muxCache = cache.MuxCache{
 		Classify: func(r *cache.Request) string {
 			return mapTypeURL(r.TypeUrl)
@@ -71,21 +283,20 @@ func NewSnapshotter(client kubernetes.Interface, logger *logger.Klogger, dbProvi
 		Caches: map[string]cache.Cache{
 			"services":  ss.servicesCache,
 			"endpoints": ss.endpointsCache,
+			"runtime":   ss.runtimeCache,
 		},
 	}
 
 	ss.endpointResourceCache = map[string]endpointCacheItem{}
-	ss.logger = logger
-	ss.dbContext = dbContext
-	ss.dbCancel = dbCancel
 
 	meter := meter.GetMeter()
 	ss.kubeEventCounter, _ = meter.Int64Counter("xds_kube_events")
+	ss.integrationCounter, _ = meter.Int64Counter("xds_integration_operations")
+	ss.limitExceededCounter, _ = meter.Int64Counter("xds_resource_limit_exceeded")
+	ss.annotationErrorCounter, _ = meter.Int64Counter("xds_annotation_errors")
 	meter.Int64ObservableGauge("xds_snapshot_resources", metric.WithInt64Callback(ss.snapshotResourceGaugeCallback))
 	meter.Int64ObservableGauge("xds_apigateway_endpoints", metric.WithInt64Callback(ss.apiGatewayEndpointGaugeCallback))
 
-	go ss.startWithDatabase(dbProvider, rcache, consulClient)
-
 	return ss
 }
 
@@ -111,20 +322,59 @@ func (s *Snapshotter) startWithDatabase(dbProvider DatabaseProvider, cache *rist
 		return
 	}
 	defer edgedbClient.Close()
+	s.addDependencyChecks(edgedbClient, consulClient)
+	s.logConfig()
+	if s.Standby() {
+		s.restoreSnapshots(s.dbContext, edgedbClient, "services", "endpoints")
+	}
 
-	group, groupCtx := errgroup.WithContext(s.dbContext)
-	group.Go(func() error {
-		return s.startServices(groupCtx, memdb, edgedbClient, consulClient)
-	})
-	group.Go(func() error {
-		return s.startEndpoints(groupCtx, memdb, edgedbClient, consulClient, s.logger)
-	})
-	err = group.Wait()
+	err = s.runLoops(s.dbContext, s.withRuntimeLoop(
+		reconcileLoop{name: "services", run: func(ctx context.Context) error {
+			return s.startServices(ctx, memdb, edgedbClient, consulClient)
+		}},
+		reconcileLoop{name: "endpoints", run: func(ctx context.Context) error {
+			return s.startEndpoints(ctx, memdb, edgedbClient, consulClient, s.logger)
+		}},
+	)...)
 	if err != nil {
 		s.logger.Errorf("Error in reconciliation loops: %v", err)
 	}
 }
 
+// reconcileLoop is a named reconciliation loop
+type reconcileLoop struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// runLoops runs loops until one fails, cancelling the others, and returns
+// the first error wrapped with the name of its loop. Every loop failing on
+// its own is logged.
+func (s *Snapshotter) runLoops(ctx context.Context, loops ...reconcileLoop) error {
+	group, groupCtx := errgroup.WithContext(ctx)
+	for _, loop := range loops {
+		group.Go(func() error {
+			err := loop.run(groupCtx)
+			if err == nil {
+				return nil
+			}
+			if !errors.Is(err, context.Canceled) {
+				s.logger.Errorf("Reconciliation loop %s failed: %v", loop.name, err)
+			}
+			return fmt.Errorf("%s loop: %w", loop.name, err)
+		})
+	}
+	return group.Wait()
+}
+
+// withRuntimeLoop appends the runtime loop to loops if a runtime ConfigMap is set.
+func (s *Snapshotter) withRuntimeLoop(loops ...reconcileLoop) []reconcileLoop {
+	if s.runtimeConfigMap == nil {
+		return loops
+	}
+	return append(loops, reconcileLoop{name: "runtime", run: s.startRuntime})
+}
+
 // createMemDB creates a new instance of MemDB.
 func (s *Snapshotter) createMemDB() (*memdb.MemDB, error) {
 	schema := &memdb.DBSchema{
@@ -135,7 +385,27 @@ func (s *Snapshotter) createMemDB() (*memdb.MemDB, error) {
 					"id": {
 						Name:    "id",
 						Unique:  true,
-						Indexer: &memdb.StringFieldIndex{Field: "ID"},
+						Indexer: objectKeyIndex{},
+					},
+				},
+			},
+			"endpoints": {
+				Name: "endpoints",
+				Indexes: map[string]*memdb.IndexSchema{
+					"id": {
+						Name:    "id",
+						Unique:  true,
+						Indexer: objectKeyIndex{},
+					},
+				},
+			},
+			"endpoint_resources": {
+				Name: "endpoint_resources",
+				Indexes: map[string]*memdb.IndexSchema{
+					"id": {
+						Name:    "id",
+						Unique:  true,
+						Indexer: endpointCacheItemIndex{},
 					},
 				},
 			},
@@ -152,24 +422,7 @@ func (s *Snapshotter) createMemDB() (*memdb.MemDB, error) {
 
 // createEdgeDBClient creates a new instance of EdgeDB client.
 func (s *Snapshotter) createEdgeDBClient() (*edgedb.Client, error) {
-	client, err := edgedb.CreateClient(s.dbContext, edgedb.Options{
-		Host:            "",
-		Port:            0,
-		Credentials:     []byte{},
-		CredentialsFile: "",
-		User:            "",
-		Database:        "",
-		Branch:          "",
-		// Password:           edgedbtypes.OptionalStr{},
-		ConnectTimeout:     10 * time.Second,
-		WaitUntilAvailable: 30 * time.Second,
-		Concurrency:        4,
-		TLSOptions:         edgedb.TLSOptions{},
-		TLSCAFile:          "",
-		TLSSecurity:        "",
-		ServerSettings:     map[string][]byte{},
-		SecretKey:          "",
-	})
+	client, err := edgedb.CreateClient(s.dbContext, s.edgedbClientOptions())
 	if err != nil {
 		return nil, err
 	}
@@ -181,15 +434,66 @@ func (s *Snapshotter) MuxCache() *cache.MuxCache {
 	return &s.muxCache
 }
 
-func (s *Snapshotter) Start(stopCtx context.Context, memdb *memdb.MemDB, edgedbClient *edgedb.Client, consulClient *consulApi.Client, logger *logger.Klogger) error {
-	group, groupCtx := errgroup.WithContext(stopCtx)
-	group.Go(func() error {
-		return s.startServices(groupCtx, memdb, edgedbClient, consulClient)
-	})
-	group.Go(func() error {
-		return s.startEndpoints(groupCtx, memdb, edgedbClient, consulClient, logger)
+// Close stops the reconciliation loops started by NewSnapshotter and logs a
+// summary of the Snapshotter lifetime. It is safe to call Close more than once.
+func (s *Snapshotter) Close() {
+	s.closeOnce.Do(func() {
+		s.dbCancel()
+
+		lastError, _ := s.lastEmitError.Load().(string)
+		s.logger.InfoS("Snapshotter closed",
+			"emits", s.emitCount.Load(),
+			"resources", s.servedResourceCount(),
+			"errors", s.emitErrorCount.Load(),
+			"last_error", lastError,
+		)
 	})
-	return group.Wait()
+}
+
+// emitErrorf logs an emit failure and records it for the Close summary.
+func (s *Snapshotter) emitErrorf(log *logger.Klogger, format string, args ...interface{}) {
+	s.emitErrorCount.Add(1)
+	s.lastEmitError.Store(fmt.Sprintf(format, args...))
+	log.Errorf(format, args...)
+}
+
+// servedResourceCount returns the number of resources currently served by both caches.
+func (s *Snapshotter) servedResourceCount() int {
+	count := 0
+	for _, r := range s.getServiceResourcesByType() {
+		count += len(r)
+	}
+	for _, r := range s.getEndpointResourcesByType() {
+		count += len(r)
+	}
+	return count
+}
+
+func (s *Snapshotter) Start(stopCtx context.Context, memdb *memdb.MemDB, edgedbClient *edgedb.Client, consulClient *consulApi.Client, logger *logger.Klogger) error {
+	s.logConfig()
+	return s.runLoops(stopCtx, s.withRuntimeLoop(
+		reconcileLoop{name: "services", run: func(ctx context.Context) error {
+			return s.startServices(ctx, memdb, edgedbClient, consulClient)
+		}},
+		reconcileLoop{name: "endpoints", run: func(ctx context.Context) error {
+			return s.startEndpoints(ctx, memdb, edgedbClient, consulClient, logger)
+		}},
+	)...)
+}
+
+// Integration operations recorded by integrationCounter
+const (
+	operationEdgeDBPersist  = "edgedb_persist"
+	operationConsulRegister = "consul_register"
+)
+
+// recordOperation counts the outcome of an integration operation on resource.
+func (s *Snapshotter) recordOperation(ctx context.Context, resource, operation string, err error) {
+	s.integrationCounter.Add(ctx, 1, metric.WithAttributes(
+		meter.ResourceAttrKey.String(resource),
+		meter.OperationAttrKey.String(operation),
+		meter.Outcome(err),
+	))
 }
 
 func (s *Snapshotter) snapshotResourceGaugeCallback(_ context.Context, result metric.Int64Observer) error {
@@ -227,6 +531,18 @@ func (s *Snapshotter) setEndpointResourcesByType(endpointResourcesByType map[str
 	s.endpointResourcesByType = endpointResourcesByType
 }
 
+// ClusterNames returns the sorted names of the clusters of the current
+// services snapshot.
+func (s *Snapshotter) ClusterNames() []string {
+	clusters := s.getServiceResourcesByType()[resource.ClusterType]
+	names := make([]string, 0, len(clusters))
+	for _, c := range clusters {
+		names = append(names, cache.GetResourceName(c))
+	}
+	sort.Strings(names)
+	return names
+}
+
 func (s *Snapshotter) getEndpointResourcesByType() map[string][]types.Resource {
 	s.resourcesByTypeLock.RLock()
 	defer s.resourcesByTypeLock.RUnlock()
@@ -244,3 +560,10 @@ func (s *Snapshotter) getAPIGatewayStats() map[string]int {
 	defer s.resourcesByTypeLock.RUnlock()
 	return s.apiGatewayStats
 }
+
+// newEmitID returns a short random ID correlating the log lines of one emit.
+func newEmitID() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/edgedb/edgedb-go"
+	consulApi "github.com/hashicorp/consul/api"
 )
 
 // DatabaseProvider is an interface for database providers.
@@ -30,3 +31,14 @@ func NewEdgeDBProvider(client *edgedb.Client) *EdgeDBProvider {
 func (p *EdgeDBProvider) GetDatabase(ctx context.Context) (Database, error) {
 	return p.client, nil
 }
+
+// EdgeDBQuerier is the subset of *edgedb.Client used to persist resources.
+type EdgeDBQuerier interface {
+	Execute(ctx context.Context, cmd string, args ...interface{}) error
+	QuerySingle(ctx context.Context, cmd string, out interface{}, args ...interface{}) error
+}
+
+// ConsulRegistrar is the subset of *consulApi.Agent used to register services.
+type ConsulRegistrar interface {
+	ServiceRegister(service *consulApi.AgentServiceRegistration) error
+}
@@ -0,0 +1,189 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/edgedb/edgedb-go"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/nebucloud/pkg/logger"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// installTestMeterReader routes the global meter provider to a manual reader
+// for the duration of the test. Install it before creating the Snapshotter.
+func installTestMeterReader(t *testing.T) *sdkmetric.ManualReader {
+	t.Helper()
+	previous := otel.GetMeterProvider()
+	reader := sdkmetric.NewManualReader()
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	t.Cleanup(func() { otel.SetMeterProvider(previous) })
+	return reader
+}
+
+// counterValue returns the sum of the int64 counter name for data points
+// carrying every one of attrs.
+func counterValue(t *testing.T, reader *sdkmetric.ManualReader, name string, attrs ...attribute.KeyValue) int64 {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	var total int64
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != name {
+				continue
+			}
+			sum, ok := m.Data.(metricdata.Sum[int64])
+			if !ok {
+				t.Fatalf("metric %s is not an int64 sum", name)
+			}
+		points:
+			for _, dp := range sum.DataPoints {
+				for _, attr := range attrs {
+					if v, ok := dp.Attributes.Value(attr.Key); !ok || v != attr.Value {
+						continue points
+					}
+				}
+				total += dp.Value
+			}
+		}
+	}
+	return total
+}
+
+func TestCloseLogsSummary(t *testing.T) {
+	s := newTestSnapshotter()
+	handler := logger.NewMemoryHandler()
+	s.logger = &logger.Klogger{}
+	s.logger.SetLogger(slog.New(handler))
+
+	memdb, err := s.createMemDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loop := &servicesLoop{
+		memdb:  memdb,
+		edgedb: &fakeEdgeDB{},
+		consul: &fakeConsul{err: errors.New("consul unavailable")},
+	}
+	services := []*corev1.Service{gatewayService("api", "default", "public")}
+	s.emitServices(context.Background(), loop, "1", services)
+
+	s.Close()
+	s.Close()
+
+	var summaries []map[string]any
+	for _, r := range handler.Records() {
+		if r.Message == "Snapshotter closed" {
+			summaries = append(summaries, r.Attrs)
+		}
+	}
+	if len(summaries) != 1 {
+		t.Fatalf("expected one summary line, got %d", len(summaries))
+	}
+	summary := summaries[0]
+	if summary["emits"] != int64(1) {
+		t.Errorf("expected 1 emit, got %v", summary["emits"])
+	}
+	if resources, _ := summary["resources"].(int64); resources != int64(s.servedResourceCount()) || resources == 0 {
+		t.Errorf("expected %d resources, got %v", s.servedResourceCount(), summary["resources"])
+	}
+	if errs, _ := summary["errors"].(int64); errs < 1 {
+		t.Errorf("expected at least one error, got %v", summary["errors"])
+	}
+	if summary["last_error"] == "" {
+		t.Errorf("expected last_error to be set")
+	}
+	if s.dbContext.Err() == nil {
+		t.Errorf("expected Close to cancel the reconciliation context")
+	}
+}
+
+func TestRunLoopsIdentifiesFailingLoop(t *testing.T) {
+	s := newTestSnapshotter()
+	handler := logger.NewMemoryHandler()
+	s.logger = &logger.Klogger{}
+	s.logger.SetLogger(slog.New(handler))
+
+	errWatch := errors.New("watch failed")
+	err := s.runLoops(context.Background(),
+		reconcileLoop{name: "services", run: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}},
+		reconcileLoop{name: "endpoints", run: func(ctx context.Context) error {
+			return errWatch
+		}},
+	)
+	if !errors.Is(err, errWatch) {
+		t.Fatalf("expected the loop error to be wrapped, got %v", err)
+	}
+	if !strings.HasPrefix(err.Error(), "endpoints loop: ") {
+		t.Errorf("expected the error to identify the endpoints loop, got %q", err)
+	}
+
+	records := handler.Records()
+	if len(records) != 1 || !strings.Contains(records[0].Message, "endpoints") {
+		t.Errorf("expected only the endpoints failure to be logged, got %v", records)
+	}
+}
+
+func TestLogConfig(t *testing.T) {
+	log, observer := logger.NewObserver()
+	options := DefaultEdgeDBOptions()
+	options.Host, options.User = "edgedb.internal", "xds"
+	options.Password = edgedb.NewOptionalStr("hunter2")
+	options.SecretKey = "nbwt_secret"
+	s := newSnapshotter(nil, log,
+		WithResyncPeriod(time.Minute),
+		WithEnvoyVersionGating(),
+		WithEgressListeners(15000),
+		WithResourceLimit(resource.ClusterType, 100),
+		WithEdgeDBOptions(options),
+	)
+
+	s.logConfig()
+	s.logConfig()
+	records := observer.FilterByMessage("Snapshotter configuration")
+	if len(records) != 1 {
+		t.Fatalf("expected the configuration logged once, got %d", len(records))
+	}
+	r := records[0]
+	want := map[string]any{
+		"resync_period":     time.Minute,
+		"namespaces":        "all",
+		"node_hash":         "envoy_version",
+		"api_gateway":       true,
+		"egress_base_port":  uint64(15000),
+		"edgedb.host":       "edgedb.internal",
+		"edgedb.user":       "xds",
+		"edgedb.password":   logger.RedactedValue,
+		"edgedb.secret_key": logger.RedactedValue,
+		"resource_limits." + resource.ClusterType: int64(100),
+	}
+	for key, value := range want {
+		if !reflect.DeepEqual(r.Attrs[key], value) {
+			t.Errorf("%s: expected %v, got %v", key, value, r.Attrs[key])
+		}
+	}
+	if integrations := r.Attrs["integrations"]; !reflect.DeepEqual(integrations, []string{"memdb", "edgedb", "consul"}) {
+		t.Errorf("expected every integration, got %v", integrations)
+	}
+	for key, value := range r.Attrs {
+		if s := fmt.Sprint(value); strings.Contains(s, "hunter2") || strings.Contains(s, "nbwt_secret") {
+			t.Errorf("%s: expected secrets redacted, got %v", key, value)
+		}
+	}
+}
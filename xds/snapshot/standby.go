@@ -0,0 +1,110 @@
+package snapshot
+
+import (
+	"context"
+
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+)
+
+// pendingSnapshot is a snapshot computed by a standby Snapshotter
+type pendingSnapshot struct {
+	version         string
+	resourcesByType map[string][]types.Resource
+}
+
+// WithStandby returns an option to start the Snapshotter as a warm standby:
+// it computes and persists snapshots, keeping EdgeDB warm, but publishes
+// nothing to its caches until Promote is called.
+func WithStandby() Option {
+	return func(s *Snapshotter) {
+		s.standby = true
+	}
+}
+
+// Standby reports whether the Snapshotter is a standby not serving snapshots.
+func (s *Snapshotter) Standby() bool {
+	s.standbyLock.Lock()
+	defer s.standbyLock.Unlock()
+	return s.standby
+}
+
+// Promote makes a standby Snapshotter serve, publishing the last snapshots
+// it computed. It is a no-op if the Snapshotter already serves.
+func (s *Snapshotter) Promote(ctx context.Context) {
+	s.standbyLock.Lock()
+	defer s.standbyLock.Unlock()
+	if !s.standby {
+		return
+	}
+	s.standby = false
+	for cacheName, pending := range s.pendingSnapshots {
+		s.setAndTrackSnapshot(ctx, cacheName, pending.version, pending.resourcesByType)
+	}
+	s.pendingSnapshots = nil
+	s.logger.Infof("Snapshotter promoted from standby")
+}
+
+// restoreSnapshots publishes the snapshots of cacheNames last persisted in
+// EdgeDB, kept for Promote on a standby unless it computes newer ones first.
+func (s *Snapshotter) restoreSnapshots(ctx context.Context, client EdgeDBQuerier, cacheNames ...string) {
+	for _, cacheName := range cacheNames {
+		version, resources, err := s.restoreSnapshot(ctx, client, cacheName)
+		if err != nil {
+			s.logger.Errorf("Failed to restore the %s snapshot from EdgeDB: %v", cacheName, err)
+			continue
+		}
+		if version == "" {
+			continue
+		}
+		s.publish(ctx, cacheName, version, resourcesToMap(resources))
+		s.logger.Infof("Restored the %s snapshot version %s from EdgeDB", cacheName, version)
+	}
+}
+
+// publish sets the snapshot of cacheName, or keeps it for Promote on a standby.
+func (s *Snapshotter) publish(ctx context.Context, cacheName, version string, resourcesByType map[string][]types.Resource) {
+	s.standbyLock.Lock()
+	defer s.standbyLock.Unlock()
+	if s.standby {
+		if s.pendingSnapshots == nil {
+			s.pendingSnapshots = make(map[string]pendingSnapshot)
+		}
+		s.pendingSnapshots[cacheName] = pendingSnapshot{version: version, resourcesByType: resourcesByType}
+		return
+	}
+	s.setAndTrackSnapshot(ctx, cacheName, version, resourcesByType)
+}
+
+// setAndTrackSnapshot sets the snapshot of cacheName, recording the emit in
+// Health only once the cache accepted it.
+func (s *Snapshotter) setAndTrackSnapshot(ctx context.Context, cacheName, version string, resourcesByType map[string][]types.Resource) {
+	if err := s.setSnapshot(ctx, cacheName, version, resourcesByType); err != nil {
+		s.logger.Errorf("Failed to set the %s snapshot version %s: %v", cacheName, version, err)
+		return
+	}
+	s.trackEmit(cacheName, version)
+}
+
+// setSnapshot sets the snapshot of cacheName and notifies the services snapshot.
+func (s *Snapshotter) setSnapshot(ctx context.Context, cacheName, version string, resourcesByType map[string][]types.Resource) error {
+	switch cacheName {
+	case "services":
+		if err := s.setServicesSnapshot(ctx, version, resourcesByType); err != nil {
+			return err
+		}
+		for _, notify := range s.snapshotNotifiers {
+			notify()
+		}
+	case "endpoints", "runtime":
+		snapshot, err := s.newSnapshot(version, resourcesByType)
+		if err != nil {
+			panic(err)
+		}
+		target := s.endpointsCache
+		if cacheName == "runtime" {
+			target = s.runtimeCache
+		}
+		return target.SetSnapshot(ctx, "", snapshot)
+	}
+	return nil
+}
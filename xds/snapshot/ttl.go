@@ -0,0 +1,68 @@
+package snapshot
+
+import (
+	"time"
+
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/log"
+)
+
+// WithResourceTTL returns an option to publish the resources of typeURL with
+// a ttl, after which Envoy expires them unless the control plane heartbeats
+// them, so stale resources are removed when the control plane goes silent.
+// The caches heartbeat at half the smallest ttl. A ttl of zero or less
+// removes the ttl of typeURL.
+func WithResourceTTL(typeURL string, ttl time.Duration) Option {
+	return func(s *Snapshotter) {
+		if s.resourceTTLs == nil {
+			s.resourceTTLs = make(map[string]time.Duration)
+		}
+		if ttl <= 0 {
+			delete(s.resourceTTLs, typeURL)
+			return
+		}
+		s.resourceTTLs[typeURL] = ttl
+	}
+}
+
+// heartbeatInterval returns half the smallest resource ttl, zero without ttls.
+func (s *Snapshotter) heartbeatInterval() time.Duration {
+	var interval time.Duration
+	for _, ttl := range s.resourceTTLs {
+		if interval == 0 || ttl/2 < interval {
+			interval = ttl / 2
+		}
+	}
+	return interval
+}
+
+// newSnapshotCache returns a snapshot cache heartbeating the resources with a
+// ttl until the snapshotter is closed.
+func (s *Snapshotter) newSnapshotCache(hash cache.NodeHash, logger log.Logger) cache.SnapshotCache {
+	if interval := s.heartbeatInterval(); interval > 0 {
+		return cache.NewSnapshotCacheWithHeartbeating(s.dbContext, false, hash, logger, interval)
+	}
+	return cache.NewSnapshotCache(false, hash, logger)
+}
+
+// newSnapshot returns the snapshot of resourcesByType at version, with the
+// configured ttls.
+func (s *Snapshotter) newSnapshot(version string, resourcesByType map[string][]types.Resource) (*cache.Snapshot, error) {
+	if len(s.resourceTTLs) == 0 {
+		return cache.NewSnapshot(version, resourcesByType)
+	}
+	withTTLs := make(map[string][]types.ResourceWithTTL, len(resourcesByType))
+	for typeURL, resources := range resourcesByType {
+		var ttl *time.Duration
+		if d, ok := s.resourceTTLs[typeURL]; ok {
+			ttl = &d
+		}
+		items := make([]types.ResourceWithTTL, 0, len(resources))
+		for _, r := range resources {
+			items = append(items, types.ResourceWithTTL{Resource: r, TTL: ttl})
+		}
+		withTTLs[typeURL] = items
+	}
+	return cache.NewSnapshotWithTTLs(version, withTTLs)
+}
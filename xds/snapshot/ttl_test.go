@@ -0,0 +1,58 @@
+package snapshot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+)
+
+func TestResourceTTL(t *testing.T) {
+	resourcesByType := map[string][]types.Resource{
+		resource.ClusterType:  {&clusterv3.Cluster{Name: "web.default:http"}},
+		resource.ListenerType: {&listenerv3.Listener{Name: "web.default:http"}},
+	}
+	tests := []struct {
+		name          string
+		opts          []Option
+		wantCluster   time.Duration
+		wantListener  time.Duration
+		wantHeartbeat time.Duration
+	}{
+		{"disabled", nil, 0, 0, 0},
+		{"cluster", []Option{WithResourceTTL(resource.ClusterType, 30*time.Second)}, 30 * time.Second, 0, 15 * time.Second},
+		{"smallest heartbeat", []Option{WithResourceTTL(resource.ClusterType, 30*time.Second), WithResourceTTL(resource.ListenerType, 10*time.Second)}, 30 * time.Second, 10 * time.Second, 5 * time.Second},
+		{"removed", []Option{WithResourceTTL(resource.ClusterType, 30*time.Second), WithResourceTTL(resource.ClusterType, 0)}, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSnapshotter(tt.opts...)
+			defer s.Close()
+			if got := s.heartbeatInterval(); got != tt.wantHeartbeat {
+				t.Errorf("expected heartbeat interval %s, got %s", tt.wantHeartbeat, got)
+			}
+
+			s.setServicesSnapshot(context.Background(), "1", resourcesByType)
+			published, err := s.servicesCache.GetSnapshot("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			snapshot := published.(*cache.Snapshot)
+			for typ, want := range map[types.ResponseType]time.Duration{types.Cluster: tt.wantCluster, types.Listener: tt.wantListener} {
+				item := snapshot.Resources[typ].Items["web.default:http"]
+				var got time.Duration
+				if item.TTL != nil {
+					got = *item.TTL
+				}
+				if item.Resource == nil || got != want {
+					t.Errorf("type %d: expected ttl %s, got %s on %v", typ, want, got, item.Resource)
+				}
+			}
+		})
+	}
+}
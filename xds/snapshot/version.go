@@ -0,0 +1,23 @@
+package snapshot
+
+import "fmt"
+
+// nextVersion returns the snapshot version of cacheName for the source
+// version, the resource version of the emitted objects, prefixed by a fixed
+// width counter of the snapshots of cacheName. Resource versions are opaque
+// and may repeat or go back on a relist, the counter keeps the versions of a
+// cache strictly increasing, in string order too, for the life of the
+// Snapshotter.
+func (s *Snapshotter) nextVersion(cacheName, version string) string {
+	s.versionLock.Lock()
+	defer s.versionLock.Unlock()
+	if s.versionCounters == nil {
+		s.versionCounters = make(map[string]uint64)
+	}
+	s.versionCounters[cacheName]++
+	counter := fmt.Sprintf("%020d", s.versionCounters[cacheName])
+	if version == "" {
+		return counter
+	}
+	return counter + "-" + version
+}
@@ -0,0 +1,78 @@
+package snapshot
+
+import (
+	"context"
+	"testing"
+
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNextVersion(t *testing.T) {
+	s := newTestSnapshotter()
+	tests := []struct {
+		cache   string
+		version string
+		want    string
+	}{
+		{"services", "42", "00000000000000000001-42"},
+		{"services", "42", "00000000000000000002-42"},
+		{"services", "", "00000000000000000003"},
+		{"endpoints", "7", "00000000000000000001-7"},
+		{"services", "41", "00000000000000000004-41"},
+	}
+	for _, tt := range tests {
+		if got := s.nextVersion(tt.cache, tt.version); got != tt.want {
+			t.Errorf("%s at %q: expected %s, got %s", tt.cache, tt.version, tt.want, got)
+		}
+	}
+
+	// the versions order as strings past a power of ten
+	s.versionCounters["services"] = 8
+	previous := s.nextVersion("services", "900")
+	for i := 0; i < 3; i++ {
+		next := s.nextVersion("services", "10")
+		if next <= previous {
+			t.Errorf("expected version %s to sort after %s", next, previous)
+		}
+		previous = next
+	}
+}
+
+func TestEmitServicesMonotonicVersion(t *testing.T) {
+	s := newTestSnapshotter()
+	memdb, err := s.createMemDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loop := &servicesLoop{memdb: memdb, edgedb: &fakeEdgeDB{}, consul: &fakeConsul{}}
+	service := func(port int32) []*corev1.Service {
+		return []*corev1.Service{{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+			Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "http", Port: port}}},
+		}}
+	}
+
+	var last string
+	// resource versions repeating and going back, as on a relist
+	for i, version := range []string{"10", "10", "9", ""} {
+		s.emitServices(context.Background(), loop, version, service(int32(8080+i)))
+		snapshot, err := s.servicesCache.GetSnapshot("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := snapshot.GetVersion(resource.ClusterType)
+		if got <= last {
+			t.Errorf("expected version %q to sort after %q", got, last)
+		}
+		last = got
+	}
+
+	// an equivalent snapshot keeps its version
+	s.emitServices(context.Background(), loop, "11", service(8083))
+	snapshot, _ := s.servicesCache.GetSnapshot("")
+	if got := snapshot.GetVersion(resource.ClusterType); got != "00000000000000000004" {
+		t.Errorf("expected the equivalent snapshot not published, got version %q", got)
+	}
+}